@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+
+	utils "github.com/mrflynn/air-alert/internal"
+	"github.com/spf13/cobra"
+)
+
+var (
+	adminCmd = &cobra.Command{
+		Use:   "admin",
+		Short: "Manages access to the admin API",
+		Long:  "Utilities for managing access to the administrative API",
+	}
+
+	adminTokenCmd = &cobra.Command{
+		Use:   "token",
+		Short: "Generates a new admin API token",
+		Long: `Generates a new bearer token for the admin API along with its hash. Add the hash to
+web.admin.tokens in the configuration file and restart the server to grant access`,
+		RunE: generateAdminToken,
+	}
+)
+
+func init() {
+	adminCmd.AddCommand(adminTokenCmd)
+	rootCmd.AddCommand(adminCmd)
+}
+
+func generateAdminToken(cmd *cobra.Command, args []string) error {
+	token, err := utils.CreateToken(32)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Token: %s\n", token)
+	fmt.Printf("Hash:  %s\n", utils.HashToken(token))
+	return nil
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	apiKeysCmd = &cobra.Command{
+		Use:   "api-keys",
+		Short: "Manages API keys",
+		Long:  "Creates, lists, and revokes keys for the public data API",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return initDatabase()
+		},
+		PersistentPostRunE: func(cmd *cobra.Command, args []string) error {
+			return database.Shutdown()
+		},
+	}
+
+	apiKeysCreateCmd = &cobra.Command{
+		Use:   "create",
+		Short: "Creates a new API key",
+		Long: `Creates a new API key. The key is only displayed once since only a hash of it is
+stored`,
+		RunE: createAPIKey,
+	}
+
+	apiKeysListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "Lists all API keys",
+		RunE:  listAPIKeys,
+	}
+
+	apiKeysRevokeCmd = &cobra.Command{
+		Use:   "revoke <id>",
+		Short: "Revokes an API key",
+		Args:  cobra.ExactArgs(1),
+		RunE:  revokeAPIKey,
+	}
+)
+
+func init() {
+	apiKeysCreateCmd.Flags().StringP("name", "n", "", "name of the key owner")
+	apiKeysCreateCmd.Flags().IntP(
+		"rate-limit", "r", 0, "requests per minute allowed for this key (default is web.api.key_rate_limit)",
+	)
+	apiKeysCreateCmd.MarkFlagRequired("name")
+
+	apiKeysCmd.AddCommand(apiKeysCreateCmd, apiKeysListCmd, apiKeysRevokeCmd)
+	rootCmd.AddCommand(apiKeysCmd)
+}
+
+func createAPIKey(cmd *cobra.Command, args []string) error {
+	name, err := cmd.Flags().GetString("name")
+	if err != nil {
+		return err
+	}
+
+	rateLimit, err := cmd.Flags().GetInt("rate-limit")
+	if err != nil {
+		return err
+	} else if rateLimit < 0 {
+		return fmt.Errorf("rate limit must not be negative")
+	}
+
+	key, details, err := database.CreateAPIKey(cmd.Context(), name, rateLimit)
+	if err != nil {
+		return fmt.Errorf("could not create api key: %s", err)
+	}
+
+	fmt.Printf("Created API key %d for %s.\n", details.ID, details.Name)
+	fmt.Printf("Key: %s\n", key)
+	return nil
+}
+
+func listAPIKeys(cmd *cobra.Command, args []string) error {
+	keys, err := database.GetAllAPIKeys(cmd.Context())
+	if err != nil {
+		return fmt.Errorf("could not get api keys: %s", err)
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tNAME\tRATE LIMIT\tCREATED")
+
+	for _, k := range keys {
+		rateLimit := "default"
+		if k.RateLimit > 0 {
+			rateLimit = fmt.Sprintf("%d/min", k.RateLimit)
+		}
+
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\n", k.ID, k.Name, rateLimit, k.CreatedAt.Format(time.RFC3339))
+	}
+
+	return writer.Flush()
+}
+
+func revokeAPIKey(cmd *cobra.Command, args []string) error {
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid api key id: %s", args[0])
+	}
+
+	err = database.DeleteAPIKey(cmd.Context(), id)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("api key %d does not exist", id)
+	} else if err != nil {
+		return fmt.Errorf("could not revoke api key: %s", err)
+	}
+
+	fmt.Printf("Revoked API key %d.\n", id)
+	return nil
+}
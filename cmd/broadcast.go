@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mrflynn/air-alert/internal/geo"
+	"github.com/mrflynn/air-alert/internal/notifications"
+	"github.com/spf13/cobra"
+)
+
+var broadcastCmd = &cobra.Command{
+	Use:   "broadcast",
+	Short: "Sends a message to all subscribers or subscribers in an area",
+	Long: `Queues a custom message for every subscriber, or only those inside of a circle or polygon.
+Messages are delivered by the notification threads of the running server at the configured
+rate limit`,
+	Example: `  air-alert broadcast --all -m "Air Alert will be down for maintenance tonight"
+  air-alert broadcast --latitude 37.77 --longitude -122.42 --radius 5000 -m "Wildfire advisory"
+  air-alert broadcast --polygon "37.8,-122.5;37.8,-122.3;37.7,-122.3;37.7,-122.5" -m "Wildfire advisory"`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := initDatastore(); err != nil {
+			return err
+		}
+
+		return initDatabase()
+	},
+	RunE: broadcast,
+	PostRunE: func(cmd *cobra.Command, args []string) error {
+		if err := database.Shutdown(); err != nil {
+			return err
+		}
+
+		return datastore.Shutdown()
+	},
+}
+
+func init() {
+	broadcastCmd.Flags().StringP("message", "m", "", "message to send")
+	broadcastCmd.Flags().Bool("all", false, "send the message to every subscriber")
+	broadcastCmd.Flags().Float64("latitude", 0, "latitude of the center of the broadcast area")
+	broadcastCmd.Flags().Float64("longitude", 0, "longitude of the center of the broadcast area")
+	broadcastCmd.Flags().Float64("radius", 0, "radius of the broadcast area in meters")
+	broadcastCmd.Flags().String("polygon", "", `broadcast area as a list of "lat,lon" vertices separated by semicolons`)
+	broadcastCmd.MarkFlagRequired("message")
+
+	rootCmd.AddCommand(broadcastCmd)
+}
+
+func getBroadcastArea(cmd *cobra.Command) (geo.Area, error) {
+	flags := cmd.Flags()
+
+	all, _ := flags.GetBool("all")
+	polygon, _ := flags.GetString("polygon")
+	radius, _ := flags.GetFloat64("radius")
+
+	switch {
+	case all && (polygon != "" || radius != 0):
+		return nil, errors.New("--all cannot be combined with an area")
+	case polygon != "" && radius != 0:
+		return nil, errors.New("only one of --polygon or --radius can be provided")
+	case polygon != "":
+		return geo.ParsePolygon(polygon)
+	case radius != 0:
+		if !flags.Changed("latitude") || !flags.Changed("longitude") {
+			return nil, errors.New("--latitude and --longitude are required with --radius")
+		} else if radius < 0 {
+			return nil, errors.New("--radius must be positive")
+		}
+
+		latitude, _ := flags.GetFloat64("latitude")
+		longitude, _ := flags.GetFloat64("longitude")
+
+		if err := geo.ValidatePoint(longitude, latitude); err != nil {
+			return nil, err
+		}
+
+		return geo.Circle{
+			Point:  geo.Point{Latitude: latitude, Longitude: longitude},
+			Radius: radius,
+		}, nil
+	case all:
+		return nil, nil
+	default:
+		return nil, errors.New("one of --all, --radius, or --polygon must be provided")
+	}
+}
+
+func broadcast(cmd *cobra.Command, args []string) error {
+	area, err := getBroadcastArea(cmd)
+	if err != nil {
+		return err
+	}
+
+	message, err := cmd.Flags().GetString("message")
+	if err != nil {
+		return err
+	}
+
+	count, err := notifications.NewSender(datastore, database).Broadcast(cmd.Context(), message, area)
+	if err != nil {
+		return fmt.Errorf("could not queue broadcast: %s", err)
+	}
+
+	fmt.Printf("Queued message for %d subscribers.\n", count)
+	return nil
+}
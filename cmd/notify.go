@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mrflynn/air-alert/internal/notifications"
+	"github.com/spf13/cobra"
+)
+
+var (
+	notifyCmd = &cobra.Command{
+		Use:   "notify",
+		Short: "Manages web push notifications",
+		Long:  "Utilities for managing and debugging web push notifications",
+	}
+
+	notifyTestCmd = &cobra.Command{
+		Use:   "test",
+		Short: "Sends a test notification to a user",
+		Long: `Sends a sample notification to a subscribed user through the same delivery pipeline used
+for air quality alerts and reports the response from the user's push service`,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return initDatabase()
+		},
+		RunE: sendTestNotification,
+		PostRunE: func(cmd *cobra.Command, args []string) error {
+			return database.Shutdown()
+		},
+	}
+)
+
+func init() {
+	notifyTestCmd.Flags().IntP("user", "u", 0, "ID of the user to send the notification to")
+	notifyTestCmd.MarkFlagRequired("user")
+
+	notifyCmd.AddCommand(notifyTestCmd)
+	rootCmd.AddCommand(notifyCmd)
+}
+
+func sendTestNotification(cmd *cobra.Command, args []string) error {
+	id, err := cmd.Flags().GetInt("user")
+	if err != nil {
+		return err
+	}
+
+	user, err := database.GetUserWithID(cmd.Context(), id)
+	if err != nil {
+		return fmt.Errorf("could not get user %d: %s", id, err)
+	}
+
+	resp, err := notifications.NewSender(datastore, database).SendTest(cmd.Context(), user)
+	if err != nil {
+		return fmt.Errorf("could not reach push service: %s", err)
+	}
+
+	fmt.Printf("Push service responded with %d %s\n", resp.StatusCode, resp.Status)
+	if resp.Body != "" {
+		fmt.Println(resp.Body)
+	}
+
+	if !resp.Delivered() {
+		return fmt.Errorf("notification was not accepted by the push service")
+	}
+
+	return nil
+}
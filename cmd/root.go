@@ -9,11 +9,14 @@ import (
 	"strings"
 	"time"
 
+	utils "github.com/mrflynn/air-alert/internal"
 	"github.com/mrflynn/air-alert/internal/database/redis"
 	pg "github.com/mrflynn/air-alert/internal/database/sql"
+	"github.com/mrflynn/air-alert/internal/logging"
 	"github.com/mrflynn/air-alert/internal/notifications"
 	"github.com/mrflynn/air-alert/internal/router"
 	"github.com/mrflynn/air-alert/internal/task"
+	"github.com/mrflynn/air-alert/internal/tracing"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -30,6 +33,9 @@ var (
 	notifier   *notifications.Sender
 	server     *router.Router
 
+	// stopTracing exports any remaining spans.
+	stopTracing func(context.Context) error
+
 	rootCmd = &cobra.Command{
 		Use:   "air-alert",
 		Short: "A server for alerting people to air quality changes",
@@ -62,7 +68,7 @@ web push notifications`,
 func init() {
 	cobra.OnInitialize(initConfig)
 
-	rootCmd.Flags().StringVarP(
+	rootCmd.PersistentFlags().StringVarP(
 		&configFile, "config", "c", "", "configuration file (default is $PWD/config.toml)",
 	)
 	rootCmd.Flags().BoolP("skip-startup", "s", false, "skip startup tasks")
@@ -75,6 +81,7 @@ func init() {
 	viper.SetDefault("database.redis.addr", ":6379")
 	viper.SetDefault("database.redis.password", "")
 	viper.SetDefault("database.redis.id", 0)
+	viper.SetDefault("database.redis.ingest_batch_size", 500)
 
 	// Default postgres settings.
 	viper.SetDefault("database.postgres.host", "localhost")
@@ -88,6 +95,8 @@ func init() {
 	viper.SetDefault("web.addr", ":3000")
 	viper.SetDefault("web.template_dir", "./templates")
 	viper.SetDefault("web.static_dir", "./static")
+	viper.SetDefault("web.proxy.header", "X-Forwarded-For")
+	viper.SetDefault("web.proxy.trusted", []string{})
 
 	// SSL settings.
 	viper.SetDefault("web.ssl.enable", false)
@@ -96,20 +105,71 @@ func init() {
 
 	// Default notification settings.
 	viper.SetDefault("web.notifications.threads", 4)
+	viper.SetDefault("web.notifications.rate_limit", 25.0)
 	viper.SetDefault("web.notifications.group", "notification_delivery")
 	viper.SetDefault("web.notifications.public_key", "")
 	viper.SetDefault("web.notifications.private_key", "")
 	viper.SetDefault("web.notifications.admin_mail", "admin@localhost")
 
+	// Default API settings. Rate limits are in requests per minute.
+	viper.SetDefault("web.api.anonymous_rate_limit", 60)
+	viper.SetDefault("web.api.key_rate_limit", 600)
+	viper.SetDefault("web.api.key_cache_ttl", "1m")
+	viper.SetDefault("web.api.max_sensors", 1000)
+	viper.SetDefault("web.api.cluster_max_zoom", 12)
+	viper.SetDefault("web.websocket.max_connections", 1000)
+	viper.SetDefault("web.websocket.max_connections_per_ip", 10)
+	viper.SetDefault("web.websocket.max_subscriptions", 100)
+
+	// Default health check settings. The sensor map is refreshed daily and AQI data every five
+	// minutes.
+	viper.SetDefault("web.health.max_sensor_age", 48*time.Hour)
+	viper.SetDefault("web.health.max_aqi_age", 15*time.Minute)
+
+	// Default metrics settings.
+	viper.SetDefault("web.metrics.enable", true)
+	viper.SetDefault("web.metrics.addr", ":9090")
+
+	// Default admin settings.
+	viper.SetDefault("web.admin.tokens", []string{})
+
+	// Default tracing settings.
+	viper.SetDefault("tracing.exporter", tracing.ExporterNone)
+	viper.SetDefault("tracing.endpoint", "localhost:55680")
+	viper.SetDefault("tracing.insecure", true)
+	viper.SetDefault("tracing.service_name", "air-alert")
+	viper.SetDefault("tracing.sample_ratio", 1.0)
+
+	// Default task schedules.
+	viper.SetDefault("tasks.startup_policy", task.StartupFailFast)
+	viper.SetDefault("tasks.history_size", 100)
+	viper.SetDefault("tasks.update_aqi.schedule", "5m")
+	viper.SetDefault("tasks.update_aqi.retries", 2)
+	viper.SetDefault("tasks.update_aqi.backoff", 10*time.Second)
+	viper.SetDefault("tasks.update_aqi.breaker_threshold", 5)
+	viper.SetDefault("tasks.update_aqi.breaker_cooldown", 15*time.Minute)
+	viper.SetDefault("tasks.update_sensors.schedule", "0 30 3 * * *")
+	viper.SetDefault("tasks.update_sensors.retries", 3)
+	viper.SetDefault("tasks.update_sensors.backoff", 30*time.Second)
+	viper.SetDefault("tasks.generate_notifications.schedule", "5m")
+	viper.SetDefault("tasks.generate_notifications.workers", 8)
+	viper.SetDefault("tasks.generate_notifications.page_size", 1000)
+	viper.SetDefault("tasks.leader_election.enable", true)
+	viper.SetDefault("tasks.leader_election.ttl", 15*time.Second)
+
+	// Default AQI cache settings.
+	viper.SetDefault("aqi_cache.geohash_precision", 6)
+
 	// Other default settings.
 	viper.SetDefault("timezone", "UTC")
 	viper.SetDefault("purpleair.url", "https://www.purpleair.com/json")
 	viper.SetDefault("purpleair.rate_limit_timeout", 10*time.Second)
 
-	log.SetFormatter(&log.TextFormatter{
-		FullTimestamp:          true,
-		DisableLevelTruncation: true,
-	})
+	// Default logging settings. These also apply to messages logged before the configuration file is
+	// read.
+	viper.SetDefault("log.level", "info")
+	viper.SetDefault("log.format", logging.FormatText)
+	logging.Configure(viper.GetString("log.level"), viper.GetString("log.format"))
 }
 
 func initConfig() {
@@ -132,23 +192,14 @@ func initConfig() {
 			log.Fatal(err)
 		}
 	}
-}
-
-func initApp() error {
-	var err error
-
-	datastore, err = redis.NewController()
-	if err != nil {
-		return err
-	}
 
-	taskRunner, err = task.NewRunner()
-	if err != nil {
-		return err
+	if err := logging.Configure(viper.GetString("log.level"), viper.GetString("log.format")); err != nil {
+		log.Fatalf("invalid logging configuration: %s", err)
 	}
+}
 
-	var dbConn *sql.DB
-	dbConn, err = sql.Open(
+func initDatabase() error {
+	dbConn, err := sql.Open(
 		"postgres",
 		fmt.Sprintf(
 			"dbname=%s user=%s password=%s host=%s port=%d sslmode=%s",
@@ -166,53 +217,149 @@ func initApp() error {
 	}
 
 	database, err = pg.NewController(dbConn)
+	return err
+}
+
+func initApp() error {
+	var err error
+
+	if precision := viper.GetInt("aqi_cache.geohash_precision"); precision < 1 || precision > 12 {
+		return fmt.Errorf("aqi cache geohash precision must be between 1 and 12, got %d", precision)
+	}
+
+	stopTracing, err = tracing.Init()
 	if err != nil {
 		return err
 	}
 
-	notifier = notifications.NewSender(datastore, database)
+	if err := initDatastore(); err != nil {
+		return err
+	}
 
-	server = router.NewRouter(datastore, database)
+	taskRunner, err = task.NewRunner()
+	if err != nil {
+		return err
+	}
 
-	return nil
-}
+	if viper.GetBool("tasks.leader_election.enable") {
+		if err := initLeaderElection(); err != nil {
+			return err
+		}
+	}
 
-func initTasks() error {
-	var err error
+	if err := initTaskState(); err != nil {
+		return err
+	}
 
-	// Air quality refresh task.
-	err = taskRunner.AddTask(task.MinuteTask{
-		Rate:     5,
-		Priority: 2,
-		TTL:      60 * time.Second,
-		RunFunc:  updateAQITask,
-	})
+	err = initDatabase()
 	if err != nil {
 		return err
 	}
 
-	// Sensor location refresh task.
-	err = taskRunner.AddTask(task.DailyTask{
-		TimeOfDay: "03:30",
-		Priority:  1,
-		TTL:       60 * time.Second,
-		RunFunc:   updateSensorsTask,
-	})
+	notifier = notifications.NewSender(datastore, database)
+
+	server, err = router.NewRouter(datastore, database, notifier, taskRunner)
+
+	return err
+}
+
+// initDatastore connects to Redis. Data derived from AQI data is cached according to the schedule
+// of the update-aqi task.
+func initDatastore() error {
+	interval, err := task.ConfiguredInterval("update-aqi")
 	if err != nil {
 		return err
 	}
 
-	// Notification stream task.
-	err = taskRunner.AddTask(task.MinuteTask{
-		Rate:     5,
-		Priority: 3,
-		TTL:      120 * time.Second,
-		RunFunc:  generateNotifications,
-	})
+	datastore, err = redis.NewController(redis.WithAQIRefreshInterval(interval))
+
+	return err
+}
+
+// initLeaderElection makes the task runner only run tasks while this server holds the leader
+// lease. The lease holder is unique to this process, so that a restarted server doesn't take over
+// the lease of its previous run.
+func initLeaderElection() error {
+	ttl := viper.GetDuration("tasks.leader_election.ttl")
+	if ttl < time.Second {
+		return fmt.Errorf("leader election ttl must be at least 1 second, got %s", ttl)
+	}
+
+	hostname, err := os.Hostname()
 	if err != nil {
 		return err
 	}
 
+	holder := hostname + ":" + utils.CreateRandomString(8)
+	taskRunner.UseLease(datastore.NewLeaderLease(holder, ttl), ttl)
+
+	return nil
+}
+
+// taskHistory stores the runs of the task runner in the task history in Redis.
+type taskHistory struct {
+	store *redis.TaskHistory
+}
+
+func (h taskHistory) AddRun(ctx context.Context, run task.Run) error {
+	return h.store.AddRun(ctx, redis.TaskRun(run))
+}
+
+func (h taskHistory) Runs(ctx context.Context, name string, count int64) ([]task.Run, error) {
+	stored, err := h.store.Runs(ctx, name, count)
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make([]task.Run, 0, len(stored))
+	for _, run := range stored {
+		runs = append(runs, task.Run(run))
+	}
+
+	return runs, nil
+}
+
+// initTaskState makes the task runner store the most recent runs of every task, which tasks are
+// paused and tasks triggered for the leader in Redis, where they are shared with other servers.
+func initTaskState() error {
+	size := viper.GetInt64("tasks.history_size")
+	if size < 1 {
+		return fmt.Errorf("task history size must be at least 1, got %d", size)
+	}
+
+	taskRunner.UseHistory(taskHistory{store: datastore.NewTaskHistory(size)})
+	taskRunner.UsePauseStore(datastore.NewPausedTasks())
+	taskRunner.UseTriggerQueue(datastore.NewTaskTriggers())
+
+	return nil
+}
+
+func initTasks() error {
+	tasks := []struct {
+		name      string
+		dependsOn []string
+		ttl       time.Duration
+		run       func(context.Context) error
+	}{
+		// Air quality refresh task.
+		{"update-aqi", []string{"update-sensors"}, 60 * time.Second, updateAQITask},
+		// Sensor location refresh task.
+		{"update-sensors", nil, 60 * time.Second, updateSensorsTask},
+		// Notification stream task.
+		{"generate-notifications", []string{"update-aqi"}, 120 * time.Second, generateNotifications},
+	}
+
+	for _, t := range tasks {
+		configured, err := task.FromConfig(t.name, t.dependsOn, t.ttl, t.run)
+		if err != nil {
+			return err
+		}
+
+		if err := taskRunner.AddTask(configured); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -242,7 +389,7 @@ func shutdown(cmd *cobra.Command, args []string) {
 	defer cancel()
 
 	hasShutdown := make(chan bool, 1)
-	errs := make(chan error, 3)
+	errs := make(chan error, 4)
 
 	go func() {
 		if err := server.Shutdown(); err != nil {
@@ -261,6 +408,10 @@ func shutdown(cmd *cobra.Command, args []string) {
 			errs <- err
 		}
 
+		if err := stopTracing(shutdownCtx); err != nil {
+			errs <- err
+		}
+
 		hasShutdown <- true
 	}()
 
@@ -2,35 +2,65 @@ package cmd
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"math"
 	"sort"
+	"sync"
 	"time"
 
 	utils "github.com/mrflynn/air-alert/internal"
 	"github.com/mrflynn/air-alert/internal/database/redis"
+	pg "github.com/mrflynn/air-alert/internal/database/sql"
+	"github.com/mrflynn/air-alert/internal/geo"
+	"github.com/mrflynn/air-alert/internal/logging"
+	"github.com/mrflynn/air-alert/internal/metrics"
 	"github.com/mrflynn/air-alert/internal/purpleapi"
+	"github.com/mrflynn/air-alert/internal/task"
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 )
 
 func updateAQITask(ctx context.Context) error {
-	log.Info("starting AQI data refresh")
+	logger := logging.FromContext(ctx)
+	logger.Info("starting AQI data refresh")
 
 	resp, err := purpleapi.Get(ctx)
 	if err != nil {
 		return err
 	}
 
-	err = datastore.SetAirQuality(ctx, resp)
+	stored, stats, err := datastore.SetAirQuality(ctx, resp)
+
+	// Readings that were stored before a failure are already served, so live AQI streams are told
+	// about them either way. Nothing has changed for them if there were no new readings.
+	if len(stored) > 0 {
+		// Live AQI streams only miss an update if this fails, so the error is only logged.
+		if err := datastore.PublishAQIUpdate(ctx, stored); err != nil {
+			logger.Errorf("could not publish aqi update: %s", err)
+		}
+	}
+
 	if err != nil {
 		return err
 	}
 
-	log.Info("completed AQI data refresh")
+	metrics.SensorsIngested.WithLabelValues("readings").Add(float64(stats.New))
+	metrics.SensorsSkipped.WithLabelValues(metrics.SkipUnchanged).Add(float64(stats.Unchanged))
+	metrics.SensorsSkipped.WithLabelValues(metrics.SkipRejected).Add(float64(stats.Rejected))
+
+	logger.WithFields(log.Fields{
+		"new":       stats.New,
+		"unchanged": stats.Unchanged,
+		"rejected":  stats.Rejected,
+	}).Info("completed AQI data refresh")
+
 	return nil
 }
 
 func updateSensorsTask(ctx context.Context) error {
-	log.Info("starting sensor location refresh")
+	logger := logging.FromContext(ctx)
+	logger.Info("starting sensor location refresh")
 
 	resp, err := purpleapi.Get(ctx)
 	if err != nil {
@@ -42,16 +72,10 @@ func updateSensorsTask(ctx context.Context) error {
 		return err
 	}
 
-	log.Info("completed sensor location refresh")
-	return nil
-}
-
-type coordinatePair [2]float64
+	metrics.SensorsIngested.WithLabelValues("locations").Add(float64(len(resp)))
 
-type forecastCacheItem struct {
-	aqi       float64
-	forecast  redis.AQIForecast
-	crossover time.Time
+	logger.Info("completed sensor location refresh")
+	return nil
 }
 
 // This function finds the point (if it exists) where the measured AQI passes the user's
@@ -82,101 +106,247 @@ func findCrossover(data []*redis.RawQualityData, threshold float64) (bool, time.
 	return false, time.Time{}
 }
 
-func generateNotifications(ctx context.Context) error {
-	log.Info("starting notification generator task")
+// newestCrossover returns the newest time that the readings of any of the sensors crossed the
+// threshold, or the zero time if none of them did. Readings must be sorted newest first.
+func newestCrossover(sensors []*redis.RawSensorData, threshold float64) time.Time {
+	var crossover time.Time
 
-	users, err := database.GetAllUsers(ctx)
-	if err != nil {
-		return err
+	for _, sensor := range sensors {
+		if ok, newCrossover := findCrossover(sensor.Data, threshold); ok && newCrossover.After(crossover) {
+			crossover = newCrossover
+		}
 	}
 
-	cache := make(map[coordinatePair]forecastCacheItem, len(users))
+	return crossover
+}
+
+// userCell is a geohash cell and the users inside of it.
+type userCell struct {
+	hash  string
+	users []pg.UserRequest
+}
+
+// groupByCell groups users by the geohash cell of their location, in order of the geohashes.
+func groupByCell(users []pg.UserRequest, precision int) []userCell {
+	cellMap := make(map[string][]pg.UserRequest)
 	for _, user := range users {
-		var (
-			// aqi and aqiDiff are running averages of all AQI readings and the absolute difference in
-			// readings across 1 hour.
-			aqi       float64
-			aqiDiff   float64
-			forecast  redis.AQIForecast
-			crossover time.Time
-		)
-
-		// If we've already calculated the AQI, forecast, and crossover time for the given coordinates,
-		// then we can reuse the previous results to speed up certain calculations. This will speed up
-		// notification delivery where multiple users are in the same geographic area.
-		if res, ok := cache[coordinatePair{user.Longitude, user.Latitude}]; ok {
-			aqi = res.aqi
-			forecast = res.forecast
-			crossover = res.crossover
-		} else {
-			sensors, err := datastore.GetAQIFromSensorsInRange(ctx, user.Longitude, user.Latitude, 2000)
-			if err != nil {
-				log.Errorf("could not get sensors: %s", err)
+		hash := geo.Geohash(user.Longitude, user.Latitude, precision)
+		cellMap[hash] = append(cellMap[hash], user)
+	}
 
-				continue
-			}
+	cells := make([]userCell, 0, len(cellMap))
+	for hash, cellUsers := range cellMap {
+		cells = append(cells, userCell{hash, cellUsers})
+	}
 
-			var prevCount int
-			for _, sensor := range sensors {
-				// Want to sort in descending order.
-				sort.Slice(sensor.Data, func(i, j int) bool {
-					return sensor.Data[i].Time > sensor.Data[j].Time
-				})
-
-				if ok, newCrossover := findCrossover(sensor.Data, user.AQIThreshold); ok {
-					aqi = utils.RecalculateAverage(sensor.Data[0].AQI, aqi, prevCount)
-					aqiDiff = utils.RecalculateAverage(
-						sensor.Data[0].AQI-sensor.Data[len(sensor.Data)-1].AQI, aqiDiff, prevCount,
-					)
-
-					// Take the newest time.
-					if newCrossover.After(crossover) {
-						crossover = newCrossover
-					}
-
-					prevCount++
-				}
-			}
+	sort.Slice(cells, func(i, j int) bool {
+		return cells[i].hash < cells[j].hash
+	})
 
-			// We only want to consider a forecast as changing if the difference between AQI
-			// values over 1 hour is > 10.
-			if aqiDiff > 10 {
-				forecast = redis.AQIIncreasing
-			} else if aqiDiff < -10 {
-				forecast = redis.AQIDecreasing
-			} else {
-				forecast = redis.AQIStatic
-			}
+	return cells
+}
+
+// errLostLeadership is returned when the notification generator stops because another server has
+// become the task leader.
+var errLostLeadership = errors.New("lost task leadership while generating notifications")
+
+// notificationRadius is the distance in meters around users that sensors are taken into account.
+const notificationRadius = 2000.0
+
+// notifyCell creates notifications for the users in the cell whose threshold has been crossed
+// since they were last notified, and returns their new crossover times. If the notification radius
+// covers the cell, the air quality of the cell is used for all of its users and shared with the
+// HTTP API through the cell cache. Otherwise, it is computed for each user. An error is returned
+// if the notifications couldn't be added to the stream, which stops the run.
+func notifyCell(ctx context.Context, cell userCell, token int64) ([]pg.CrossoverUpdate, error) {
+	logger := logging.FromContext(ctx).WithField(logging.FieldGeohash, cell.hash)
+
+	covers, err := redis.CellCoversRadius(cell.hash, notificationRadius)
+	if err != nil {
+		logger.Errorf("could not get cell size: %s", err)
+		return nil, nil
+	}
 
-			cache[coordinatePair{user.Longitude, user.Latitude}] = forecastCacheItem{
-				aqi, forecast, crossover,
+	var cellAQI redis.CellAQI
+	if covers {
+		cellAQI, err = datastore.GetCellAQI(ctx, cell.hash, notificationRadius)
+		if err != nil {
+			logger.Errorf("could not get cell aqi: %s", err)
+			return nil, nil
+		}
+	}
+
+	var (
+		notifications []redis.NotificationStream
+		updates       []pg.CrossoverUpdate
+	)
+
+	for _, user := range cell.users {
+		userAQI := cellAQI
+		if !covers {
+			userAQI, err = datastore.GetPointAQI(ctx, user.Longitude, user.Latitude, notificationRadius)
+			if err != nil {
+				logger.WithField(logging.FieldUserID, user.ID).Errorf("could not get aqi: %s", err)
+				continue
 			}
 		}
 
+		// Only send notifications if the forecast is changing.
+		if userAQI.Forecast == redis.AQIStatic {
+			continue
+		}
+
+		crossover := newestCrossover(userAQI.Sensors, user.AQIThreshold)
+
 		var oldCrossover time.Time
 		if user.LastCrossover.Valid {
 			oldCrossover = user.LastCrossover.Time
 		}
 
-		// Only send a notification if forecast is changing and a new crossover point has been found.
-		if forecast != redis.AQIStatic && crossover.After(oldCrossover) {
-			// Store new computed crossover time.
-			if err := database.UpdateCrossoverTime(ctx, user.ID, crossover); err != nil {
-				log.Errorf("could not update crossover time: %s", err)
+		// Only send a notification if a new crossover point has been found.
+		if crossover.After(oldCrossover) {
+			notifications = append(notifications, redis.NotificationStream{
+				UID:      user.ID,
+				AQI:      userAQI.AQI,
+				Forecast: userAQI.Forecast,
+			})
+			updates = append(updates, pg.CrossoverUpdate{ID: user.ID, Crossover: crossover})
+		}
+	}
+
+	if len(notifications) == 0 {
+		return nil, nil
+	}
+
+	// The notifications are added first, so that a leader which has lost its lease stops before
+	// storing crossover times that would keep the new leader from notifying the users.
+	err = datastore.AddToNotificationStreamFenced(ctx, token, notifications...)
+	if err == redis.ErrStaleFencingToken {
+		return nil, errLostLeadership
+	} else if err != nil {
+		// Nobody would be notified, so the crossover times mustn't be stored either.
+		return nil, fmt.Errorf("could not push notifications: %s", err)
+	}
+
+	logger.Debugf("created %d notifications", len(notifications))
+	return updates, nil
+}
+
+// streamUserCells reads all users in pages and sends the users of each page to cells grouped by
+// geohash cell. It closes cells when it returns.
+func streamUserCells(ctx context.Context, pageSize, precision int, cells chan<- userCell) error {
+	defer close(cells)
+
+	lastID := 0
+	for {
+		users, err := database.GetUsersAfter(ctx, lastID, pageSize)
+		if err != nil {
+			return err
+		} else if len(users) == 0 {
+			return nil
+		}
+
+		lastID = users[len(users)-1].ID
+
+		for _, cell := range groupByCell(users, precision) {
+			select {
+			case cells <- cell:
+			case <-ctx.Done():
+				return ctx.Err()
 			}
+		}
 
-			if err := datastore.AddToNotificationStream(ctx, redis.NotificationStream{
-				UID:      user.ID,
-				AQI:      aqi,
-				Forecast: forecast,
-			}); err != nil {
-				log.Errorf("could not push notification: %s", err)
+		if len(users) < pageSize {
+			return nil
+		}
+	}
+}
+
+// generateNotifications evaluates the air quality near every user and creates a notification for
+// each user whose threshold has been crossed. Users are read in pages and grouped by geohash cell,
+// so that nearby users share the cached air quality of their cell, and cells are evaluated by a
+// pool of workers. The new crossover times of all notified users are stored at the end of the run.
+func generateNotifications(ctx context.Context) error {
+	logger := logging.FromContext(ctx)
+	logger.Info("starting notification generator task")
+
+	workers := viper.GetInt("tasks.generate_notifications.workers")
+	pageSize := viper.GetInt("tasks.generate_notifications.page_size")
+	precision := viper.GetInt("aqi_cache.geohash_precision")
+
+	if workers < 1 || pageSize < 1 {
+		return errors.New("workers and page size must be at least 1")
+	}
+
+	// Notifications are only fenced when the task is run by the leader.
+	token, _ := task.FencingToken(ctx)
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		updates  []pg.CrossoverUpdate
+		firstErr error
+	)
+
+	cells := make(chan userCell, workers)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for cell := range cells {
+				// Cells are still drained after a failure so that the users aren't read for nothing.
+				if ctx.Err() != nil {
+					continue
+				}
+
+				cellUpdates, err := notifyCell(ctx, cell, token)
+
+				mu.Lock()
+				updates = append(updates, cellUpdates...)
+				if err != nil && firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
 			}
+		}()
+	}
+
+	err := streamUserCells(ctx, pageSize, precision, cells)
+	wg.Wait()
+
+	if firstErr != nil {
+		err = firstErr
+	} else if err == nil {
+		// Stop as soon as the run times out or is cancelled.
+		err = parent.Err()
+	}
+
+	// Crossover times are stored even if the run failed, since their users have been notified.
+	if len(updates) > 0 {
+		updateCtx := parent
+		if parent.Err() != nil {
+			var cancelUpdate context.CancelFunc
+			updateCtx, cancelUpdate = context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancelUpdate()
+		}
 
-			log.Debugf("updated crossover time and created notification for user: %d", user.ID)
+		if updateErr := database.UpdateCrossoverTimes(updateCtx, updates); updateErr != nil {
+			logger.Errorf("could not update crossover times: %s", updateErr)
 		}
 	}
 
-	log.Info("stopping notification generator task")
+	if err != nil {
+		return err
+	}
+
+	logger.WithField("notifications", len(updates)).Info("stopping notification generator task")
 	return nil
 }
@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mrflynn/air-alert/internal/task"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	tasksCmd = &cobra.Command{
+		Use:   "tasks",
+		Short: "Shows and controls background tasks",
+		Long: `Shows the schedules of background tasks and their most recent runs on every server that
+shares the Redis database, and runs, pauses, or resumes tasks through the admin API of a running
+server`,
+	}
+
+	tasksListCmd = &cobra.Command{
+		Use:      "list",
+		Short:    "Lists all tasks and their last run",
+		PreRunE:  initTasksCLI,
+		RunE:     listTasks,
+		PostRunE: shutdownTasksCLI,
+	}
+
+	tasksHistoryCmd = &cobra.Command{
+		Use:      "history <name>",
+		Short:    "Lists the most recent runs of a task",
+		Args:     cobra.ExactArgs(1),
+		PreRunE:  initTasksCLI,
+		RunE:     showTaskHistory,
+		PostRunE: shutdownTasksCLI,
+	}
+
+	tasksRunCmd = &cobra.Command{
+		Use:   "run <name>",
+		Short: "Runs a task on the server right away",
+		Long: `Asks the running server to run a task right away, even if it is paused or isn't the
+task leader`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return postTaskAction(cmd, args[0], "run", "Triggered")
+		},
+	}
+
+	tasksPauseCmd = &cobra.Command{
+		Use:   "pause <name>",
+		Short: "Pauses a task on every server",
+		Long:  "Stops a task from running on its schedule or at startup until it is resumed",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return postTaskAction(cmd, args[0], "pause", "Paused")
+		},
+	}
+
+	tasksResumeCmd = &cobra.Command{
+		Use:   "resume <name>",
+		Short: "Resumes a paused task",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return postTaskAction(cmd, args[0], "resume", "Resumed")
+		},
+	}
+)
+
+func init() {
+	tasksHistoryCmd.Flags().Int64P("count", "n", 20, "number of runs to show")
+
+	for _, c := range []*cobra.Command{tasksRunCmd, tasksPauseCmd, tasksResumeCmd} {
+		c.Flags().String("server", "", "URL of the server (default is derived from web.addr and web.ssl)")
+		c.Flags().String("token", "", "admin API token (default is $AIR_ALERT_ADMIN_TOKEN)")
+	}
+
+	tasksCmd.AddCommand(tasksListCmd, tasksHistoryCmd, tasksRunCmd, tasksPauseCmd, tasksResumeCmd)
+	rootCmd.AddCommand(tasksCmd)
+}
+
+// initTasksCLI creates a task runner with the configured tasks that reads their state from Redis.
+// The runner is never started.
+func initTasksCLI(cmd *cobra.Command, args []string) error {
+	var err error
+
+	if err := initDatastore(); err != nil {
+		return err
+	}
+
+	taskRunner, err = task.NewRunner()
+	if err != nil {
+		return err
+	}
+
+	if err := initTaskState(); err != nil {
+		return err
+	}
+
+	return initTasks()
+}
+
+func shutdownTasksCLI(cmd *cobra.Command, args []string) error {
+	return datastore.Shutdown()
+}
+
+// serverURL returns the URL that the server configured in web.addr and web.ssl can be reached at
+// from this machine.
+func serverURL() string {
+	if viper.GetBool("web.ssl.enable") {
+		if domains := viper.GetStringSlice("web.ssl.domains"); len(domains) > 0 && domains[0] != "" {
+			return "https://" + domains[0]
+		}
+	}
+
+	host, port, err := net.SplitHostPort(viper.GetString("web.addr"))
+	if err != nil {
+		return "http://localhost:3000"
+	}
+
+	if host == "" || host == "0.0.0.0" {
+		host = "localhost"
+	}
+
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// postTaskAction sends a task action to the admin API of the server.
+func postTaskAction(cmd *cobra.Command, name, action, done string) error {
+	server, _ := cmd.Flags().GetString("server")
+	if server == "" {
+		server = serverURL()
+	}
+
+	token, _ := cmd.Flags().GetString("token")
+	if token == "" {
+		token = os.Getenv("AIR_ALERT_ADMIN_TOKEN")
+	}
+
+	if token == "" {
+		return errors.New("an admin token is required. pass --token or set AIR_ALERT_ADMIN_TOKEN")
+	}
+
+	endpoint := strings.TrimSuffix(server, "/") + "/admin/tasks/" + url.PathEscape(name) + "/" + action
+
+	req, err := http.NewRequestWithContext(cmd.Context(), http.MethodPost, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not reach server: %s", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("server responded with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	fmt.Printf("%s task %s.\n", done, name)
+	return nil
+}
+
+func formatRun(run task.Run) string {
+	return fmt.Sprintf(
+		"%s\t%s\t%s\t%.1fs\t%d\t%s",
+		run.Start.Local().Format(time.RFC3339), run.Trigger, run.Outcome, run.Duration, run.Attempts, run.Error,
+	)
+}
+
+func listTasks(cmd *cobra.Command, args []string) error {
+	statuses, err := taskRunner.Status(cmd.Context())
+	if err != nil {
+		return fmt.Errorf("could not get task status: %s", err)
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "NAME\tSCHEDULE\tPAUSED\tLAST RUN\tTRIGGER\tOUTCOME\tDURATION\tATTEMPTS\tERROR")
+
+	for _, status := range statuses {
+		lastRun := "never\t\t\t\t\t"
+		if status.LastRun != nil {
+			lastRun = formatRun(*status.LastRun)
+		}
+
+		fmt.Fprintf(writer, "%s\t%s\t%t\t%s\n", status.Name, status.Schedule, status.Paused, lastRun)
+	}
+
+	return writer.Flush()
+}
+
+func showTaskHistory(cmd *cobra.Command, args []string) error {
+	count, err := cmd.Flags().GetInt64("count")
+	if err != nil {
+		return err
+	} else if count < 1 {
+		return fmt.Errorf("count must be at least 1")
+	}
+
+	runs, err := taskRunner.History(cmd.Context(), args[0], count)
+	if err == task.ErrTaskNotFound {
+		return fmt.Errorf("task %s does not exist", args[0])
+	} else if err != nil {
+		return fmt.Errorf("could not get task history: %s", err)
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "STARTED\tTRIGGER\tOUTCOME\tDURATION\tATTEMPTS\tERROR")
+
+	for _, run := range runs {
+		fmt.Fprintln(writer, formatRun(run))
+	}
+
+	return writer.Flush()
+}
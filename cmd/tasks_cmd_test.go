@@ -0,0 +1,36 @@
+// +build unit
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestServerURL(t *testing.T) {
+	defer viper.Reset()
+
+	tests := []struct {
+		addr     string
+		ssl      bool
+		domains  []string
+		expected string
+	}{
+		{":3000", false, nil, "http://localhost:3000"},
+		{"0.0.0.0:8080", false, nil, "http://localhost:8080"},
+		{"10.0.0.2:3000", false, nil, "http://10.0.0.2:3000"},
+		{":443", true, []string{"airalert.app"}, "https://airalert.app"},
+		{":3000", true, []string{""}, "http://localhost:3000"},
+	}
+
+	for _, test := range tests {
+		viper.Set("web.addr", test.addr)
+		viper.Set("web.ssl.enable", test.ssl)
+		viper.Set("web.ssl.domains", test.domains)
+
+		if url := serverURL(); url != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.addr, test.expected, url)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/mrflynn/air-alert/internal/database/redis"
+	pg "github.com/mrflynn/air-alert/internal/database/sql"
 )
 
 func TestFindCrossover(t *testing.T) {
@@ -57,3 +58,40 @@ func TestFindCrossover(t *testing.T) {
 		t.Errorf("expected time to be 0, got %d", crossoverAbove.Unix())
 	}
 }
+
+func TestNewestCrossover(t *testing.T) {
+	sensors := []*redis.RawSensorData{
+		{ID: 1, Data: []*redis.RawQualityData{{Time: 20, AQI: 80}, {Time: 10, AQI: 60}, {Time: 0, AQI: 40}}},
+		{ID: 2, Data: []*redis.RawQualityData{{Time: 20, AQI: 70}, {Time: 10, AQI: 45}, {Time: 0, AQI: 30}}},
+		{ID: 3, Data: []*redis.RawQualityData{{Time: 20, AQI: 10}, {Time: 0, AQI: 10}}},
+	}
+
+	if crossover := newestCrossover(sensors, 50); !time.Unix(10, 0).Equal(crossover) {
+		t.Errorf("expected crossover time to be 10, got %d", crossover.Unix())
+	}
+
+	if crossover := newestCrossover(sensors, 100); !crossover.IsZero() {
+		t.Errorf("expected no crossover, got %d", crossover.Unix())
+	}
+}
+
+func TestGroupByCell(t *testing.T) {
+	users := []pg.UserRequest{
+		{ID: 1, Longitude: -122.4194, Latitude: 37.7749},
+		{ID: 2, Longitude: 10.40744, Latitude: 57.64911},
+		{ID: 3, Longitude: -122.4195, Latitude: 37.7750},
+	}
+
+	cells := groupByCell(users, 6)
+	if len(cells) != 2 {
+		t.Fatalf("expected 2 cells, got %d", len(cells))
+	}
+
+	if cells[0].hash != "9q8yyk" || len(cells[0].users) != 2 {
+		t.Errorf("expected users 1 and 3 in cell 9q8yyk, got %+v", cells[0])
+	}
+
+	if cells[1].hash != "u4pruy" || len(cells[1].users) != 1 || cells[1].users[0].ID != 2 {
+		t.Errorf("expected user 2 in cell u4pruy, got %+v", cells[1])
+	}
+}
@@ -1,4 +1,5 @@
 drop table if exists users;
+drop table if exists api_keys;
 
 create table users (
     id serial not null primary key,
@@ -9,4 +10,12 @@ create table users (
     latitude double precision not null,
     threshold double precision not null,
     last_crossover timestamp with time zone
-);
\ No newline at end of file
+);
+
+create table api_keys (
+    id serial not null primary key,
+    name text not null,
+    key_hash text not null unique,
+    rate_limit integer not null default 0,
+    created_at timestamp with time zone not null default now()
+);
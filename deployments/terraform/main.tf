@@ -341,6 +341,11 @@ resource "kubernetes_deployment" "air_alert_app" {
         labels = {
           "app" = "air-alert"
         }
+        annotations = {
+          "prometheus.io/scrape" = "true"
+          "prometheus.io/port" = "9090"
+          "prometheus.io/path" = "/metrics"
+        }
       }
       spec {
         container {
@@ -350,6 +355,11 @@ resource "kubernetes_deployment" "air_alert_app" {
           port {
             container_port = 3000
           }
+          // Metrics are scraped from the pod directly and aren't part of the service.
+          port {
+            name = "metrics"
+            container_port = 9090
+          }
           env {
             name = "AIR_ALERT_DATABASE_POSTGRES_HOST"
             value_from {
@@ -395,6 +405,27 @@ resource "kubernetes_deployment" "air_alert_app" {
               }
             }
           }
+          env {
+            name = "AIR_ALERT_WEB_PROXY_TRUSTED"
+            value = var.pod_cidr
+          }
+          liveness_probe {
+            http_get {
+              path = "/healthz"
+              port = 3000
+            }
+            initial_delay_seconds = 10
+            period_seconds = 10
+          }
+          readiness_probe {
+            http_get {
+              path = "/readyz"
+              port = 3000
+            }
+            initial_delay_seconds = 10
+            period_seconds = 30
+            timeout_seconds = 5
+          }
           volume_mount {
             mount_path = "/config"
             name = "config-volume"
@@ -5,6 +5,12 @@ variable "default_ttl" {
   default = 60
 }
 
+// Pod network of the cluster, which the nginx ingress controller forwards requests from.
+variable "pod_cidr" {
+  type = string
+  default = "10.244.0.0/16"
+}
+
 data "kubernetes_secret" "airalert_secrets" {
   metadata {
     name = "airalert-secrets"
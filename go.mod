@@ -5,9 +5,10 @@ go 1.15
 require (
 	github.com/DATA-DOG/go-sqlmock v1.5.0 // indirect
 	github.com/SherClockHolmes/webpush-go v1.1.2
+	github.com/alicebob/miniredis/v2 v2.13.3
+	github.com/fasthttp/websocket v1.4.3
 	github.com/friendsofgo/errors v0.9.2
 	github.com/fsnotify/fsnotify v1.4.9 // indirect
-	github.com/go-co-op/gocron v0.3.0
 	github.com/go-redis/redis/v8 v8.0.0-beta.8
 	github.com/gofiber/fiber/v2 v2.0.2
 	github.com/gofiber/template v1.6.2
@@ -19,17 +20,24 @@ require (
 	github.com/mitchellh/mapstructure v1.3.3 // indirect
 	github.com/mrflynn/go-aqi v0.0.9
 	github.com/pelletier/go-toml v1.8.1 // indirect
+	github.com/prometheus/client_golang v1.7.0
+	github.com/prometheus/client_model v0.2.0
+	github.com/robfig/cron/v3 v3.0.0
 	github.com/shopspring/decimal v1.2.0
 	github.com/sirupsen/logrus v1.6.0
 	github.com/spf13/afero v1.3.5 // indirect
 	github.com/spf13/cobra v1.0.0
 	github.com/spf13/jwalterweatherman v1.1.0 // indirect
 	github.com/spf13/viper v1.7.1
+	github.com/valyala/fasthttp v1.16.0
 	github.com/volatiletech/null/v8 v8.1.0
 	github.com/volatiletech/randomize v0.0.1
 	github.com/volatiletech/sqlboiler/v4 v4.2.0
 	github.com/volatiletech/strmangle v0.0.1
-	go.opentelemetry.io/otel v0.11.0 // indirect
+	go.opentelemetry.io/otel v0.11.0
+	go.opentelemetry.io/otel/exporters/otlp v0.11.0
+	go.opentelemetry.io/otel/exporters/stdout v0.11.0
+	go.opentelemetry.io/otel/sdk v0.11.0
 	golang.org/x/crypto v0.0.0-20200820211705-5c72a883971a
 	golang.org/x/time v0.0.0-20200630173020-3af7569d3a1e
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
@@ -0,0 +1,178 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/go-redis/redis/v8"
+	utils "github.com/mrflynn/air-alert/internal"
+	"github.com/mrflynn/air-alert/internal/geo"
+	"github.com/mrflynn/air-alert/internal/logging"
+)
+
+const (
+	cellAQIKey = "aqi:cell"
+	// cellRadiusStep is the distance in meters that the radius around a cell is rounded to, so that
+	// similar radii share the same cached air quality.
+	cellRadiusStep = 100.0
+	// maxAQIRadius is the largest radius in meters that sensors are searched in.
+	maxAQIRadius = 100000.0
+)
+
+// CellAQI is the air quality of a geohash cell, computed from the sensors around its center.
+type CellAQI struct {
+	// AQI is the average of the most recent readings of all sensors.
+	AQI float64 `json:"aqi"`
+	// Readings is the number of sensors that contributed to the average.
+	Readings int         `json:"readings"`
+	Forecast AQIForecast `json:"forecast"`
+	// Sensors holds the readings of every sensor, newest first.
+	Sensors []*RawSensorData `json:"sensors"`
+}
+
+// quantizeRadius rounds radius to a multiple of cellRadiusStep between cellRadiusStep and
+// maxAQIRadius.
+func quantizeRadius(radius float64) float64 {
+	radius = math.Round(radius/cellRadiusStep) * cellRadiusStep
+	return math.Min(math.Max(radius, cellRadiusStep), maxAQIRadius)
+}
+
+// CellCoversRadius returns whether the air quality within radius meters of the center of the cell
+// can be used for every point in it, which is the case if the radius is at least the size of the
+// cell.
+func CellCoversRadius(hash string, radius float64) (bool, error) {
+	bounds, err := geo.GeohashBounds(hash)
+	if err != nil {
+		return false, err
+	}
+
+	size := geo.Distance(bounds.MinLongitude, bounds.MinLatitude, bounds.MaxLongitude, bounds.MaxLatitude)
+
+	return radius >= size, nil
+}
+
+func createCellAQIKey(generation int64, hash string, radius float64) string {
+	return fmt.Sprintf("%s:%d:%s:%g", cellAQIKey, generation, hash, radius)
+}
+
+// newCellAQI computes the air quality of a cell from the readings of the sensors around it. The
+// readings of each sensor are sorted newest first.
+func newCellAQI(sensors []*RawSensorData) CellAQI {
+	cell := CellAQI{Sensors: sensors}
+
+	// aqiDiff is a running average of the difference in readings across 1 hour.
+	var aqiDiff float64
+
+	for _, sensor := range sensors {
+		// Want to sort in descending order.
+		sort.Slice(sensor.Data, func(i, j int) bool {
+			return sensor.Data[i].Time > sensor.Data[j].Time
+		})
+
+		if len(sensor.Data) == 0 || utils.IsNil(sensor.Data[0].AQI) {
+			continue
+		}
+
+		cell.AQI = utils.RecalculateAverage(sensor.Data[0].AQI, cell.AQI, cell.Readings)
+		aqiDiff = utils.RecalculateAverage(
+			sensor.Data[0].AQI-sensor.Data[len(sensor.Data)-1].AQI, aqiDiff, cell.Readings,
+		)
+
+		cell.Readings++
+	}
+
+	// We only want to consider a forecast as changing if the difference between AQI
+	// values over 1 hour is > 10.
+	if aqiDiff > 10 {
+		cell.Forecast = AQIIncreasing
+	} else if aqiDiff < -10 {
+		cell.Forecast = AQIDecreasing
+	} else {
+		cell.Forecast = AQIStatic
+	}
+
+	return cell
+}
+
+// GetPointAQI returns the air quality computed from the sensors within radius meters of a point.
+// Radii larger than maxAQIRadius are reduced to it. Results aren't cached.
+func (c *Controller) GetPointAQI(ctx context.Context, longitude, latitude, radius float64) (CellAQI, error) {
+	sensors, err := c.GetAQIFromSensorsInRange(ctx, longitude, latitude, math.Min(radius, maxAQIRadius))
+	if err != nil {
+		return CellAQI{}, err
+	}
+
+	return newCellAQI(sensors), nil
+}
+
+// GetAQIAround returns the air quality computed from the sensors within radius meters of a point.
+// If the radius covers the geohash cell of the point at the given precision, the air quality of
+// the cell is used instead, so that nearby points share the same cached result. The radius is
+// rounded the same way as in GetCellAQI, so that it can't be rounded below the size of the cell.
+func (c *Controller) GetAQIAround(ctx context.Context, longitude, latitude, radius float64, precision int) (CellAQI, error) {
+	hash := geo.Geohash(longitude, latitude, precision)
+	radius = quantizeRadius(radius)
+
+	covers, err := CellCoversRadius(hash, radius)
+	if err != nil {
+		return CellAQI{}, err
+	} else if !covers {
+		return c.GetPointAQI(ctx, longitude, latitude, radius)
+	}
+
+	return c.GetCellAQI(ctx, hash, radius)
+}
+
+// GetCellAQI returns the air quality of the geohash cell, computed from the sensors within radius
+// meters of its center. The radius is rounded to a multiple of 100 meters between 100 meters and
+// 100 km. Results are cached in Redis until new AQI data is stored, so that requests and
+// notifications for nearby locations don't have to compute them again.
+func (c *Controller) GetCellAQI(ctx context.Context, hash string, radius float64) (CellAQI, error) {
+	logger := logging.FromContext(ctx).WithField(logging.FieldGeohash, hash)
+
+	bounds, err := geo.GeohashBounds(hash)
+	if err != nil {
+		return CellAQI{}, err
+	}
+
+	generation, err := c.GetAQIGeneration(ctx)
+	if err != nil {
+		return CellAQI{}, err
+	}
+
+	radius = quantizeRadius(radius)
+	key := createCellAQIKey(generation, hash, radius)
+
+	// Computing the air quality again is slower but still works, so cache failures are only logged.
+	cached, err := c.db.Get(ctx, key).Bytes()
+	if err == nil {
+		var cell CellAQI
+		decodeErr := json.Unmarshal(cached, &cell)
+		if decodeErr == nil {
+			return cell, nil
+		}
+
+		logger.Errorf("could not decode cached cell aqi: %s", decodeErr)
+	} else if err != redis.Nil {
+		logger.Errorf("could not get cell aqi from cache: %s", err)
+	}
+
+	center := bounds.Center()
+	sensors, err := c.GetAQIFromSensorsInRange(ctx, center.Longitude, center.Latitude, radius)
+	if err != nil {
+		return CellAQI{}, err
+	}
+
+	cell := newCellAQI(sensors)
+
+	encoded, err := json.Marshal(cell)
+	if err != nil {
+		logger.Errorf("could not encode cell aqi: %s", err)
+	} else if err := c.db.Set(ctx, key, encoded, c.aqiCacheTTL()).Err(); err != nil {
+		logger.Errorf("could not cache cell aqi: %s", err)
+	}
+
+	return cell, nil
+}
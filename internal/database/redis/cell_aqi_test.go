@@ -0,0 +1,146 @@
+// +build unit
+
+package redis
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrflynn/air-alert/internal/geo"
+	"github.com/mrflynn/air-alert/internal/purpleapi"
+)
+
+func TestNewCellAQI(t *testing.T) {
+	cell := newCellAQI([]*RawSensorData{
+		{ID: 1, Data: []*RawQualityData{{Time: 0, AQI: 40}, {Time: 20, AQI: 80}, {Time: 10, AQI: 60}}},
+		{ID: 2, Data: []*RawQualityData{{Time: 20, AQI: 70}, {Time: 0, AQI: 30}}},
+		// Sensors without readings don't count towards the average.
+		{ID: 3, Data: []*RawQualityData{}},
+	})
+
+	if cell.AQI != 75 || cell.Readings != 2 {
+		t.Errorf("expected AQI of 75 from 2 sensors, got %f from %d", cell.AQI, cell.Readings)
+	}
+
+	if cell.Forecast != AQIIncreasing {
+		t.Errorf("expected forecast to be increasing, got %v", cell.Forecast)
+	}
+
+	if newest := cell.Sensors[0].Data[0].Time; newest != 20 {
+		t.Errorf("expected readings to be sorted newest first, got %d first", newest)
+	}
+
+	if cell := newCellAQI(nil); cell.Forecast != AQIStatic || cell.Readings != 0 {
+		t.Errorf("expected static forecast without sensors, got %+v", cell)
+	}
+}
+
+func TestCreateCellAQIKey(t *testing.T) {
+	if key := createCellAQIKey(3, "9q8yyk", 2000); key != "aqi:cell:3:9q8yyk:2000" {
+		t.Errorf("got unexpected key %s", key)
+	}
+}
+
+func TestQuantizeRadius(t *testing.T) {
+	tests := []struct {
+		radius, expected float64
+	}{
+		{2000, 2000},
+		{2049.99, 2000},
+		{2050, 2100},
+		{0, cellRadiusStep},
+		{-500, cellRadiusStep},
+		{1e12, maxAQIRadius},
+	}
+
+	for _, test := range tests {
+		if radius := quantizeRadius(test.radius); radius != test.expected {
+			t.Errorf("%g: expected %g, got %g", test.radius, test.expected, radius)
+		}
+	}
+}
+
+func TestCellCoversRadius(t *testing.T) {
+	// Cells with 6 characters are about 1.2 by 0.6 km.
+	tests := []struct {
+		radius float64
+		covers bool
+	}{
+		{2000, true},
+		{500, false},
+	}
+
+	for _, test := range tests {
+		covers, err := CellCoversRadius("9q8yyk", test.radius)
+		if err != nil {
+			t.Fatalf("got unexpected error: %s", err)
+		}
+
+		if covers != test.covers {
+			t.Errorf("%g: expected covers to be %t, got %t", test.radius, test.covers, covers)
+		}
+	}
+
+	if _, err := CellCoversRadius("invalid!", 2000); err == nil {
+		t.Error("expected error for invalid geohash")
+	}
+}
+
+func TestGetAQIAround(t *testing.T) {
+	c, server := createTestController(t)
+	ctx := context.Background()
+
+	now := time.Now().Unix()
+	data := []purpleapi.Response{
+		{ID: 1, Location: purpleapi.Outside, LastUpdated: now, Latitude: 37.7, Longitude: -122.4, PM25: 10},
+		{ID: 2, Location: purpleapi.Outside, LastUpdated: now, Latitude: 37.703, Longitude: -122.4, PM25: 30},
+	}
+
+	if err := c.SetSensorLocationData(ctx, data); err != nil {
+		t.Fatalf("could not store sensor locations: %s", err)
+	}
+
+	if _, _, err := c.SetAirQuality(ctx, data); err != nil {
+		t.Fatalf("could not store readings: %s", err)
+	}
+
+	// The radius doesn't cover the cell, so only the sensor at the point itself is used.
+	aqi, err := c.GetAQIAround(ctx, -122.4, 37.7, 100, 6)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if aqi.Readings != 1 || aqi.Sensors[0].ID != 1 {
+		t.Errorf("expected only sensor 1 to be used, got %+v", aqi)
+	}
+
+	for _, key := range server.Keys() {
+		if strings.HasPrefix(key, cellAQIKey) {
+			t.Errorf("expected air quality of the point not to be cached, got %s", key)
+		}
+	}
+
+	// Similar radii share the cached air quality of the cell.
+	for _, radius := range []float64{2000, 2049.99} {
+		aqi, err = c.GetAQIAround(ctx, -122.4, 37.7, radius, 6)
+		if err != nil {
+			t.Fatalf("got unexpected error: %s", err)
+		}
+
+		if aqi.Readings != 2 {
+			t.Errorf("%g: expected both sensors to be used, got %+v", radius, aqi)
+		}
+	}
+
+	generation, err := c.GetAQIGeneration(ctx)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	key := createCellAQIKey(generation, geo.Geohash(-122.4, 37.7, 6), 2000)
+	if !server.Exists(key) {
+		t.Errorf("expected air quality of the cell to be cached at %s, got keys %v", key, server.Keys())
+	}
+}
@@ -0,0 +1,43 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// aqiUpdatedKey and sensorsUpdatedKey hold the Unix time at which AQI data and the sensor map
+	// were last stored successfully.
+	aqiUpdatedKey     = "aqi:updated"
+	sensorsUpdatedKey = "sensors:updated"
+)
+
+// Ping checks that the Redis datastore is reachable.
+func (c *Controller) Ping(ctx context.Context) error {
+	return c.db.Ping(ctx).Err()
+}
+
+func (c *Controller) getUpdateTime(ctx context.Context, key string) (time.Time, error) {
+	updated, err := c.db.Get(ctx, key).Int64()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(updated, 0), nil
+}
+
+// GetLastAQIUpdate returns the time at which AQI data was last stored. The zero time is returned
+// if it has never been stored.
+func (c *Controller) GetLastAQIUpdate(ctx context.Context) (time.Time, error) {
+	return c.getUpdateTime(ctx, aqiUpdatedKey)
+}
+
+// GetLastSensorUpdate returns the time at which the sensor map was last stored. The zero time is
+// returned if it has never been stored.
+func (c *Controller) GetLastSensorUpdate(ctx context.Context) (time.Time, error) {
+	return c.getUpdateTime(ctx, sensorsUpdatedKey)
+}
@@ -0,0 +1,249 @@
+package redis
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/mrflynn/air-alert/internal/purpleapi"
+	"github.com/mrflynn/go-aqi"
+)
+
+const (
+	// lastSeenKey is a hash of the time that each sensor last reported a reading, by the ID of the
+	// sensor in Purple Air.
+	lastSeenKey = "sensors:last_seen"
+	// readingsMaxAge is how long readings are kept.
+	readingsMaxAge = 60 * time.Minute
+)
+
+// IngestStats counts what happened to the readings of outside sensors when AQI data was stored.
+type IngestStats struct {
+	// New is the number of sensors with a reading that hadn't been stored before.
+	New int `json:"new"`
+	// Unchanged is the number of sensors that haven't reported a reading since the last update.
+	Unchanged int `json:"unchanged"`
+	// Rejected is the number of sensors with an invalid ID or a reading that is missing its time or
+	// is too old to be kept.
+	Rejected int `json:"rejected"`
+}
+
+// ingestReading is a new reading and the ID that it is stored under, which is the ID of the parent
+// sensor for secondary channels.
+type ingestReading struct {
+	id   int
+	resp purpleapi.Response
+}
+
+// selectNewReadings returns the readings of outside sensors whose last update is newer than the one
+// in lastSeen, which may be missing for sensors that have never been seen. Readings older than
+// cutoff are rejected.
+func selectNewReadings(data []purpleapi.Response, lastSeen map[int]int64, cutoff int64) ([]ingestReading, IngestStats) {
+	var stats IngestStats
+	readings := make([]ingestReading, 0, len(data))
+
+	for _, resp := range data {
+		// We only want sensors that are outside.
+		if resp.Location != purpleapi.Outside {
+			continue
+		}
+
+		id, err := getPrimaryKey(reflect.ValueOf(resp))
+		if err != nil || resp.LastUpdated <= cutoff {
+			stats.Rejected++
+			continue
+		}
+
+		if seen, ok := lastSeen[resp.ID]; ok && resp.LastUpdated <= seen {
+			stats.Unchanged++
+			continue
+		}
+
+		readings = append(readings, ingestReading{id, resp})
+		stats.New++
+	}
+
+	return readings, stats
+}
+
+// getLastSeen returns the time that each of the outside sensors last reported a reading, if they
+// have been seen before. Sensors are looked up in batches of batchSize.
+func (c *Controller) getLastSeen(ctx context.Context, data []purpleapi.Response, batchSize int) (map[int]int64, error) {
+	ids := make([]string, 0, len(data))
+	for _, resp := range data {
+		if resp.Location == purpleapi.Outside {
+			ids = append(ids, strconv.Itoa(resp.ID))
+		}
+	}
+
+	lastSeen := make(map[int]int64, len(ids))
+
+	for start := 0; start < len(ids); start += batchSize {
+		end := start + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		values, err := c.db.HMGet(ctx, lastSeenKey, ids[start:end]...).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for i, value := range values {
+			s, ok := value.(string)
+			if !ok {
+				continue
+			}
+
+			if seen, err := strconv.ParseInt(s, 10, 64); err == nil {
+				id, _ := strconv.Atoi(ids[start+i])
+				lastSeen[id] = seen
+			}
+		}
+	}
+
+	return lastSeen, nil
+}
+
+// addReadingToPipe adds the commands that store a reading to the pipeline and returns them. The
+// last seen time isn't part of them, since the commands of a pipeline run even if earlier ones
+// fail.
+func addReadingToPipe(ctx context.Context, pipe redis.Pipeliner, reading ingestReading, cutoff string) []redis.Cmder {
+	resp := reading.resp
+
+	pm25Key := createRedisKey(reading.id, "data", "pm25")
+	aqiKey := createRedisKey(reading.id, "data", "aqi")
+
+	// Add pm2.5 value with score being equal to reading capture time.
+	cmds := []redis.Cmder{
+		pipe.ZAddNX(ctx, pm25Key, &redis.Z{
+			Score:  float64(resp.LastUpdated),
+			Member: resp.PM25,
+		}),
+	}
+
+	// Add calculated AQI if the result is valid.
+	if aqi, err := aqi.Calculate(aqi.PM25{Concentration: resp.PM25}); err == nil {
+		cmds = append(cmds, pipe.ZAddNX(ctx, aqiKey, &redis.Z{
+			Score:  float64(resp.LastUpdated),
+			Member: aqi.AQI,
+		}))
+	}
+
+	// This removes all measurements older than 60 minutes. Sensors that stop reporting aren't
+	// written to anymore, so their readings expire once the newest one is too old to be kept.
+	expiry := time.Unix(resp.LastUpdated, 0).Add(readingsMaxAge)
+
+	return append(cmds,
+		pipe.ZRemRangeByScore(ctx, pm25Key, "0", cutoff),
+		pipe.ZRemRangeByScore(ctx, aqiKey, "0", cutoff),
+		pipe.ExpireAt(ctx, pm25Key, expiry),
+		pipe.ExpireAt(ctx, aqiKey, expiry),
+	)
+}
+
+// storeReadings stores a batch of readings and returns the ones whose commands all succeeded.
+// Their last seen time is only updated afterwards, so that readings that failed are stored again by
+// the next update.
+func (c *Controller) storeReadings(ctx context.Context, readings []ingestReading, cutoff string) ([]purpleapi.Response, error) {
+	cmds := make([][]redis.Cmder, len(readings))
+
+	// The error of the pipeline is that of the first failed command, which is checked per reading.
+	_, pipeErr := c.db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, reading := range readings {
+			cmds[i] = addReadingToPipe(ctx, pipe, reading, cutoff)
+		}
+
+		return nil
+	})
+
+	stored := make([]purpleapi.Response, 0, len(readings))
+	lastSeen := make([]interface{}, 0, 2*len(readings))
+
+	for i, reading := range readings {
+		if !commandsSucceeded(cmds[i]) {
+			continue
+		}
+
+		stored = append(stored, reading.resp)
+		lastSeen = append(lastSeen, strconv.Itoa(reading.resp.ID), reading.resp.LastUpdated)
+	}
+
+	if len(lastSeen) > 0 {
+		if err := c.db.HSet(ctx, lastSeenKey, lastSeen...).Err(); err != nil {
+			return stored, err
+		}
+	}
+
+	return stored, pipeErr
+}
+
+func commandsSucceeded(cmds []redis.Cmder) bool {
+	for _, cmd := range cmds {
+		if cmd.Err() != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SetAirQuality takes an array of Purple Air API response structs and stores the most recent
+// PM2.5 AQI readings from those sensors, returning the readings that were stored. Only sensors
+// that have reported a new reading since the last update are written, in pipelines of at most
+// database.redis.ingest_batch_size sensors. Batches aren't transactions, so a failed update may
+// have stored some of the readings, which are returned along with the error and skipped by the
+// next update. Data derived from the previous readings, such as tiles, is invalidated whenever a
+// batch was written.
+func (c *Controller) SetAirQuality(ctx context.Context, data []purpleapi.Response) ([]purpleapi.Response, IngestStats, error) {
+	cutoffTime := time.Now().Add(-readingsMaxAge).Unix()
+	cutoff := strconv.FormatInt(cutoffTime, 10)
+
+	lastSeen, err := c.getLastSeen(ctx, data, c.ingestBatchSize)
+	if err != nil {
+		return nil, IngestStats{}, err
+	}
+
+	readings, stats := selectNewReadings(data, lastSeen, cutoffTime)
+	stored := make([]purpleapi.Response, 0, len(readings))
+
+	var written bool
+	for start := 0; start < len(readings); start += c.ingestBatchSize {
+		end := start + c.ingestBatchSize
+		if end > len(readings) {
+			end = len(readings)
+		}
+
+		// Commands of a failed batch may still have been applied, so it counts as written too.
+		written = true
+
+		var batch []purpleapi.Response
+		batch, err = c.storeReadings(ctx, readings[start:end], cutoff)
+		stored = append(stored, batch...)
+
+		if err != nil {
+			break
+		}
+	}
+
+	_, pipeErr := c.db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		// Invalidate everything that was derived from the previous data.
+		if written {
+			pipe.Incr(ctx, aqiGenerationKey)
+		}
+
+		// The data is only up to date if every batch was stored.
+		if err == nil {
+			pipe.Set(ctx, aqiUpdatedKey, time.Now().Unix(), 0)
+		}
+
+		return nil
+	})
+	if err == nil {
+		err = pipeErr
+	}
+
+	return stored, stats, err
+}
@@ -0,0 +1,136 @@
+// +build unit
+
+package redis
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mrflynn/air-alert/internal/purpleapi"
+)
+
+func TestSelectNewReadings(t *testing.T) {
+	data := []purpleapi.Response{
+		// New sensor.
+		{ID: 1, Location: purpleapi.Outside, LastUpdated: 200, PM25: 10},
+		// Secondary channel of sensor 1, which is stored under the ID of its parent.
+		{ID: 2, ParentID: 1, Location: purpleapi.Outside, LastUpdated: 200, PM25: 11},
+		// Updated since it was last seen.
+		{ID: 3, Location: purpleapi.Outside, LastUpdated: 300, PM25: 12},
+		// Not updated since it was last seen.
+		{ID: 4, Location: purpleapi.Outside, LastUpdated: 200, PM25: 13},
+		// Missing time and too old.
+		{ID: 5, Location: purpleapi.Outside, PM25: 14},
+		{ID: 6, Location: purpleapi.Outside, LastUpdated: 100, PM25: 15},
+		// Inside sensors are ignored.
+		{ID: 7, Location: purpleapi.Inside, LastUpdated: 200, PM25: 16},
+	}
+
+	readings, stats := selectNewReadings(data, map[int]int64{3: 200, 4: 200}, 100)
+
+	expectedStats := IngestStats{New: 3, Unchanged: 1, Rejected: 2}
+	if stats != expectedStats {
+		t.Errorf("expected stats %+v, got %+v", expectedStats, stats)
+	}
+
+	ids := make([]int, 0, len(readings))
+	for _, reading := range readings {
+		ids = append(ids, reading.id)
+	}
+
+	if expected := []int{1, 1, 3}; !cmp.Equal(ids, expected) {
+		t.Errorf("expected readings to be stored under %v, got %v", expected, ids)
+	}
+}
+
+func TestSetAirQuality(t *testing.T) {
+	c, server := createTestController(t)
+	ctx := context.Background()
+
+	now := time.Now().Unix()
+	data := []purpleapi.Response{
+		{ID: 1, Location: purpleapi.Outside, LastUpdated: now, PM25: 10},
+		{ID: 2, Location: purpleapi.Outside, LastUpdated: now, PM25: 20},
+		{ID: 3, Location: purpleapi.Outside, LastUpdated: now, PM25: 30},
+	}
+
+	storedIDs := func(stored []purpleapi.Response) []int {
+		ids := make([]int, 0, len(stored))
+		for _, resp := range stored {
+			ids = append(ids, resp.ID)
+		}
+
+		return ids
+	}
+
+	// Readings can't be added to a string, so the second batch fails.
+	brokenKey := createRedisKey(3, "data", "pm25")
+	server.Set(brokenKey, "broken")
+
+	stored, stats, err := c.SetAirQuality(ctx, data)
+	if err == nil {
+		t.Fatal("expected error from failed batch")
+	}
+
+	if expected := (IngestStats{New: 3}); stats != expected {
+		t.Errorf("expected stats %+v, got %+v", expected, stats)
+	}
+
+	if expected := []int{1, 2}; !cmp.Equal(storedIDs(stored), expected) {
+		t.Errorf("expected readings of sensors %v to be stored, got %v", expected, storedIDs(stored))
+	}
+
+	for _, id := range []int{1, 2} {
+		if seen := server.HGet(lastSeenKey, strconv.Itoa(id)); seen != strconv.FormatInt(now, 10) {
+			t.Errorf("expected sensor %d to be seen at %d, got %q", id, now, seen)
+		}
+	}
+
+	if seen := server.HGet(lastSeenKey, "3"); seen != "" {
+		t.Errorf("expected failed reading not to be marked as seen, got %q", seen)
+	}
+
+	if generation, err := c.GetAQIGeneration(ctx); err != nil || generation != 1 {
+		t.Errorf("expected generation to be bumped after the first batch was written, got %d (%v)", generation, err)
+	}
+
+	if server.Exists(aqiUpdatedKey) {
+		t.Error("expected update time not to be set after a failure")
+	}
+
+	// Only the failed reading is stored again.
+	server.Del(brokenKey)
+
+	stored, stats, err = c.SetAirQuality(ctx, data)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if expected := (IngestStats{New: 1, Unchanged: 2}); stats != expected {
+		t.Errorf("expected stats %+v, got %+v", expected, stats)
+	}
+
+	if expected := []int{3}; !cmp.Equal(storedIDs(stored), expected) {
+		t.Errorf("expected readings of sensors %v to be stored, got %v", expected, storedIDs(stored))
+	}
+
+	if generation, err := c.GetAQIGeneration(ctx); err != nil || generation != 2 {
+		t.Errorf("expected generation 2, got %d (%v)", generation, err)
+	}
+
+	if !server.Exists(aqiUpdatedKey) {
+		t.Error("expected update time to be set")
+	}
+
+	// Nothing is invalidated if there are no new readings.
+	if stored, _, err = c.SetAirQuality(ctx, data); err != nil || len(stored) != 0 {
+		t.Errorf("expected no readings to be stored, got %v (%v)", storedIDs(stored), err)
+	}
+
+	if generation, err := c.GetAQIGeneration(ctx); err != nil || generation != 2 {
+		t.Errorf("expected generation to stay at 2, got %d (%v)", generation, err)
+	}
+}
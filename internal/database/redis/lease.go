@@ -0,0 +1,130 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	leaderLeaseKey   = "leader:lease"
+	fencingTokenKey  = "leader:fencing_token"
+	leaseRenewedFlag = 1
+)
+
+// ErrStaleFencingToken is returned by fenced writes when the lease has been acquired by someone
+// else since the fencing token was handed out.
+var ErrStaleFencingToken = errors.New("fencing token is stale")
+
+var (
+	// acquireLeaseScript sets the lease to the holder if nobody holds it and hands out a new
+	// fencing token. A holder that already has the lease extends it and keeps its token. It returns
+	// 0 if the lease is held by someone else.
+	acquireLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return tonumber(redis.call("GET", KEYS[2]))
+end
+
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+
+return 0
+`)
+
+	// renewLeaseScript extends the lease if it is still held by the holder.
+	renewLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+
+return 0
+`)
+
+	// releaseLeaseScript deletes the lease if it is still held by the holder.
+	releaseLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+
+return 0
+`)
+)
+
+// Lease is a lock on the leadership of background tasks that is held by at most one server at a
+// time. It expires unless it is renewed, so that another server can take over if the leader dies.
+// Every acquisition hands out a larger fencing token, which writes can use to reject a leader
+// that has lost the lease without noticing.
+type Lease struct {
+	db     *redis.Client
+	holder string
+	ttl    time.Duration
+}
+
+// NewLeaderLease creates a lease on task leadership for holder, which must be unique to this
+// server.
+func (c *Controller) NewLeaderLease(holder string, ttl time.Duration) *Lease {
+	return &Lease{
+		db:     c.db,
+		holder: holder,
+		ttl:    ttl,
+	}
+}
+
+// Acquire tries to take the lease and returns its fencing token, or 0 if the lease is held by
+// someone else.
+func (l *Lease) Acquire(ctx context.Context) (int64, error) {
+	return acquireLeaseScript.Run(
+		ctx, l.db, []string{leaderLeaseKey, fencingTokenKey}, l.holder, l.ttl.Milliseconds(),
+	).Int64()
+}
+
+// Renew extends the lease by its TTL. It returns false if the lease has expired or is held by
+// someone else.
+func (l *Lease) Renew(ctx context.Context) (bool, error) {
+	renewed, err := renewLeaseScript.Run(ctx, l.db, []string{leaderLeaseKey}, l.holder, l.ttl.Milliseconds()).Int64()
+	if err != nil {
+		return false, err
+	}
+
+	return renewed == leaseRenewedFlag, nil
+}
+
+// Release gives up the lease so that another server can take it over without waiting for it to
+// expire. Nothing happens if the lease isn't held by this holder.
+func (l *Lease) Release(ctx context.Context) error {
+	return releaseLeaseScript.Run(ctx, l.db, []string{leaderLeaseKey}, l.holder).Err()
+}
+
+// fenced runs fn in a transaction that only commits if token is still the latest fencing token.
+// Writes are not fenced if token is 0.
+func (c *Controller) fenced(ctx context.Context, token int64, fn func(redis.Pipeliner) error) error {
+	if token == 0 {
+		_, err := c.db.TxPipelined(ctx, fn)
+		return err
+	}
+
+	err := c.db.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.Get(ctx, fencingTokenKey).Int64()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+
+		if current != token {
+			return ErrStaleFencingToken
+		}
+
+		_, err = tx.TxPipelined(ctx, fn)
+		return err
+	}, fencingTokenKey)
+
+	// The token changed between reading and writing it.
+	if err == redis.TxFailedErr {
+		return ErrStaleFencingToken
+	}
+
+	return err
+}
@@ -0,0 +1,108 @@
+package redis
+
+import (
+	"context"
+	"io"
+	"sort"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/mrflynn/air-alert/internal/geo"
+	"github.com/mrflynn/air-alert/internal/purpleapi"
+	log "github.com/sirupsen/logrus"
+)
+
+const aqiUpdateChannel = "aqi:updates"
+
+// AQIUpdatePrecision is the number of geohash characters of the cells in an AQIUpdate. Cells with
+// 4 characters are about 39 by 20 km.
+const AQIUpdatePrecision = 4
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// AQIUpdate announces that new AQI data has been stored.
+type AQIUpdate struct {
+	// Generation is the AQI generation that the new data belongs to.
+	Generation int64 `json:"generation"`
+	// Cells are the geohashes with AQIUpdatePrecision characters of the cells that contain a
+	// sensor with new data.
+	Cells []string `json:"cells"`
+}
+
+// updateCells returns the sorted geohashes of the cells that contain a sensor with new data.
+func updateCells(data []purpleapi.Response) []string {
+	seen := make(map[string]struct{})
+	cells := make([]string, 0)
+
+	for _, resp := range data {
+		// Only outside sensors are stored, so they are the only ones with new data.
+		if resp.Location != purpleapi.Outside {
+			continue
+		}
+
+		cell := geo.Geohash(resp.Longitude, resp.Latitude, AQIUpdatePrecision)
+		if _, ok := seen[cell]; ok {
+			continue
+		}
+
+		seen[cell] = struct{}{}
+		cells = append(cells, cell)
+	}
+
+	sort.Strings(cells)
+
+	return cells
+}
+
+// PublishAQIUpdate announces new AQI data from the given sensors to every subscriber.
+func (c *Controller) PublishAQIUpdate(ctx context.Context, data []purpleapi.Response) error {
+	cells := updateCells(data)
+	if len(cells) == 0 {
+		return nil
+	}
+
+	generation, err := c.GetAQIGeneration(ctx)
+	if err != nil {
+		return err
+	}
+
+	message, err := json.Marshal(AQIUpdate{
+		Generation: generation,
+		Cells:      cells,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.db.Publish(ctx, aqiUpdateChannel, message).Err()
+}
+
+// SubscribeAQIUpdates subscribes to announcements of new AQI data. The returned channel is closed
+// once the subscription is closed.
+func (c *Controller) SubscribeAQIUpdates(ctx context.Context) (<-chan AQIUpdate, io.Closer, error) {
+	pubsub := c.db.Subscribe(ctx, aqiUpdateChannel)
+
+	// Wait for the subscription to be confirmed so that no updates are missed after returning.
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, nil, err
+	}
+
+	updates := make(chan AQIUpdate)
+
+	go func() {
+		defer close(updates)
+
+		for message := range pubsub.Channel() {
+			var update AQIUpdate
+
+			if err := json.Unmarshal([]byte(message.Payload), &update); err != nil {
+				log.Errorf("could not decode aqi update: %s", err)
+				continue
+			}
+
+			updates <- update
+		}
+	}()
+
+	return updates, pubsub, nil
+}
@@ -0,0 +1,32 @@
+// +build unit
+
+package redis
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mrflynn/air-alert/internal/purpleapi"
+)
+
+func TestUpdateCells(t *testing.T) {
+	data := []purpleapi.Response{
+		{Location: purpleapi.Outside, Latitude: 37.7, Longitude: -122.4},
+		{Location: purpleapi.Unknown, Latitude: 40.7, Longitude: -74.0},
+		{Location: purpleapi.Outside, Latitude: 37.9, Longitude: -122.6},
+		// Same cell as the first sensor.
+		{Location: purpleapi.Outside, Latitude: 37.71, Longitude: -122.41},
+		{Location: purpleapi.Outside, Latitude: 51.5, Longitude: -0.1},
+	}
+
+	expected := []string{"9q8y", "9q8z", "gcpu"}
+	if cells := updateCells(data); !cmp.Equal(cells, expected) {
+		t.Errorf("expected %v, got %v", expected, cells)
+	}
+}
+
+func TestUpdateCellsNoOutsideSensors(t *testing.T) {
+	if cells := updateCells([]purpleapi.Response{{Location: purpleapi.Unknown}}); len(cells) != 0 {
+		t.Errorf("expected no cells without outside sensors, got %v", cells)
+	}
+}
@@ -0,0 +1,114 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const rateLimitKey = "ratelimit"
+
+// takeTokenScript atomically refills a token bucket based on the time elapsed since it was last
+// updated and then tries to take a single token from it. Redis' clock is used so that every
+// server instance agrees on the current time. It returns whether or not a token was taken and the
+// number of tokens left in the bucket.
+var takeTokenScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(bucket[1])
+local updated = tonumber(bucket[2])
+
+if tokens == nil or updated == nil then
+	tokens = capacity
+	updated = now
+end
+
+tokens = math.min(capacity, tokens + math.max(0, now - updated) * rate)
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "updated", now)
+redis.call("PEXPIRE", KEYS[1], math.max(1, math.ceil((capacity - tokens) / rate)))
+
+return {allowed, tostring(tokens)}
+`)
+
+// RateLimitResult contains the state of a rate limit bucket after a request has been made.
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int64
+	Remaining int64
+	// Reset is the time until the bucket is completely refilled.
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed. It is zero if the request was
+	// allowed.
+	RetryAfter time.Duration
+}
+
+// TakeRateLimitToken takes a token from the bucket with the given identity. Each bucket holds up to
+// limit tokens and is refilled at a rate of limit tokens per period.
+func (c *Controller) TakeRateLimitToken(ctx context.Context, identity string, limit int64, period time.Duration) (RateLimitResult, error) {
+	// Tokens per millisecond.
+	rate := float64(limit) / float64(period.Milliseconds())
+
+	result := takeTokenScript.Run(
+		ctx, c.db, []string{rateLimitKey + ":" + identity}, limit, strconv.FormatFloat(rate, 'f', -1, 64),
+	)
+
+	return getRateLimitResult(result, limit, rate)
+}
+
+func getRateLimitResult(cmd *redis.Cmd, limit int64, rate float64) (RateLimitResult, error) {
+	result, err := cmd.Result()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	values, ok := result.([]interface{})
+	if !ok {
+		return RateLimitResult{}, fmt.Errorf("could not convert %T to slice", result)
+	} else if len(values) != 2 {
+		return RateLimitResult{}, fmt.Errorf("expected 2 values from rate limit script, got %d", len(values))
+	}
+
+	allowed, ok := values[0].(int64)
+	if !ok {
+		return RateLimitResult{}, fmt.Errorf("could not convert %T to int64", values[0])
+	}
+
+	raw, ok := values[1].(string)
+	if !ok {
+		return RateLimitResult{}, fmt.Errorf("could not convert %T to string", values[1])
+	}
+
+	tokens, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	state := RateLimitResult{
+		Allowed:   allowed == 1,
+		Limit:     limit,
+		Remaining: int64(math.Floor(tokens)),
+		Reset:     time.Duration((float64(limit)-tokens)/rate) * time.Millisecond,
+	}
+
+	if !state.Allowed {
+		state.RetryAfter = time.Duration((1-tokens)/rate) * time.Millisecond
+	}
+
+	return state, nil
+}
@@ -0,0 +1,61 @@
+// +build unit
+
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGetRateLimitResultAllowed(t *testing.T) {
+	// 60 tokens per minute.
+	cmd := redis.NewCmdResult([]interface{}{int64(1), "29.5"}, nil)
+
+	result, err := getRateLimitResult(cmd, 60, 0.001)
+	if err != nil {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	expected := RateLimitResult{
+		Allowed:   true,
+		Limit:     60,
+		Remaining: 29,
+		Reset:     30500 * time.Millisecond,
+	}
+
+	if !cmp.Equal(result, expected) {
+		t.Errorf("\nexpected %#v\ngot %#v", expected, result)
+	}
+}
+
+func TestGetRateLimitResultDenied(t *testing.T) {
+	cmd := redis.NewCmdResult([]interface{}{int64(0), "0.25"}, nil)
+
+	result, err := getRateLimitResult(cmd, 60, 0.001)
+	if err != nil {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	expected := RateLimitResult{
+		Allowed:    false,
+		Limit:      60,
+		Remaining:  0,
+		Reset:      59750 * time.Millisecond,
+		RetryAfter: 750 * time.Millisecond,
+	}
+
+	if !cmp.Equal(result, expected) {
+		t.Errorf("\nexpected %#v\ngot %#v", expected, result)
+	}
+}
+
+func TestGetRateLimitResultBadType(t *testing.T) {
+	cmd := redis.NewCmdResult("1", nil)
+
+	if _, err := getRateLimitResult(cmd, 60, 0.001); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
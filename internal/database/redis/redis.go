@@ -3,6 +3,7 @@ package redis
 import (
 	"context"
 	"fmt"
+	"math"
 	"reflect"
 	"strconv"
 	"strings"
@@ -10,8 +11,10 @@ import (
 
 	"github.com/go-redis/redis/v8"
 	utils "github.com/mrflynn/air-alert/internal"
+	"github.com/mrflynn/air-alert/internal/geo"
+	"github.com/mrflynn/air-alert/internal/logging"
 	"github.com/mrflynn/air-alert/internal/purpleapi"
-	"github.com/mrflynn/go-aqi"
+	"github.com/mrflynn/air-alert/internal/tracing"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
@@ -19,28 +22,66 @@ import (
 const (
 	sensorMapKey          = "sensors"
 	notificationStreamKey = "notifications"
+	// notificationStreamMaxLength is roughly the most notifications that are kept in the stream.
+	// Delivered notifications are removed, so this only drops the oldest notifications if they
+	// aren't delivered fast enough.
+	notificationStreamMaxLength = 100000
 )
 
+// defaultAQIRefreshInterval is how often new AQI data is assumed to be stored if the controller
+// isn't told otherwise.
+const defaultAQIRefreshInterval = 5 * time.Minute
+
 // Controller is a container for a Redis client.
 type Controller struct {
-	db *redis.Client
+	db                 *redis.Client
+	ingestBatchSize    int
+	aqiRefreshInterval time.Duration
+}
+
+// ControllerOption configures optional settings of a Controller.
+type ControllerOption func(*Controller)
+
+// WithAQIRefreshInterval sets how often new AQI data is stored, which determines how long data
+// derived from it is cached. The default is five minutes.
+func WithAQIRefreshInterval(interval time.Duration) ControllerOption {
+	return func(c *Controller) {
+		c.aqiRefreshInterval = interval
+	}
 }
 
 // NewController creates a new Redis client.
-func NewController() (*Controller, error) {
-	db := redis.NewClient(&redis.Options{
+func NewController(opts ...ControllerOption) (*Controller, error) {
+	batchSize := viper.GetInt("database.redis.ingest_batch_size")
+	if batchSize < 1 {
+		return &Controller{}, fmt.Errorf("redis ingest batch size must be at least 1, got %d", batchSize)
+	}
+
+	c := &Controller{
+		ingestBatchSize:    batchSize,
+		aqiRefreshInterval: defaultAQIRefreshInterval,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.aqiRefreshInterval <= 0 {
+		return &Controller{}, fmt.Errorf("aqi refresh interval must be positive, got %s", c.aqiRefreshInterval)
+	}
+
+	c.db = redis.NewClient(&redis.Options{
 		Addr:     viper.GetString("database.redis.addr"),
 		Password: viper.GetString("database.redis.password"),
 		DB:       viper.GetInt("database.redis.id"),
 	})
+	c.db.AddHook(tracingHook{})
 
-	if err := db.Ping(context.Background()).Err(); err != nil {
+	if err := c.db.Ping(context.Background()).Err(); err != nil {
 		return &Controller{}, err
 	}
 
-	return &Controller{
-		db: db,
-	}, nil
+	return c, nil
 }
 
 // Shutdown closes the connection to the Redis datastore.
@@ -86,49 +127,6 @@ func (c *Controller) exchangeAndRemove(ctx context.Context, originalKey, newKey
 	return nil
 }
 
-// SetAirQuality takes an array of Purple Air API response structs and stores the most recent
-// PM2.5 AQI readings from those sensors.
-func (c *Controller) SetAirQuality(ctx context.Context, data []purpleapi.Response) error {
-	cutoffTime := strconv.FormatInt(time.Now().Add(-60*time.Minute).Unix(), 10)
-
-	_, err := c.db.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
-		for _, resp := range data {
-			// We only want sensors that are outside.
-			if resp.Location == purpleapi.Outside {
-				id, err := getPrimaryKey(reflect.ValueOf(resp))
-				if err != nil {
-					return err
-				}
-
-				pm25Key := createRedisKey(id, "data", "pm25")
-				aqiKey := createRedisKey(id, "data", "aqi")
-
-				// Add pm2.5 value with score being equal to reading capture time.
-				pipe.ZAddNX(ctx, pm25Key, &redis.Z{
-					Score:  float64(resp.LastUpdated),
-					Member: resp.PM25,
-				})
-
-				// Add calculated AQI if the result is valid.
-				if aqi, err := aqi.Calculate(aqi.PM25{Concentration: resp.PM25}); err == nil {
-					pipe.ZAddNX(ctx, aqiKey, &redis.Z{
-						Score:  float64(resp.LastUpdated),
-						Member: aqi.AQI,
-					})
-				}
-
-				// This removes all measurements older than 60 minutes.
-				pipe.ZRemRangeByScore(ctx, pm25Key, "0", cutoffTime)
-				pipe.ZRemRangeByScore(ctx, aqiKey, "0", cutoffTime)
-			}
-		}
-
-		return nil
-	})
-
-	return err
-}
-
 // RawSensorData contains raw sensor from the Redis datastore.
 type RawSensorData struct {
 	ID   int               `json:"sensor_id"`
@@ -187,6 +185,10 @@ func (c *Controller) GetAirQuality(ctx context.Context, id int) (*RawSensorData,
 	return nil, fmt.Errorf("could not get sensor data for sensor id: %d", id)
 }
 
+// RecentReadingsCount is the number of recent readings per sensor returned by
+// GetAQIFromSensorsInRange.
+const RecentReadingsCount = 10
+
 // GetAQIFromSensorsInRange returns raw sensor data from all sensors within the specified
 // radius around the given coordinates.
 func (c *Controller) GetAQIFromSensorsInRange(ctx context.Context, longitude, latitude, radius float64) ([]*RawSensorData, error) {
@@ -195,7 +197,7 @@ func (c *Controller) GetAQIFromSensorsInRange(ctx context.Context, longitude, la
 		return nil, err
 	}
 
-	compositeDataMap, err := c.GetTimeSeriesData(ctx, 10, ids...)
+	compositeDataMap, err := c.GetTimeSeriesData(ctx, RecentReadingsCount, ids...)
 	if err != nil {
 		return nil, err
 	}
@@ -206,7 +208,7 @@ func (c *Controller) GetAQIFromSensorsInRange(ctx context.Context, longitude, la
 		if _, ok := sensorResultMap[id]; !ok {
 			sensorResultMap[id] = &RawSensorData{
 				ID:   id,
-				Data: make([]*RawQualityData, 0, 10), // There will only every be a maximum of 10 results.
+				Data: make([]*RawQualityData, 0, RecentReadingsCount),
 			}
 		}
 
@@ -255,7 +257,7 @@ func (c *Controller) SetSensorLocationData(ctx context.Context, data []purpleapi
 		return err
 	}
 
-	return nil
+	return c.db.Set(ctx, sensorsUpdatedKey, time.Now().Unix(), 0).Err()
 }
 
 // GetSensorsInRange takes a pair of coordinates and a radius (in meters) and returns a list of sensor IDs within that
@@ -279,13 +281,140 @@ func (c *Controller) GetSensorsInRange(ctx context.Context, longitude, latitude,
 		if id, err := strconv.Atoi(sensor.Name); err == nil {
 			ids = append(ids, id)
 		} else {
-			log.Errorf(`ID:%d:%d conversion err: %s`, id, i, err)
+			logging.FromContext(ctx).WithField(logging.FieldSensorID, sensor.Name).Errorf(`could not convert sensor id at %d: %s`, i, err)
 		}
 	}
 
 	return ids, nil
 }
 
+// SensorLocation contains the ID and coordinates of a sensor.
+type SensorLocation struct {
+	ID        int
+	Longitude float64
+	Latitude  float64
+}
+
+// GetSensorLocationsInArea returns the IDs and coordinates of all sensors inside of the given
+// area, sorted by distance from the center of the area's bounds.
+func (c *Controller) GetSensorLocationsInArea(ctx context.Context, area geo.Area) ([]SensorLocation, error) {
+	sensors, _, err := c.getSensorLocationsInArea(ctx, area, 0)
+	return sensors, err
+}
+
+// getSensorLocationsInArea returns the sensors inside of the given area, sorted by distance from
+// the center of the area's bounds. Only the count sensors closest to the center are searched, and
+// partial is set if there were more. A count of zero or less searches every sensor.
+func (c *Controller) getSensorLocationsInArea(ctx context.Context, area geo.Area, count int) ([]SensorLocation, bool, error) {
+	bounds := area.Bounds()
+	center := bounds.Center()
+
+	// Search the smallest circle that encloses the bounds and then filter out everything that is
+	// outside of the area itself.
+	radius := math.Max(
+		geo.Distance(center.Longitude, center.Latitude, bounds.MinLongitude, bounds.MinLatitude),
+		geo.Distance(center.Longitude, center.Latitude, bounds.MaxLongitude, bounds.MaxLatitude),
+	)
+
+	query := &redis.GeoRadiusQuery{
+		Radius:    math.Max(1, math.Ceil(radius)),
+		Unit:      "m",
+		WithCoord: true,
+		Sort:      "ASC",
+	}
+	if count > 0 {
+		query.Count = count
+	}
+
+	results, err := c.db.GeoRadius(ctx, sensorMapKey, center.Longitude, center.Latitude, query).Result()
+
+	if err == redis.Nil {
+		return []SensorLocation{}, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	sensors := make([]SensorLocation, 0, len(results))
+	for _, sensor := range results {
+		if !area.Contains(sensor.Longitude, sensor.Latitude) {
+			continue
+		}
+
+		id, err := strconv.Atoi(sensor.Name)
+		if err != nil {
+			logging.FromContext(ctx).WithField(logging.FieldSensorID, sensor.Name).Errorf(`could not convert sensor id: %s`, err)
+			continue
+		}
+
+		sensors = append(sensors, SensorLocation{
+			ID:        id,
+			Longitude: sensor.Longitude,
+			Latitude:  sensor.Latitude,
+		})
+	}
+
+	return sensors, count > 0 && len(results) == count, nil
+}
+
+// GetSensorsInArea returns the IDs and coordinates of sensors inside of the given area. At most
+// limit sensors are returned, preferring those closest to the center of the area's bounds, along
+// with whether any sensors were left out. A limit of zero or less returns every sensor.
+func (c *Controller) GetSensorsInArea(ctx context.Context, area geo.Area, limit int) ([]SensorLocation, bool, error) {
+	if limit <= 0 {
+		sensors, err := c.GetSensorLocationsInArea(ctx, area)
+		return sensors, false, err
+	}
+
+	// Sensors in the parts of the search circle outside of the area count towards the limit of
+	// the search too, so it is widened until enough sensors are found inside of the area. One
+	// extra sensor is searched for to tell whether any were left out.
+	for count := limit + 1; ; count *= 2 {
+		sensors, partial, err := c.getSensorLocationsInArea(ctx, area, count)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if len(sensors) > limit {
+			return sensors[:limit], true, nil
+		} else if !partial {
+			return sensors, false, nil
+		}
+	}
+}
+
+// GetSensorLocations returns the coordinates of the sensors with the given IDs. Sensors that do
+// not exist are left out.
+func (c *Controller) GetSensorLocations(ctx context.Context, ids ...int) ([]SensorLocation, error) {
+	if len(ids) < 1 {
+		return []SensorLocation{}, nil
+	}
+
+	members := make([]string, 0, len(ids))
+	for _, id := range ids {
+		members = append(members, strconv.Itoa(id))
+	}
+
+	positions, err := c.db.GeoPos(ctx, sensorMapKey, members...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sensors := make([]SensorLocation, 0, len(ids))
+	for i, position := range positions {
+		if position == nil {
+			continue
+		}
+
+		sensors = append(sensors, SensorLocation{
+			ID:        ids[i],
+			Longitude: position.Longitude,
+			Latitude:  position.Latitude,
+		})
+	}
+
+	return sensors, nil
+}
+
 // AQIForecast indicates changes in the direction of AQI values. In other words it indicates whether
 // or not the AQI is increasing, decreasing, or remaining the same.
 type AQIForecast int
@@ -300,36 +429,63 @@ const (
 )
 
 // NotificationStream contains data to insert into Redis stream that contains changing AQI information.
+// If Message is set, then it is delivered to the user verbatim instead of the AQI update.
+// TraceParent links the delivery of the notification to the trace that created it.
 type NotificationStream struct {
-	MessageID string
-	UID       int
-	AQI       float64
-	Forecast  AQIForecast
+	MessageID   string
+	UID         int
+	AQI         float64
+	Forecast    AQIForecast
+	Message     string
+	TraceParent string
 }
 
 func (a NotificationStream) getStreamArgs() map[string]interface{} {
-	return map[string]interface{}{
+	args := map[string]interface{}{
 		"uid":      a.UID,
 		"aqi":      a.AQI,
-		"forecast": a.Forecast,
+		"forecast": int(a.Forecast),
+	}
+
+	if a.Message != "" {
+		args["message"] = a.Message
 	}
+
+	if a.TraceParent != "" {
+		args["traceparent"] = a.TraceParent
+	}
+
+	return args
 }
 
-// AddToNotificationStream adds one or more NotifcationStream items into the forecast stream.
+// AddToNotificationStream adds one or more NotifcationStream items into the forecast stream. Items
+// without a TraceParent continue the trace in ctx.
 func (c *Controller) AddToNotificationStream(ctx context.Context, data ...NotificationStream) error {
-	_, err := c.db.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+	return c.AddToNotificationStreamFenced(ctx, 0, data...)
+}
+
+// AddToNotificationStreamFenced is like AddToNotificationStream, but nothing is added and
+// ErrStaleFencingToken is returned if token is no longer the fencing token of the task leader. This
+// stops a leader that has lost its lease from generating the same notifications as the new one.
+func (c *Controller) AddToNotificationStreamFenced(ctx context.Context, token int64, data ...NotificationStream) error {
+	traceParent := tracing.TraceParent(ctx)
+
+	return c.fenced(ctx, token, func(pipe redis.Pipeliner) error {
 		for _, d := range data {
+			if d.TraceParent == "" {
+				d.TraceParent = traceParent
+			}
+
 			pipe.XAdd(ctx, &redis.XAddArgs{
-				Stream: notificationStreamKey,
-				ID:     "*",
-				Values: d.getStreamArgs(),
+				Stream:       notificationStreamKey,
+				MaxLenApprox: notificationStreamMaxLength,
+				ID:           "*",
+				Values:       d.getStreamArgs(),
 			})
 		}
 
 		return nil
 	})
-
-	return err
 }
 
 // CreateConsumerGroup creates a consumer group and the associated stream.
@@ -346,7 +502,8 @@ func (c *Controller) CreateConsumerGroup(ctx context.Context, group string) erro
 }
 
 // NotificationConsumerRead reads n notifications from the "notifications" stream and serializes
-// them into an array of NotificationStream structs.
+// them into an array of NotificationStream structs. The notifications are pending until they are
+// acknowledged with ACKNotifications.
 func (c *Controller) NotificationConsumerRead(ctx context.Context, group, consumer string, count int64) ([]NotificationStream, error) {
 	result := c.db.XReadGroup(ctx, &redis.XReadGroupArgs{
 		Group:    group,
@@ -354,18 +511,86 @@ func (c *Controller) NotificationConsumerRead(ctx context.Context, group, consum
 		Streams:  []string{notificationStreamKey, ">"},
 		Count:    count,
 		Block:    200 * time.Millisecond,
-		NoAck:    true,
 	})
 
 	return getNotificationsFromStream(result, count)
 }
 
-// ACKNotifications acknowledges that a set of notifications have been processed.
+// ACKNotifications acknowledges that a set of notifications have been processed and removes them
+// from the stream, so that the stream only holds notifications that are still waiting to be
+// delivered. Notifications are only read by a single consumer group.
 func (c *Controller) ACKNotifications(ctx context.Context, group string, notifications ...NotificationStream) error {
 	ids := make([]string, 0, len(notifications))
 	for _, n := range notifications {
 		ids = append(ids, n.MessageID)
 	}
 
-	return c.db.XAck(ctx, notificationStreamKey, group, ids...).Err()
+	_, err := c.db.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, notificationStreamKey, group, ids...)
+		pipe.XDel(ctx, notificationStreamKey, ids...)
+
+		return nil
+	})
+
+	return err
+}
+
+// PendingNotification contains details about a notification that has been read by a consumer but
+// has not been acknowledged.
+type PendingNotification struct {
+	MessageID  string        `json:"message_id"`
+	Consumer   string        `json:"consumer"`
+	Idle       time.Duration `json:"idle"`
+	RetryCount int64         `json:"retry_count"`
+}
+
+// NotificationStreamInfo contains the current state of the notification stream for a consumer group.
+// Length is the number of notifications that haven't been delivered yet, including pending ones.
+type NotificationStreamInfo struct {
+	Length    int64                 `json:"length"`
+	Pending   int64                 `json:"pending"`
+	Consumers map[string]int64      `json:"consumers"`
+	Entries   []PendingNotification `json:"entries"`
+}
+
+// GetNotificationStreamInfo returns the number of notifications waiting to be delivered and up to
+// count pending entries for the given consumer group.
+func (c *Controller) GetNotificationStreamInfo(ctx context.Context, group string, count int64) (NotificationStreamInfo, error) {
+	length, err := c.db.XLen(ctx, notificationStreamKey).Result()
+	if err != nil {
+		return NotificationStreamInfo{}, err
+	}
+
+	summary, err := c.db.XPending(ctx, notificationStreamKey, group).Result()
+	if err != nil {
+		return NotificationStreamInfo{}, err
+	}
+
+	pending, err := c.db.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: notificationStreamKey,
+		Group:  group,
+		Start:  "-",
+		End:    "+",
+		Count:  count,
+	}).Result()
+	if err != nil {
+		return NotificationStreamInfo{}, err
+	}
+
+	entries := make([]PendingNotification, 0, len(pending))
+	for _, p := range pending {
+		entries = append(entries, PendingNotification{
+			MessageID:  p.ID,
+			Consumer:   p.Consumer,
+			Idle:       p.Idle,
+			RetryCount: p.RetryCount,
+		})
+	}
+
+	return NotificationStreamInfo{
+		Length:    length,
+		Pending:   summary.Count,
+		Consumers: summary.Consumers,
+		Entries:   entries,
+	}, nil
 }
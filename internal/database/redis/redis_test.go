@@ -0,0 +1,124 @@
+// +build unit
+
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/go-cmp/cmp"
+	"github.com/mrflynn/air-alert/internal/geo"
+	"github.com/mrflynn/air-alert/internal/purpleapi"
+)
+
+// createTestController creates a controller that is backed by an in-memory Redis server.
+func createTestController(t *testing.T) (*Controller, *miniredis.Miniredis) {
+	t.Helper()
+
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("could not start redis server: %s", err)
+	}
+	t.Cleanup(server.Close)
+
+	c := &Controller{
+		db:                 redis.NewClient(&redis.Options{Addr: server.Addr()}),
+		ingestBatchSize:    2,
+		aqiRefreshInterval: 5 * time.Minute,
+	}
+	t.Cleanup(func() { c.Shutdown() })
+
+	return c, server
+}
+
+func TestGetSensorsInArea(t *testing.T) {
+	c, _ := createTestController(t)
+
+	// The bounds are much wider than they are tall, so sensors just outside of them are closer to
+	// the center than most of the sensors inside.
+	bounds := geo.Bounds{MinLatitude: 37.69, MinLongitude: -123, MaxLatitude: 37.71, MaxLongitude: -122}
+
+	data := []purpleapi.Response{
+		{ID: 1, Location: purpleapi.Outside, Latitude: 37.7, Longitude: -122.5},
+		{ID: 2, Location: purpleapi.Outside, Latitude: 37.7, Longitude: -122.6},
+		{ID: 3, Location: purpleapi.Outside, Latitude: 37.7, Longitude: -122.7},
+		{ID: 4, Location: purpleapi.Outside, Latitude: 37.7, Longitude: -122.8},
+	}
+	for id := 10; id < 20; id++ {
+		data = append(data, purpleapi.Response{
+			ID: id, Location: purpleapi.Outside, Latitude: 37.72, Longitude: -122.5 + float64(id-10)*0.01,
+		})
+	}
+
+	if err := c.SetSensorLocationData(context.Background(), data); err != nil {
+		t.Fatalf("could not store sensor locations: %s", err)
+	}
+
+	tests := []struct {
+		limit     int
+		ids       []int
+		truncated bool
+	}{
+		{2, []int{1, 2}, true},
+		{3, []int{1, 2, 3}, true},
+		{4, []int{1, 2, 3, 4}, false},
+		{10, []int{1, 2, 3, 4}, false},
+		{0, []int{1, 2, 3, 4}, false},
+	}
+
+	for _, test := range tests {
+		sensors, truncated, err := c.GetSensorsInArea(context.Background(), bounds, test.limit)
+		if err != nil {
+			t.Fatalf("got unexpected error: %s", err)
+		}
+
+		ids := make([]int, 0, len(sensors))
+		for _, sensor := range sensors {
+			ids = append(ids, sensor.ID)
+		}
+
+		if !cmp.Equal(ids, test.ids) || truncated != test.truncated {
+			t.Errorf("limit %d: expected %v (truncated %t), got %v (truncated %t)",
+				test.limit, test.ids, test.truncated, ids, truncated)
+		}
+	}
+}
+
+func TestACKNotifications(t *testing.T) {
+	c, _ := createTestController(t)
+	ctx := context.Background()
+
+	if err := c.CreateConsumerGroup(ctx, "senders"); err != nil {
+		t.Fatalf("could not create consumer group: %s", err)
+	}
+
+	if err := c.AddToNotificationStream(ctx, NotificationStream{UID: 1, Forecast: AQIIncreasing}); err != nil {
+		t.Fatalf("could not add notifications: %s", err)
+	}
+
+	notifications, err := c.NotificationConsumerRead(ctx, "senders", "sender", 1)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if len(notifications) != 1 || notifications[0].UID != 1 || notifications[0].Forecast != AQIIncreasing {
+		t.Fatalf("expected notification for user 1, got %+v", notifications)
+	}
+
+	if err := c.ACKNotifications(ctx, "senders", notifications...); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	// Delivered notifications are removed from the stream.
+	length, err := c.db.XLen(ctx, notificationStreamKey).Result()
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if length != 0 {
+		t.Errorf("expected no notifications to be left in the stream, got %d", length)
+	}
+}
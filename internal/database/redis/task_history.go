@@ -0,0 +1,85 @@
+package redis
+
+import (
+	"context"
+
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const taskHistoryKey = "task:history"
+
+// TaskRun is a single run of a task as it is stored in the history.
+type TaskRun struct {
+	Task     string    `json:"task"`
+	Trigger  string    `json:"trigger"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration float64   `json:"duration_seconds"`
+	Attempts int       `json:"attempts"`
+	Outcome  string    `json:"outcome"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// TaskHistory keeps the most recent runs of every task in a capped list per task, so that the
+// runs of all servers can be seen in one place.
+type TaskHistory struct {
+	db   *redis.Client
+	size int64
+}
+
+// NewTaskHistory creates a task history that keeps up to size runs of every task.
+func (c *Controller) NewTaskHistory(size int64) *TaskHistory {
+	return &TaskHistory{
+		db:   c.db,
+		size: size,
+	}
+}
+
+// AddRun stores a run and drops the oldest runs of the task if there are too many.
+func (h *TaskHistory) AddRun(ctx context.Context, run TaskRun) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	key := taskHistoryKey + ":" + run.Task
+
+	_, err = h.db.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, key, data)
+		pipe.LTrim(ctx, key, 0, h.size-1)
+
+		return nil
+	})
+
+	return err
+}
+
+// Runs returns up to count of the most recent runs of the task, newest first.
+func (h *TaskHistory) Runs(ctx context.Context, name string, count int64) ([]TaskRun, error) {
+	if count <= 0 {
+		return []TaskRun{}, nil
+	}
+
+	values, err := h.db.LRange(ctx, taskHistoryKey+":"+name, 0, count-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeTaskRuns(values)
+}
+
+func decodeTaskRuns(values []string) ([]TaskRun, error) {
+	runs := make([]TaskRun, 0, len(values))
+	for _, value := range values {
+		var run TaskRun
+		if err := json.Unmarshal([]byte(value), &run); err != nil {
+			return nil, err
+		}
+
+		runs = append(runs, run)
+	}
+
+	return runs, nil
+}
@@ -0,0 +1,46 @@
+// +build unit
+
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDecodeTaskRuns(t *testing.T) {
+	start := time.Date(2020, time.September, 1, 8, 0, 0, 0, time.UTC)
+	expected := []TaskRun{
+		{
+			Task:     "update-aqi",
+			Trigger:  "scheduled",
+			Start:    start,
+			End:      start.Add(2 * time.Second),
+			Duration: 2,
+			Attempts: 2,
+			Outcome:  "failure",
+			Error:    "unavailable",
+		},
+	}
+
+	data, err := json.Marshal(expected[0])
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	runs, err := decodeTaskRuns([]string{string(data)})
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if !cmp.Equal(runs, expected) {
+		t.Errorf("\nexpected %#v\ngot %#v", expected, runs)
+	}
+}
+
+func TestDecodeTaskRunsInvalid(t *testing.T) {
+	if _, err := decodeTaskRuns([]string{"{"}); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
@@ -0,0 +1,34 @@
+package redis
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const pausedTasksKey = "task:paused"
+
+// PausedTasks keeps track of paused tasks in Redis, so that a task is paused on every server.
+type PausedTasks struct {
+	db *redis.Client
+}
+
+// NewPausedTasks creates a store of paused tasks.
+func (c *Controller) NewPausedTasks() *PausedTasks {
+	return &PausedTasks{db: c.db}
+}
+
+// Pause marks the task as paused.
+func (p *PausedTasks) Pause(ctx context.Context, name string) error {
+	return p.db.SAdd(ctx, pausedTasksKey, name).Err()
+}
+
+// Resume marks the task as no longer paused.
+func (p *PausedTasks) Resume(ctx context.Context, name string) error {
+	return p.db.SRem(ctx, pausedTasksKey, name).Err()
+}
+
+// IsPaused returns whether the task is paused.
+func (p *PausedTasks) IsPaused(ctx context.Context, name string) (bool, error) {
+	return p.db.SIsMember(ctx, pausedTasksKey, name).Result()
+}
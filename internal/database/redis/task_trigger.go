@@ -0,0 +1,42 @@
+package redis
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const taskTriggersKey = "task:triggers"
+
+// TaskTriggers queues tasks that were triggered on one server for the server that runs background
+// tasks.
+type TaskTriggers struct {
+	db *redis.Client
+}
+
+// NewTaskTriggers creates a queue of triggered tasks.
+func (c *Controller) NewTaskTriggers() *TaskTriggers {
+	return &TaskTriggers{db: c.db}
+}
+
+// Push adds a triggered task to the end of the queue.
+func (t *TaskTriggers) Push(ctx context.Context, name string) error {
+	return t.db.RPush(ctx, taskTriggersKey, name).Err()
+}
+
+// Pop removes every task from the queue and returns them in the order they were triggered.
+func (t *TaskTriggers) Pop(ctx context.Context) ([]string, error) {
+	var names *redis.StringSliceCmd
+
+	_, err := t.db.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		names = pipe.LRange(ctx, taskTriggersKey, 0, -1)
+		pipe.Del(ctx, taskTriggersKey)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return names.Val(), nil
+}
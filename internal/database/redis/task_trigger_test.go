@@ -0,0 +1,35 @@
+// +build unit
+
+package redis
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTaskTriggers(t *testing.T) {
+	controller, _ := createTestController(t)
+	triggers := controller.NewTaskTriggers()
+	ctx := context.Background()
+
+	for _, name := range []string{"update-sensors", "update-aqi"} {
+		if err := triggers.Push(ctx, name); err != nil {
+			t.Fatalf("got unexpected error: %s", err)
+		}
+	}
+
+	names, err := triggers.Pop(ctx)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if expected := []string{"update-sensors", "update-aqi"}; !cmp.Equal(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+
+	if names, err := triggers.Pop(ctx); err != nil || len(names) != 0 {
+		t.Errorf("expected queue to be empty, got %v, %v", names, err)
+	}
+}
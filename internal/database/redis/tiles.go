@@ -0,0 +1,58 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// aqiGenerationKey holds a counter that is incremented every time new AQI data is stored. It is
+	// used to tell apart data derived from different AQI updates.
+	aqiGenerationKey = "aqi:generation"
+	tileKey          = "tile"
+)
+
+func createTileKey(generation int64, z, x, y int) string {
+	return fmt.Sprintf("%s:%d:%d:%d:%d", tileKey, generation, z, x, y)
+}
+
+// AQIRefreshInterval returns how often new AQI data is stored, as set by WithAQIRefreshInterval.
+func (c *Controller) AQIRefreshInterval() time.Duration {
+	return c.aqiRefreshInterval
+}
+
+// aqiCacheTTL returns how long data derived from AQI data, such as tiles and the air quality of
+// cells, is cached. Cached data is tied to the generation of the AQI data it was derived from and
+// stops being used as soon as new data is stored, so it only needs to outlast a single refresh.
+func (c *Controller) aqiCacheTTL() time.Duration {
+	return 2 * c.aqiRefreshInterval
+}
+
+// GetAQIGeneration returns the number of times AQI data has been stored.
+func (c *Controller) GetAQIGeneration(ctx context.Context) (int64, error) {
+	generation, err := c.db.Get(ctx, aqiGenerationKey).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+
+	return generation, err
+}
+
+// GetTile returns the cached tile image for the given AQI generation and tile coordinates. It
+// returns nil if the tile is not cached.
+func (c *Controller) GetTile(ctx context.Context, generation int64, z, x, y int) ([]byte, error) {
+	tile, err := c.db.Get(ctx, createTileKey(generation, z, x, y)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+
+	return tile, err
+}
+
+// SetTile caches a tile image for the given AQI generation and tile coordinates.
+func (c *Controller) SetTile(ctx context.Context, generation int64, z, x, y int, tile []byte) error {
+	return c.db.Set(ctx, createTileKey(generation, z, x, y), tile, c.aqiCacheTTL()).Err()
+}
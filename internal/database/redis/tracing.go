@@ -0,0 +1,88 @@
+package redis
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/mrflynn/air-alert/internal/tracing"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/semconv"
+)
+
+// tracingHook records a span for every command and pipeline sent to Redis as part of a trace.
+// Commands outside of a trace, like polling the notification stream, are not recorded so that they
+// don't each start a trace of their own. Only command names are recorded since arguments can
+// contain user data.
+type tracingHook struct{}
+
+var _ redis.Hook = tracingHook{}
+
+// hookSpanKey stores the span started by the hook in the command context.
+type hookSpanKey struct{}
+
+// startSpan starts a span for a command if ctx is part of a trace.
+func startSpan(ctx context.Context, name string, attrs ...label.KeyValue) context.Context {
+	if !trace.SpanFromContext(ctx).SpanContext().IsValid() {
+		return ctx
+	}
+
+	ctx, span := tracing.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	return context.WithValue(ctx, hookSpanKey{}, span)
+}
+
+// endSpan ends the span started by startSpan, if there is one.
+func endSpan(ctx context.Context, err error) {
+	if span, ok := ctx.Value(hookSpanKey{}).(trace.Span); ok {
+		tracing.End(span, err)
+	}
+}
+
+// commandError returns the error of a command, ignoring redis.Nil since a missing key is an
+// expected result.
+func commandError(cmd redis.Cmder) error {
+	if err := cmd.Err(); err != nil && err != redis.Nil {
+		return err
+	}
+
+	return nil
+}
+
+func (tracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return startSpan(ctx, "redis "+cmd.FullName(),
+		semconv.DBSystemRedis,
+		semconv.DBOperationKey.String(cmd.FullName()),
+	), nil
+}
+
+func (tracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	endSpan(ctx, commandError(cmd))
+	return nil
+}
+
+func (tracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	names := make([]string, 0, len(cmds))
+	for _, cmd := range cmds {
+		names = append(names, cmd.FullName())
+	}
+
+	return startSpan(ctx, "redis pipeline",
+		semconv.DBSystemRedis,
+		semconv.DBOperationKey.String("pipeline"),
+		semconv.DBStatementKey.String(strings.Join(names, "; ")),
+		label.Int("db.redis.pipeline_length", len(cmds)),
+	), nil
+}
+
+func (tracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if err = commandError(cmd); err != nil {
+			break
+		}
+	}
+
+	endSpan(ctx, err)
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/mrflynn/air-alert/internal/logging"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -68,14 +69,14 @@ func serializeSensorData(cmds []redis.Cmder) (map[UnionKey]*RawQualityData, erro
 
 			set, err := cmd.Result()
 			if err != nil {
-				log.Debugf("could not get result for %#v : %d : %s", path, id, err)
+				log.WithField(logging.FieldSensorID, id).Debugf("could not get result for %#v: %s", path, err)
 				continue
 			}
 
 			for _, item := range set {
 				value, err := strconv.ParseFloat(item.Member.(string), 64)
 				if err != nil {
-					log.Debugf("could not convert field %#v : %d to float: %s", path, id, err)
+					log.WithField(logging.FieldSensorID, id).Debugf("could not convert field %#v to float: %s", path, err)
 					continue
 				}
 
@@ -123,9 +124,11 @@ func getNotificationsFromStream(c redis.Cmder, count int64) ([]NotificationStrea
 
 		for _, s := range stream {
 			for _, m := range s.Messages {
+				logger := log.WithField(logging.FieldMessageID, m.ID)
+
 				uid, err := strconv.Atoi(m.Values["uid"].(string))
 				if err != nil {
-					log.Debugf("could not convert %s to uid, skipping...", m.Values["uid"].(string))
+					logger.Debugf("could not convert %s to uid, skipping...", m.Values["uid"].(string))
 					continue
 				}
 
@@ -134,21 +137,27 @@ func getNotificationsFromStream(c redis.Cmder, count int64) ([]NotificationStrea
 
 				aqi, err = strconv.ParseFloat(m.Values["aqi"].(string), 64)
 				if err != nil {
-					log.Debugf("could not conver aqi field from stream: %s", err)
+					logger.Debugf("could not conver aqi field from stream: %s", err)
 					continue
 				}
 
 				forecast, err = strconv.Atoi(m.Values["forecast"].(string))
 				if err != nil {
-					log.Debugf("could not convert forecast field from stream %s", err)
+					logger.Debugf("could not convert forecast field from stream %s", err)
 					continue
 				}
 
+				// Custom messages and trace context are optional.
+				message, _ := m.Values["message"].(string)
+				traceParent, _ := m.Values["traceparent"].(string)
+
 				streamData = append(streamData, NotificationStream{
-					MessageID: m.ID,
-					UID:       uid,
-					AQI:       aqi,
-					Forecast:  AQIForecast(forecast),
+					MessageID:   m.ID,
+					UID:         uid,
+					AQI:         aqi,
+					Forecast:    AQIForecast(forecast),
+					Message:     message,
+					TraceParent: traceParent,
 				})
 			}
 		}
@@ -178,11 +178,21 @@ func TestGetNotifcationStream(t *testing.T) {
 						"forecast": "2",
 					},
 				},
+				{
+					ID: "2",
+					Values: map[string]interface{}{
+						"uid":         "2",
+						"aqi":         "0",
+						"forecast":    "0",
+						"message":     "hello",
+						"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+					},
+				},
 			},
 		},
 	}, nil)
 
-	data, err := getNotificationsFromStream(cmd, 2)
+	data, err := getNotificationsFromStream(cmd, 3)
 	if err != nil {
 		t.Errorf("got unexpected error: %s", err)
 	}
@@ -200,6 +210,13 @@ func TestGetNotifcationStream(t *testing.T) {
 			AQI:       2.5,
 			Forecast:  AQIDecreasing,
 		},
+		{
+			MessageID:   "2",
+			UID:         2,
+			Forecast:    AQIStatic,
+			Message:     "hello",
+			TraceParent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		},
 	}
 
 	if !cmp.Equal(data, expected) {
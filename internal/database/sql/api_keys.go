@@ -0,0 +1,95 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	utils "github.com/mrflynn/air-alert/internal"
+	"github.com/mrflynn/air-alert/internal/database/sql/models"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+// apiKeySize is the number of random bytes used to create an API key.
+const apiKeySize = 24
+
+// APIKey contains details about an issued API key. Only a hash of the key itself is stored, so
+// the key cannot be recovered after it has been created.
+type APIKey struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	RateLimit int       `json:"rate_limit"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func apiKeyModelToAPIKey(m *models.APIKey) APIKey {
+	return APIKey{
+		ID:        m.ID,
+		Name:      m.Name,
+		RateLimit: m.RateLimit,
+		CreatedAt: m.CreatedAt,
+	}
+}
+
+// CreateAPIKey issues a new API key with the given name and rate limit (in requests per minute).
+// A rate limit of 0 means the key uses the default rate limit for API keys. It returns the key,
+// which is not stored anywhere, along with its details.
+func (c *Controller) CreateAPIKey(ctx context.Context, name string, rateLimit int) (string, APIKey, error) {
+	key, err := utils.CreateToken(apiKeySize)
+	if err != nil {
+		return "", APIKey{}, err
+	}
+
+	newKey := &models.APIKey{
+		Name:      name,
+		KeyHash:   utils.HashToken(key),
+		RateLimit: rateLimit,
+	}
+
+	err = newKey.Insert(ctx, c.db, boil.Infer())
+	if err != nil {
+		return "", APIKey{}, err
+	}
+
+	return key, apiKeyModelToAPIKey(newKey), nil
+}
+
+// GetAPIKey returns the details of the given API key. It returns sql.ErrNoRows if the key does
+// not exist.
+func (c *Controller) GetAPIKey(ctx context.Context, key string) (APIKey, error) {
+	apiKey, err := models.APIKeys(models.APIKeyWhere.KeyHash.EQ(utils.HashToken(key))).One(ctx, c.db)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	return apiKeyModelToAPIKey(apiKey), nil
+}
+
+// GetAllAPIKeys returns the details of every issued API key.
+func (c *Controller) GetAllAPIKeys(ctx context.Context) ([]APIKey, error) {
+	apiKeys, err := models.APIKeys(qm.OrderBy(models.APIKeyColumns.ID)).All(ctx, c.db)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]APIKey, 0, len(apiKeys))
+	for _, k := range apiKeys {
+		keys = append(keys, apiKeyModelToAPIKey(k))
+	}
+
+	return keys, nil
+}
+
+// DeleteAPIKey revokes the API key with the matching ID. It returns sql.ErrNoRows if no such key
+// exists.
+func (c *Controller) DeleteAPIKey(ctx context.Context, id int) error {
+	rows, err := models.APIKeys(models.APIKeyWhere.ID.EQ(id)).DeleteAll(ctx, c.db)
+	if err != nil {
+		return err
+	} else if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
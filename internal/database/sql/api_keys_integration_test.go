@@ -0,0 +1,68 @@
+// +build integration
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestCreateAPIKey(t *testing.T) {
+	defer runSeq()()
+
+	key, created, err := controller.CreateAPIKey(context.Background(), "test", 100)
+	if err != nil {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	if key == "" {
+		t.Error("expected key to be non-empty")
+	}
+
+	found, err := controller.GetAPIKey(context.Background(), key)
+	if err != nil {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	if found.ID != created.ID || found.Name != "test" || found.RateLimit != 100 {
+		t.Errorf("expected %#v\ngot %#v", created, found)
+	}
+
+	if found.CreatedAt.IsZero() {
+		t.Error("expected creation time to be set")
+	}
+}
+
+func TestGetAPIKeyInvalid(t *testing.T) {
+	defer runSeq()()
+
+	_, err := controller.GetAPIKey(context.Background(), "invalid")
+	if err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestDeleteAPIKey(t *testing.T) {
+	defer runSeq()()
+
+	key, created, err := controller.CreateAPIKey(context.Background(), "deleted", 0)
+	if err != nil {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	err = controller.DeleteAPIKey(context.Background(), created.ID)
+	if err != nil {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	_, err = controller.GetAPIKey(context.Background(), key)
+	if err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows, got %v", err)
+	}
+
+	err = controller.DeleteAPIKey(context.Background(), created.ID)
+	if err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows, got %v", err)
+	}
+}
@@ -4,7 +4,9 @@
 package models
 
 var TableNames = struct {
-	Users string
+	APIKeys string
+	Users   string
 }{
-	Users: "users",
+	APIKeys: "api_keys",
+	Users:   "users",
 }
@@ -6,17 +6,20 @@ import (
 	"time"
 
 	"github.com/SherClockHolmes/webpush-go"
+	"github.com/lib/pq"
 	"github.com/mrflynn/air-alert/internal/database/sql/models"
+	"github.com/mrflynn/air-alert/internal/geo"
 	log "github.com/sirupsen/logrus"
 	"github.com/volatiletech/null/v8"
 	"github.com/volatiletech/sqlboiler/v4/boil"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
 )
 
 //go:generate sqlboiler --wipe psql
 
 // Controller is a container for the SQL backend connection.
 type Controller struct {
-	db *sql.DB
+	db tracedDB
 }
 
 // NewController verifies the connection with the SQL backend
@@ -27,10 +30,15 @@ func NewController(conn *sql.DB) (*Controller, error) {
 	}
 
 	return &Controller{
-		db: conn,
+		db: tracedDB{conn},
 	}, nil
 }
 
+// Ping checks that the SQL backend is reachable.
+func (c *Controller) Ping(ctx context.Context) error {
+	return c.db.PingContext(ctx)
+}
+
 // Shutdown closes the database connection.
 func (c *Controller) Shutdown() error {
 	log.Debug("attempting to shutdown sql database controller...")
@@ -101,6 +109,77 @@ func (c *Controller) GetAllUsers(ctx context.Context) ([]UserRequest, error) {
 	return requests, nil
 }
 
+// CountUsers returns the number of users subscribed to notifications.
+func (c *Controller) CountUsers(ctx context.Context) (int64, error) {
+	return models.Users().Count(ctx, c.db)
+}
+
+// GetUsers returns at most limit users ordered by ID, starting after offset users.
+func (c *Controller) GetUsers(ctx context.Context, offset, limit int) ([]UserRequest, error) {
+	users, err := models.Users(
+		qm.OrderBy(models.UserColumns.ID),
+		qm.Offset(offset),
+		qm.Limit(limit),
+	).All(ctx, c.db)
+	if err != nil {
+		return nil, err
+	}
+
+	requests := make([]UserRequest, 0, len(users))
+	for _, u := range users {
+		requests = append(requests, userModelToUserRequest(u))
+	}
+
+	return requests, nil
+}
+
+// GetUsersAfter returns at most limit users ordered by ID whose ID is greater than id. Unlike
+// GetUsers, pages stay consistent while users are created or deleted, so all users can be read in
+// pages by passing the ID of the last user of the previous page.
+func (c *Controller) GetUsersAfter(ctx context.Context, id, limit int) ([]UserRequest, error) {
+	users, err := models.Users(
+		models.UserWhere.ID.GT(id),
+		qm.OrderBy(models.UserColumns.ID),
+		qm.Limit(limit),
+	).All(ctx, c.db)
+	if err != nil {
+		return nil, err
+	}
+
+	requests := make([]UserRequest, 0, len(users))
+	for _, u := range users {
+		requests = append(requests, userModelToUserRequest(u))
+	}
+
+	return requests, nil
+}
+
+// GetUsersInArea returns a list of all users whose location is inside of the given area.
+func (c *Controller) GetUsersInArea(ctx context.Context, area geo.Area) ([]UserRequest, error) {
+	bounds := area.Bounds()
+
+	// Narrow down the search to the bounding box of the area first, then filter out any users that
+	// fall outside of the area itself.
+	users, err := models.Users(
+		models.UserWhere.Latitude.GTE(bounds.MinLatitude),
+		models.UserWhere.Latitude.LTE(bounds.MaxLatitude),
+		models.UserWhere.Longitude.GTE(bounds.MinLongitude),
+		models.UserWhere.Longitude.LTE(bounds.MaxLongitude),
+	).All(ctx, c.db)
+	if err != nil {
+		return nil, err
+	}
+
+	requests := make([]UserRequest, 0, len(users))
+	for _, u := range users {
+		if area.Contains(u.Longitude, u.Latitude) {
+			requests = append(requests, userModelToUserRequest(u))
+		}
+	}
+
+	return requests, nil
+}
+
 // GetUserWithID returns the user with the matching ID, if they exist.
 func (c *Controller) GetUserWithID(ctx context.Context, id int) (UserRequest, error) {
 	user, err := models.FindUser(ctx, c.db, id)
@@ -111,6 +190,21 @@ func (c *Controller) GetUserWithID(ctx context.Context, id int) (UserRequest, er
 	return userModelToUserRequest(user), nil
 }
 
+// GetUserWithSubscription returns the user with a matching push url, public, and private keys, if
+// they exist.
+func (c *Controller) GetUserWithSubscription(ctx context.Context, sub *webpush.Subscription) (UserRequest, error) {
+	user, err := models.Users(
+		models.UserWhere.PushURL.EQ(sub.Endpoint),
+		models.UserWhere.PrivateKey.EQ(sub.Keys.Auth),
+		models.UserWhere.PublicKey.EQ(sub.Keys.P256dh),
+	).One(ctx, c.db)
+	if err != nil {
+		return UserRequest{}, err
+	}
+
+	return userModelToUserRequest(user), nil
+}
+
 // UpdateCrossoverTime updates the last_notification column in the database for a specific user.
 func (c *Controller) UpdateCrossoverTime(ctx context.Context, id int, updated time.Time) error {
 	user, err := models.FindUser(ctx, c.db, id)
@@ -127,6 +221,33 @@ func (c *Controller) UpdateCrossoverTime(ctx context.Context, id int, updated ti
 	return nil
 }
 
+// CrossoverUpdate is a new crossover time of a user.
+type CrossoverUpdate struct {
+	ID        int
+	Crossover time.Time
+}
+
+// UpdateCrossoverTimes updates the crossover times of many users in a single statement.
+func (c *Controller) UpdateCrossoverTimes(ctx context.Context, updates []CrossoverUpdate) error {
+	if len(updates) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(updates))
+	crossovers := make([]string, 0, len(updates))
+
+	for _, update := range updates {
+		ids = append(ids, int64(update.ID))
+		crossovers = append(crossovers, update.Crossover.UTC().Format(time.RFC3339Nano))
+	}
+
+	_, err := c.db.ExecContext(ctx, `update users set last_crossover = updates.crossover
+from unnest($1::integer[], $2::timestamptz[]) as updates (id, crossover)
+where users.id = updates.id`, pq.Array(ids), pq.Array(crossovers))
+
+	return err
+}
+
 // DeleteUser deletes a user that has a matching push url, public, and private keys.
 func (c *Controller) DeleteUser(ctx context.Context, u UserRequest) error {
 	_, err := models.Users(
@@ -137,3 +258,16 @@ func (c *Controller) DeleteUser(ctx context.Context, u UserRequest) error {
 
 	return err
 }
+
+// DeleteUserWithID deletes the user with the matching ID. It returns sql.ErrNoRows if no such user
+// exists.
+func (c *Controller) DeleteUserWithID(ctx context.Context, id int) error {
+	rows, err := models.Users(models.UserWhere.ID.EQ(id)).DeleteAll(ctx, c.db)
+	if err != nil {
+		return err
+	} else if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	_ "github.com/lib/pq"
+	"github.com/mrflynn/air-alert/internal/geo"
 	"github.com/volatiletech/null/v8"
 )
 
@@ -161,6 +162,19 @@ func TestGetAllUsers(t *testing.T) {
 	}
 }
 
+func TestCountUsers(t *testing.T) {
+	defer runSeq()()
+
+	count, err := controller.CountUsers(context.Background())
+	if err != nil {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	if count != 1 {
+		t.Errorf("expected 1 user, got %d", count)
+	}
+}
+
 func TestGetUserWithID(t *testing.T) {
 	defer runSeq()()
 
@@ -220,6 +234,50 @@ func TestUpdateCrossoverTime(t *testing.T) {
 	}
 }
 
+func TestGetUsersAfter(t *testing.T) {
+	defer runSeq()()
+
+	users, err := controller.GetUsersAfter(context.Background(), 0, 10)
+	if err != nil {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	if !cmp.Equal(users, []UserRequest{testUser}, cmpopts.IgnoreFields(UserRequest{}, "ID", "LastCrossover")) {
+		t.Errorf("expected %#v\ngot %#v", []UserRequest{testUser}, users)
+	}
+
+	users, err = controller.GetUsersAfter(context.Background(), 1, 10)
+	if err != nil {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	if len(users) != 0 {
+		t.Errorf("expected no users after the last user, got %d", len(users))
+	}
+}
+
+func TestUpdateCrossoverTimes(t *testing.T) {
+	defer runSeq()()
+
+	crossover := time.Date(2020, time.September, 1, 8, 0, 0, 0, time.UTC)
+	err := controller.UpdateCrossoverTimes(context.Background(), []CrossoverUpdate{
+		{ID: 1, Crossover: crossover},
+		{ID: 100, Crossover: crossover},
+	})
+	if err != nil {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	var lastCrossover sql.NullTime
+	if err := conn.QueryRow("select last_crossover from users where id = 1").Scan(&lastCrossover); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if !lastCrossover.Valid || !lastCrossover.Time.Equal(crossover) {
+		t.Errorf("expected time to be %s, got %#v", crossover, lastCrossover)
+	}
+}
+
 func TestDeleteUser(t *testing.T) {
 	defer runSeq()()
 
@@ -268,3 +326,72 @@ func TestDeleteUser(t *testing.T) {
 		t.Errorf("expected id to be %d, got %d", createdID, id)
 	}
 }
+
+func TestGetUsersInArea(t *testing.T) {
+	defer runSeq()()
+
+	users, err := controller.GetUsersInArea(context.Background(), geo.Circle{
+		Point:  geo.Point{Latitude: 1.0, Longitude: 1.0},
+		Radius: 1000,
+	})
+	if err != nil {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	if len(users) != 1 {
+		t.Errorf("expected 1 user, got %d", len(users))
+	}
+
+	users, err = controller.GetUsersInArea(context.Background(), geo.Circle{
+		Point:  geo.Point{Latitude: 0.0, Longitude: 0.0},
+		Radius: 1000,
+	})
+	if err != nil {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	if len(users) != 0 {
+		t.Errorf("expected 0 users, got %d", len(users))
+	}
+}
+
+func TestGetUsers(t *testing.T) {
+	defer runSeq()()
+
+	users, err := controller.GetUsers(context.Background(), 0, 10)
+	if err != nil {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	if len(users) != 1 {
+		t.Errorf("expected 1 user, got %d", len(users))
+	}
+
+	users, err = controller.GetUsers(context.Background(), 1, 10)
+	if err != nil {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	if len(users) != 0 {
+		t.Errorf("expected 0 users, got %d", len(users))
+	}
+}
+
+func TestDeleteUserWithID(t *testing.T) {
+	defer runSeq()()
+
+	createdID, err := controller.CreateUser(context.Background(), testUser)
+	if err != nil {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	err = controller.DeleteUserWithID(context.Background(), createdID)
+	if err != nil {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	err = controller.DeleteUserWithID(context.Background(), createdID)
+	if err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows, got %v", err)
+	}
+}
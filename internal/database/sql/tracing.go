@@ -0,0 +1,71 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/mrflynn/air-alert/internal/tracing"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/semconv"
+)
+
+// tracedDB records a span for every query that SQLBoiler runs as part of a trace, such as the
+// queries of a request or a task. Statements are recorded as they are sent, with placeholders
+// instead of arguments.
+type tracedDB struct {
+	*sql.DB
+}
+
+// startQuery starts the span of a single query. Spans are named after the SQL operation, e.g.
+// "postgresql SELECT".
+func startQuery(ctx context.Context, query string) (context.Context, trace.Span) {
+	if !trace.SpanFromContext(ctx).SpanContext().IsValid() {
+		return ctx, trace.NoopSpan{}
+	}
+
+	operation := strings.TrimSpace(query)
+	if i := strings.IndexAny(operation, " \n\t"); i >= 0 {
+		operation = operation[:i]
+	}
+	operation = strings.ToUpper(operation)
+
+	return tracing.Start(ctx, "postgresql "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgres,
+			semconv.DBOperationKey.String(operation),
+			semconv.DBStatementKey.String(query),
+		),
+	)
+}
+
+func (t tracedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := startQuery(ctx, query)
+	result, err := t.DB.ExecContext(ctx, query, args...)
+	tracing.End(span, err)
+
+	return result, err
+}
+
+func (t tracedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := startQuery(ctx, query)
+	rows, err := t.DB.QueryContext(ctx, query, args...)
+	tracing.End(span, err)
+
+	return rows, err
+}
+
+func (t tracedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, span := startQuery(ctx, query)
+	row := t.DB.QueryRowContext(ctx, query, args...)
+
+	// A missing row is a normal result, not a failed query.
+	err := row.Err()
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	tracing.End(span, err)
+
+	return row
+}
@@ -0,0 +1,267 @@
+package geo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// earthRadius is the mean radius of the Earth in meters.
+	earthRadius = 6371008.8
+	// metersPerDegree is the approximate length of one degree of latitude in meters.
+	metersPerDegree = 111320.0
+)
+
+// Point is a single pair of coordinates.
+type Point struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Bounds is a rectangular region defined by its south-west and north-east corners.
+type Bounds struct {
+	MinLatitude  float64 `json:"min_latitude"`
+	MinLongitude float64 `json:"min_longitude"`
+	MaxLatitude  float64 `json:"max_latitude"`
+	MaxLongitude float64 `json:"max_longitude"`
+}
+
+// Contains checks if the given coordinates are inside of the bounds.
+func (b Bounds) Contains(longitude, latitude float64) bool {
+	return latitude >= b.MinLatitude && latitude <= b.MaxLatitude &&
+		longitude >= b.MinLongitude && longitude <= b.MaxLongitude
+}
+
+// Intersects checks if the bounds overlap with other bounds.
+func (b Bounds) Intersects(other Bounds) bool {
+	return b.MinLatitude <= other.MaxLatitude && b.MaxLatitude >= other.MinLatitude &&
+		b.MinLongitude <= other.MaxLongitude && b.MaxLongitude >= other.MinLongitude
+}
+
+// Bounds returns the bounds themselves so that Bounds can be used as an Area.
+func (b Bounds) Bounds() Bounds {
+	return b
+}
+
+// Center returns the point halfway between the corners of the bounds.
+func (b Bounds) Center() Point {
+	return Point{
+		Latitude:  (b.MinLatitude + b.MaxLatitude) / 2,
+		Longitude: (b.MinLongitude + b.MaxLongitude) / 2,
+	}
+}
+
+// Validate checks that both corners are valid coordinates and that the south-west corner is
+// actually south-west of the north-east corner.
+func (b Bounds) Validate() error {
+	if err := ValidatePoint(b.MinLongitude, b.MinLatitude); err != nil {
+		return err
+	} else if err := ValidatePoint(b.MaxLongitude, b.MaxLatitude); err != nil {
+		return err
+	}
+
+	if b.MinLatitude > b.MaxLatitude {
+		return errors.New("minimum latitude is greater than maximum latitude")
+	} else if b.MinLongitude > b.MaxLongitude {
+		return errors.New("minimum longitude is greater than maximum longitude")
+	}
+
+	return nil
+}
+
+// Area is a region on the surface of the Earth.
+type Area interface {
+	// Contains checks if the given coordinates are inside of the area.
+	Contains(longitude, latitude float64) bool
+	// Bounds returns the smallest rectangle that fully encloses the area.
+	Bounds() Bounds
+}
+
+// Distance returns the great-circle distance in meters between two pairs of coordinates.
+func Distance(longitude1, latitude1, longitude2, latitude2 float64) float64 {
+	lat1 := toRadians(latitude1)
+	lat2 := toRadians(latitude2)
+	deltaLat := toRadians(latitude2 - latitude1)
+	deltaLong := toRadians(longitude2 - longitude1)
+
+	a := math.Pow(math.Sin(deltaLat/2), 2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin(deltaLong/2), 2)
+
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+func toRadians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+// Circle is an Area defined by a center point and a radius in meters.
+type Circle struct {
+	Point
+	Radius float64 `json:"radius"`
+}
+
+// Contains checks if the given coordinates are within the radius of the circle.
+func (c Circle) Contains(longitude, latitude float64) bool {
+	return Distance(c.Longitude, c.Latitude, longitude, latitude) <= c.Radius
+}
+
+// Bounds returns the rectangle that encloses the circle.
+func (c Circle) Bounds() Bounds {
+	latDelta := c.Radius / metersPerDegree
+
+	// Near the poles a degree of longitude approaches zero meters, so just cover every longitude.
+	longDelta := 180.0
+	if cos := math.Cos(toRadians(c.Latitude)); cos > 1e-6 {
+		longDelta = math.Min(180.0, c.Radius/(metersPerDegree*cos))
+	}
+
+	return Bounds{
+		MinLatitude:  math.Max(-90, c.Latitude-latDelta),
+		MinLongitude: math.Max(-180, c.Longitude-longDelta),
+		MaxLatitude:  math.Min(90, c.Latitude+latDelta),
+		MaxLongitude: math.Min(180, c.Longitude+longDelta),
+	}
+}
+
+// Polygon is an Area defined by a list of vertices. The polygon is implicitly closed, so the
+// first vertex does not need to be repeated at the end.
+type Polygon []Point
+
+// Contains checks if the given coordinates are inside of the polygon using the ray casting
+// algorithm.
+func (p Polygon) Contains(longitude, latitude float64) bool {
+	inside := false
+
+	for i, j := 0, len(p)-1; i < len(p); j, i = i, i+1 {
+		a, b := p[i], p[j]
+
+		if (a.Latitude > latitude) != (b.Latitude > latitude) {
+			crossing := a.Longitude +
+				(latitude-a.Latitude)*(b.Longitude-a.Longitude)/(b.Latitude-a.Latitude)
+
+			if longitude < crossing {
+				inside = !inside
+			}
+		}
+	}
+
+	return inside
+}
+
+// Bounds returns the rectangle that encloses every vertex of the polygon.
+func (p Polygon) Bounds() Bounds {
+	if len(p) < 1 {
+		return Bounds{}
+	}
+
+	bounds := Bounds{
+		MinLatitude:  p[0].Latitude,
+		MinLongitude: p[0].Longitude,
+		MaxLatitude:  p[0].Latitude,
+		MaxLongitude: p[0].Longitude,
+	}
+
+	for _, point := range p[1:] {
+		bounds.MinLatitude = math.Min(bounds.MinLatitude, point.Latitude)
+		bounds.MinLongitude = math.Min(bounds.MinLongitude, point.Longitude)
+		bounds.MaxLatitude = math.Max(bounds.MaxLatitude, point.Latitude)
+		bounds.MaxLongitude = math.Max(bounds.MaxLongitude, point.Longitude)
+	}
+
+	return bounds
+}
+
+// Validate checks that the polygon has enough vertices and that each one is a valid coordinate.
+func (p Polygon) Validate() error {
+	if len(p) < 3 {
+		return errors.New("polygon must have at least 3 vertices")
+	}
+
+	for _, point := range p {
+		if err := ValidatePoint(point.Longitude, point.Latitude); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ValidatePoint checks that the given coordinates are within the valid range of longitudes
+// and latitudes.
+func ValidatePoint(longitude, latitude float64) error {
+	if latitude < -90 || latitude > 90 {
+		return fmt.Errorf("latitude %f is out of range", latitude)
+	} else if longitude < -180 || longitude > 180 {
+		return fmt.Errorf("longitude %f is out of range", longitude)
+	}
+
+	return nil
+}
+
+// ParsePolygon parses a polygon from a string of semicolon-separated vertices where each vertex
+// is a comma-separated latitude and longitude. For example: "37.1,-122.1;37.2,-122.0;37.0,-121.9".
+func ParsePolygon(s string) (Polygon, error) {
+	vertices := strings.Split(strings.TrimSpace(s), ";")
+	polygon := make(Polygon, 0, len(vertices))
+
+	for _, vertex := range vertices {
+		coordinates := strings.Split(vertex, ",")
+		if len(coordinates) != 2 {
+			return nil, fmt.Errorf(`invalid vertex "%s"`, vertex)
+		}
+
+		latitude, err := strconv.ParseFloat(strings.TrimSpace(coordinates[0]), 64)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid latitude in vertex "%s"`, vertex)
+		}
+
+		longitude, err := strconv.ParseFloat(strings.TrimSpace(coordinates[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid longitude in vertex "%s"`, vertex)
+		}
+
+		polygon = append(polygon, Point{Latitude: latitude, Longitude: longitude})
+	}
+
+	if err := polygon.Validate(); err != nil {
+		return nil, err
+	}
+
+	return polygon, nil
+}
+
+// ParseBounds parses bounds from a string of four comma-separated values in the order minimum
+// latitude, minimum longitude, maximum latitude, and maximum longitude. For example:
+// "37.0,-122.5,37.9,-121.8".
+func ParseBounds(s string) (Bounds, error) {
+	values := strings.Split(strings.TrimSpace(s), ",")
+	if len(values) != 4 {
+		return Bounds{}, fmt.Errorf(`invalid bounds "%s"`, s)
+	}
+
+	coordinates := make([]float64, 0, len(values))
+	for _, v := range values {
+		coordinate, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return Bounds{}, fmt.Errorf(`invalid coordinate "%s" in bounds`, v)
+		}
+
+		coordinates = append(coordinates, coordinate)
+	}
+
+	bounds := Bounds{
+		MinLatitude:  coordinates[0],
+		MinLongitude: coordinates[1],
+		MaxLatitude:  coordinates[2],
+		MaxLongitude: coordinates[3],
+	}
+
+	if err := bounds.Validate(); err != nil {
+		return Bounds{}, err
+	}
+
+	return bounds, nil
+}
@@ -0,0 +1,214 @@
+// +build unit
+
+package geo
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var square = Polygon{
+	{Latitude: 0, Longitude: 0},
+	{Latitude: 0, Longitude: 1},
+	{Latitude: 1, Longitude: 1},
+	{Latitude: 1, Longitude: 0},
+}
+
+func TestDistance(t *testing.T) {
+	// San Francisco to Los Angeles is roughly 559 km.
+	distance := Distance(-122.4194, 37.7749, -118.2437, 34.0522)
+
+	if math.Abs(distance-559000) > 2000 {
+		t.Errorf("expected distance to be about 559000 m, got %f", distance)
+	}
+}
+
+func TestCircleContains(t *testing.T) {
+	circle := Circle{
+		Point:  Point{Latitude: 37.7749, Longitude: -122.4194},
+		Radius: 2000,
+	}
+
+	if !circle.Contains(-122.41, 37.78) {
+		t.Error("expected point to be inside of circle")
+	}
+
+	if circle.Contains(-122.2, 37.8) {
+		t.Error("expected point to be outside of circle")
+	}
+}
+
+func TestCircleBounds(t *testing.T) {
+	circle := Circle{
+		Point:  Point{Latitude: 0, Longitude: 0},
+		Radius: metersPerDegree,
+	}
+
+	bounds := circle.Bounds()
+	expected := Bounds{MinLatitude: -1, MinLongitude: -1, MaxLatitude: 1, MaxLongitude: 1}
+
+	if !cmp.Equal(bounds, expected) {
+		t.Errorf("expected %#v, got %#v", expected, bounds)
+	}
+}
+
+func TestPolygonContains(t *testing.T) {
+	if !square.Contains(0.5, 0.5) {
+		t.Error("expected point to be inside of polygon")
+	}
+
+	if square.Contains(1.5, 0.5) {
+		t.Error("expected point to be outside of polygon")
+	}
+}
+
+func TestPolygonBounds(t *testing.T) {
+	bounds := square.Bounds()
+	expected := Bounds{MinLatitude: 0, MinLongitude: 0, MaxLatitude: 1, MaxLongitude: 1}
+
+	if !cmp.Equal(bounds, expected) {
+		t.Errorf("expected %#v, got %#v", expected, bounds)
+	}
+}
+
+func TestParsePolygon(t *testing.T) {
+	polygon, err := ParsePolygon("0,0; 0,1; 1,1; 1,0")
+	if err != nil {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	if !cmp.Equal(polygon, square) {
+		t.Errorf("expected %#v, got %#v", square, polygon)
+	}
+}
+
+func TestParsePolygonTooFewVertices(t *testing.T) {
+	if _, err := ParsePolygon("0,0;1,1"); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestParsePolygonInvalidVertex(t *testing.T) {
+	if _, err := ParsePolygon("0,0;1;1,1"); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestParseBounds(t *testing.T) {
+	bounds, err := ParseBounds("37.0, -122.5, 37.9, -121.8")
+	if err != nil {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	expected := Bounds{MinLatitude: 37.0, MinLongitude: -122.5, MaxLatitude: 37.9, MaxLongitude: -121.8}
+	if !cmp.Equal(bounds, expected) {
+		t.Errorf("expected %#v, got %#v", expected, bounds)
+	}
+
+	center := bounds.Center()
+	if math.Abs(center.Latitude-37.45) > 1e-9 || math.Abs(center.Longitude+122.15) > 1e-9 {
+		t.Errorf("got unexpected center %#v", center)
+	}
+}
+
+func TestParseBoundsInvalid(t *testing.T) {
+	for _, s := range []string{"37.0,-122.5,37.9", "37.9,-122.5,37.0,-121.8", "37.0,-122.5,37.9,abc", "0,0,91,1"} {
+		if _, err := ParseBounds(s); err == nil {
+			t.Errorf("%s: expected error, got nil", s)
+		}
+	}
+}
+
+func TestBoundsIntersects(t *testing.T) {
+	bounds := Bounds{MinLatitude: 0, MinLongitude: 0, MaxLatitude: 1, MaxLongitude: 1}
+
+	tests := []struct {
+		other      Bounds
+		intersects bool
+	}{
+		{Bounds{MinLatitude: 0.5, MinLongitude: 0.5, MaxLatitude: 2, MaxLongitude: 2}, true},
+		{Bounds{MinLatitude: -1, MinLongitude: -1, MaxLatitude: 2, MaxLongitude: 2}, true},
+		{Bounds{MinLatitude: 1, MinLongitude: 1, MaxLatitude: 2, MaxLongitude: 2}, true},
+		{Bounds{MinLatitude: 1.5, MinLongitude: 0, MaxLatitude: 2, MaxLongitude: 1}, false},
+		{Bounds{MinLatitude: 0, MinLongitude: -2, MaxLatitude: 1, MaxLongitude: -1}, false},
+	}
+
+	for _, test := range tests {
+		if bounds.Intersects(test.other) != test.intersects {
+			t.Errorf("%+v: expected intersects to be %t", test.other, test.intersects)
+		}
+	}
+}
+
+func TestGeohash(t *testing.T) {
+	if hash := Geohash(10.40744, 57.64911, 11); hash != "u4pruydqqvj" {
+		t.Errorf("expected u4pruydqqvj, got %s", hash)
+	}
+
+	if hash := Geohash(10.40744, 57.64911, 5); hash != "u4pru" {
+		t.Errorf("expected u4pru, got %s", hash)
+	}
+}
+
+func TestGeohashBounds(t *testing.T) {
+	bounds, err := GeohashBounds("u4pruydqqvj")
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if !bounds.Contains(10.40744, 57.64911) {
+		t.Errorf("expected %#v to contain the point the geohash was created from", bounds)
+	}
+
+	if height := bounds.MaxLatitude - bounds.MinLatitude; height > 1e-5 {
+		t.Errorf("expected cell to be smaller than 0.00001 degrees, got %f", height)
+	}
+
+	if _, err := GeohashBounds("u4pa"); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestGeohashesInBounds(t *testing.T) {
+	world := Bounds{MinLatitude: -90, MinLongitude: -180, MaxLatitude: 90, MaxLongitude: 180}
+
+	hashes, ok := GeohashesInBounds(world, 1, 32)
+	if !ok {
+		t.Fatal("expected 32 cells to be within the limit")
+	}
+
+	if len(hashes) != 32 || !strings.Contains(strings.Join(hashes, ""), "u") {
+		t.Errorf("expected every cell of the world, got %v", hashes)
+	}
+
+	if _, ok := GeohashesInBounds(world, 2, 32); ok {
+		t.Error("expected too many cells")
+	}
+
+	// The bounds cross the western border of the cell.
+	cell, err := GeohashBounds("9q8yy")
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	center := cell.Center()
+	bounds := Bounds{
+		MinLatitude:  center.Latitude - 0.001,
+		MinLongitude: cell.MinLongitude - 0.001,
+		MaxLatitude:  center.Latitude + 0.001,
+		MaxLongitude: cell.MinLongitude + 0.001,
+	}
+
+	hashes, ok = GeohashesInBounds(bounds, 5, 10)
+	if !ok {
+		t.Fatal("expected cells to be within the limit")
+	}
+
+	expected := []string{Geohash(bounds.MinLongitude, center.Latitude, 5), "9q8yy"}
+	if !cmp.Equal(hashes, expected) {
+		t.Errorf("expected %v, got %v", expected, hashes)
+	}
+}
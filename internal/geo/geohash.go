@@ -0,0 +1,133 @@
+package geo
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// geohashAlphabet is the base 32 alphabet used by geohashes.
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// Geohash returns the geohash of the given coordinates with precision characters. Geohashes divide
+// the world into a grid of cells, and all coordinates in the same cell share the same geohash. A
+// precision of 6 gives cells of about 1.2 by 0.6 km.
+func Geohash(longitude, latitude float64, precision int) string {
+	latitudeRange := [2]float64{-90, 90}
+	longitudeRange := [2]float64{-180, 180}
+
+	var (
+		hash strings.Builder
+		bits int
+		char int
+	)
+
+	// Bits alternate between longitude and latitude, starting with longitude.
+	for even := true; hash.Len() < precision; even = !even {
+		value, bounds := latitude, &latitudeRange
+		if even {
+			value, bounds = longitude, &longitudeRange
+		}
+
+		char <<= 1
+		if mid := (bounds[0] + bounds[1]) / 2; value >= mid {
+			char |= 1
+			bounds[0] = mid
+		} else {
+			bounds[1] = mid
+		}
+
+		if bits++; bits == 5 {
+			hash.WriteByte(geohashAlphabet[char])
+			bits, char = 0, 0
+		}
+	}
+
+	return hash.String()
+}
+
+// GeohashBounds returns the bounds of the cell with the given geohash.
+func GeohashBounds(hash string) (Bounds, error) {
+	bounds := Bounds{MinLatitude: -90, MinLongitude: -180, MaxLatitude: 90, MaxLongitude: 180}
+	even := true
+
+	for _, c := range hash {
+		char := strings.IndexRune(geohashAlphabet, c)
+		if char < 0 {
+			return Bounds{}, fmt.Errorf(`invalid geohash "%s"`, hash)
+		}
+
+		for bit := 4; bit >= 0; bit-- {
+			set := char&(1<<uint(bit)) != 0
+
+			if even {
+				if mid := (bounds.MinLongitude + bounds.MaxLongitude) / 2; set {
+					bounds.MinLongitude = mid
+				} else {
+					bounds.MaxLongitude = mid
+				}
+			} else {
+				if mid := (bounds.MinLatitude + bounds.MaxLatitude) / 2; set {
+					bounds.MinLatitude = mid
+				} else {
+					bounds.MaxLatitude = mid
+				}
+			}
+
+			even = !even
+		}
+	}
+
+	return bounds, nil
+}
+
+// geohashCellSize returns the width and height in degrees of geohash cells with precision
+// characters.
+func geohashCellSize(precision int) (float64, float64) {
+	bits := 5 * precision
+	longitudeBits := (bits + 1) / 2
+
+	return 360 / math.Pow(2, float64(longitudeBits)), 180 / math.Pow(2, float64(bits-longitudeBits))
+}
+
+// cellIndex returns the index of the cell of the given size that value falls into, counting from
+// start.
+func cellIndex(value, start, size float64, cells int) int {
+	index := int(math.Floor((value - start) / size))
+	if index < 0 {
+		return 0
+	} else if index >= cells {
+		return cells - 1
+	}
+
+	return index
+}
+
+// GeohashesInBounds returns the geohashes with precision characters of every cell that overlaps
+// with the bounds. It returns false instead if there are more than limit of them.
+func GeohashesInBounds(bounds Bounds, precision, limit int) ([]string, bool) {
+	width, height := geohashCellSize(precision)
+	columns, rows := int(math.Round(360/width)), int(math.Round(180/height))
+
+	minColumn := cellIndex(bounds.MinLongitude, -180, width, columns)
+	maxColumn := cellIndex(bounds.MaxLongitude, -180, width, columns)
+	minRow := cellIndex(bounds.MinLatitude, -90, height, rows)
+	maxRow := cellIndex(bounds.MaxLatitude, -90, height, rows)
+
+	if count := (maxColumn - minColumn + 1) * (maxRow - minRow + 1); count > limit {
+		return nil, false
+	}
+
+	hashes := make([]string, 0, (maxColumn-minColumn+1)*(maxRow-minRow+1))
+	for row := minRow; row <= maxRow; row++ {
+		for column := minColumn; column <= maxColumn; column++ {
+			// The center of a cell is safely inside of it.
+			longitude := -180 + (float64(column)+0.5)*width
+			latitude := -90 + (float64(row)+0.5)*height
+
+			hashes = append(hashes, Geohash(longitude, latitude, precision))
+		}
+	}
+
+	return hashes, true
+}
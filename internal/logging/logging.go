@@ -0,0 +1,65 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Output formats of log messages.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Field names that are shared by log messages across the application, so that messages about the
+// same thing can be found together.
+const (
+	FieldRequestID = "request_id"
+	FieldTask      = "task"
+	FieldUserID    = "user_id"
+	FieldSensorID  = "sensor_id"
+	FieldMessageID = "message_id"
+	FieldGeohash   = "geohash"
+)
+
+// Configure sets the level and output format of the standard logger.
+func Configure(level, format string) error {
+	lvl, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case FormatText:
+		log.SetFormatter(&log.TextFormatter{
+			FullTimestamp:          true,
+			DisableLevelTruncation: true,
+		})
+	case FormatJSON:
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf(`unknown log format "%s"`, format)
+	}
+
+	log.SetLevel(lvl)
+	return nil
+}
+
+type contextKey struct{}
+
+// WithLogger returns a copy of ctx that carries the given logger.
+func WithLogger(ctx context.Context, logger *log.Entry) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or the standard logger if there is none. Messages
+// logged with it include the fields of whatever ctx belongs to, like the current request or task.
+func FromContext(ctx context.Context) *log.Entry {
+	if logger, ok := ctx.Value(contextKey{}).(*log.Entry); ok {
+		return logger
+	}
+
+	return log.NewEntry(log.StandardLogger())
+}
@@ -0,0 +1,45 @@
+// +build unit
+
+package logging
+
+import (
+	"context"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestConfigure(t *testing.T) {
+	defer Configure("info", FormatText)
+
+	if err := Configure("debug", FormatJSON); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if log.GetLevel() != log.DebugLevel {
+		t.Errorf("expected level debug, got %s", log.GetLevel())
+	}
+
+	if _, ok := log.StandardLogger().Formatter.(*log.JSONFormatter); !ok {
+		t.Errorf("expected json formatter, got %T", log.StandardLogger().Formatter)
+	}
+
+	if err := Configure("loud", FormatText); err == nil {
+		t.Error("expected error for invalid level, got nil")
+	}
+
+	if err := Configure("info", "xml"); err == nil {
+		t.Error("expected error for invalid format, got nil")
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if logger := FromContext(context.Background()); len(logger.Data) != 0 {
+		t.Errorf("expected logger without fields, got %v", logger.Data)
+	}
+
+	ctx := WithLogger(context.Background(), log.WithField(FieldTask, "test"))
+	if task := FromContext(ctx).Data[FieldTask]; task != "test" {
+		t.Errorf("expected task field test, got %v", task)
+	}
+}
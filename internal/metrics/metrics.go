@@ -0,0 +1,151 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Namespace prefixes the names of all metrics exported by Air Alert.
+const Namespace = "airalert"
+
+// Task run outcomes.
+const (
+	OutcomeSuccess  = "success"
+	OutcomeFailure  = "failure"
+	OutcomeTimeout  = "timeout"
+	OutcomeCanceled = "canceled"
+)
+
+// Reasons that a task run is skipped.
+const (
+	SkipOverlap   = "overlap"
+	SkipPaused    = "paused"
+	SkipSuspended = "suspended"
+)
+
+// Reasons that the reading of a sensor isn't stored.
+const (
+	SkipUnchanged = "unchanged"
+	SkipRejected  = "rejected"
+)
+
+// Reasons that a request to the Purple Air API can fail.
+const (
+	FailureRequest     = "request"
+	FailureRateLimited = "rate_limited"
+	FailureDecode      = "decode"
+)
+
+var (
+	// PurpleAirRequestDuration tracks how long single requests to the Purple Air API take.
+	PurpleAirRequestDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "purpleair",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of requests to the Purple Air API.",
+		Buckets:   []float64{0.25, 0.5, 1, 2.5, 5, 10, 20, 40},
+	})
+
+	// PurpleAirFailures counts failed attempts to get data from the Purple Air API by reason.
+	PurpleAirFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "purpleair",
+		Name:      "failures_total",
+		Help:      "Number of failed attempts to get data from the Purple Air API.",
+	}, []string{"reason"})
+
+	// SensorsIngested counts the sensors stored in the datastore by type of data.
+	SensorsIngested = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "sensors_ingested_total",
+		Help:      "Number of sensors whose data was stored.",
+	}, []string{"data"})
+
+	// SensorsSkipped counts the sensors whose readings weren't stored by reason.
+	SensorsSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "sensors_skipped_total",
+		Help:      "Number of sensors whose readings were not stored.",
+	}, []string{"reason"})
+
+	// TaskDuration tracks how long background task runs take.
+	TaskDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "task",
+		Name:      "duration_seconds",
+		Help:      "Duration of background task runs.",
+		Buckets:   []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300},
+	}, []string{"task"})
+
+	// TaskRuns counts background task runs by outcome.
+	TaskRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "task",
+		Name:      "runs_total",
+		Help:      "Number of background task runs.",
+	}, []string{"task", "outcome"})
+
+	// TaskRetries counts retries of failed task runs.
+	TaskRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "task",
+		Name:      "retries_total",
+		Help:      "Number of retries of failed background task runs.",
+	}, []string{"task"})
+
+	// TaskSkipped counts task runs that were skipped by reason.
+	TaskSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "task",
+		Name:      "skipped_total",
+		Help:      "Number of background task runs that were skipped.",
+	}, []string{"task", "reason"})
+
+	// TaskLeader is 1 while this server holds the task leader lease and runs scheduled tasks.
+	TaskLeader = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "task",
+		Name:      "leader",
+		Help:      "Whether this server is the task leader.",
+	})
+
+	// PushDeliveries counts web push deliveries by the status code returned by the push service,
+	// or "error" if the push service could not be reached.
+	PushDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "notifications",
+		Name:      "push_deliveries_total",
+		Help:      "Number of web push deliveries by response status.",
+	}, []string{"status"})
+
+	// HTTPRequestDuration tracks how long HTTP requests take by route.
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of HTTP requests by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+// TaskOutcome returns the outcome of a task run that returned err.
+func TaskOutcome(err error) string {
+	if err == context.DeadlineExceeded {
+		return OutcomeTimeout
+	} else if err == context.Canceled {
+		return OutcomeCanceled
+	} else if err != nil {
+		return OutcomeFailure
+	}
+
+	return OutcomeSuccess
+}
+
+// ObserveTask records the duration and outcome of a single task run.
+func ObserveTask(name string, duration time.Duration, err error) {
+	TaskDuration.WithLabelValues(name).Observe(duration.Seconds())
+	TaskRuns.WithLabelValues(name, TaskOutcome(err)).Inc()
+}
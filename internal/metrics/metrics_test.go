@@ -0,0 +1,32 @@
+// +build unit
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveTask(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		outcome string
+	}{
+		{"observe-success", nil, OutcomeSuccess},
+		{"observe-failure", errors.New("failed"), OutcomeFailure},
+		{"observe-timeout", context.DeadlineExceeded, OutcomeTimeout},
+	}
+
+	for _, test := range tests {
+		ObserveTask(test.name, time.Second, test.err)
+
+		if runs := testutil.ToFloat64(TaskRuns.WithLabelValues(test.name, test.outcome)); runs != 1 {
+			t.Errorf("%s: expected 1 %s run, got %f", test.name, test.outcome, runs)
+		}
+	}
+}
@@ -3,16 +3,50 @@ package notifications
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/SherClockHolmes/webpush-go"
 	utils "github.com/mrflynn/air-alert/internal"
 	"github.com/mrflynn/air-alert/internal/database/redis"
 	"github.com/mrflynn/air-alert/internal/database/sql"
+	"github.com/mrflynn/air-alert/internal/geo"
+	"github.com/mrflynn/air-alert/internal/logging"
+	"github.com/mrflynn/air-alert/internal/metrics"
+	"github.com/mrflynn/air-alert/internal/tracing"
 	"github.com/shopspring/decimal"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/semconv"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// testNotificationText is the message delivered by SendTest.
+	testNotificationText = "This is a test notification from Air Alert. You're all set!"
+
+	// MaxMessageLength is the maximum size in bytes of a broadcast message. Push services limit
+	// payloads to 4 KB after encryption, so this leaves plenty of headroom.
+	MaxMessageLength = 1024
+
+	// broadcastBatchSize is the number of notifications added to the stream at once during a
+	// broadcast.
+	broadcastBatchSize = 500
+
+	// pushTimeout is how long a push service has to respond to a notification.
+	pushTimeout = 10 * time.Second
+	// maxPushResponseSize is the maximum number of bytes that are read from the response of a push
+	// service.
+	maxPushResponseSize = 4096
 )
 
 // Sender is a notification sending system for web push notifications.
@@ -27,20 +61,38 @@ type Sender struct {
 	datastore *redis.Controller
 	users     *sql.Controller
 
+	// Shared across all delivery threads to throttle requests to push services.
+	limiter *rate.Limiter
+
 	stop chan bool
 	ack  chan bool
 }
 
 // NewSender creates a new notification sender.
 func NewSender(datastore *redis.Controller, users *sql.Controller) *Sender {
+	threads := viper.GetUint("web.notifications.threads")
+
+	// A non-positive rate limit disables throttling.
+	limit := rate.Inf
+	if l := viper.GetFloat64("web.notifications.rate_limit"); l > 0 {
+		limit = rate.Limit(l)
+	}
+
+	// A burst of 0 would reject every send.
+	burst := int(threads)
+	if burst < 1 {
+		burst = 1
+	}
+
 	return &Sender{
-		Threads:    viper.GetUint("web.notifications.threads"),
+		Threads:    threads,
 		Group:      viper.GetString("web.notifications.group"),
 		pubKey:     viper.GetString("web.notifications.public_key"),
 		privKey:    viper.GetString("web.notifications.private_key"),
 		subscriber: viper.GetString("web.notifications.admin_mail"),
 		datastore:  datastore,
 		users:      users,
+		limiter:    rate.NewLimiter(limit, burst),
 		stop:       make(chan bool),
 		ack:        make(chan bool),
 	}
@@ -64,26 +116,19 @@ func (s *Sender) dispatch() {
 
 		if ok {
 			for _, n := range notifications {
-				user, err := s.users.GetUserWithID(ctx, n.UID)
-				if err != nil {
-					log.Errorf("could not get user %d from database", n.UID)
-					continue
-				}
+				err := s.deliver(ctx, n)
 
-				msg := createNotificationText(n)
-				resp, err := webpush.SendNotification(msg, user.Subscription, &webpush.Options{
-					Subscriber:      s.subscriber,
-					TTL:             10,
-					VAPIDPublicKey:  s.pubKey,
-					VAPIDPrivateKey: s.privKey,
-				})
+				// Failed notifications aren't retried, so they are acknowledged as well. Only
+				// notifications of senders that stopped before delivering them stay pending.
+				if ackErr := s.datastore.ACKNotifications(ctx, s.Group, n); err == nil {
+					err = ackErr
+				}
 
 				if err != nil {
-					log.Errorf("got error from web push delivery service: %s", err)
-				} else if resp.StatusCode != http.StatusCreated {
-					log.Errorf("got non-201 response from push service: %d", resp.StatusCode)
-				} else {
-					s.datastore.ACKNotifications(ctx, s.Group, n)
+					log.WithFields(log.Fields{
+						logging.FieldMessageID: n.MessageID,
+						logging.FieldUserID:    n.UID,
+					}).Error(err)
 				}
 			}
 		}
@@ -98,6 +143,169 @@ func (s *Sender) dispatch() {
 	}
 }
 
+// deliver sends a single notification from the stream. The delivery continues the trace of
+// whatever created the notification.
+func (s *Sender) deliver(ctx context.Context, n redis.NotificationStream) (err error) {
+	ctx, span := tracing.Start(tracing.WithTraceParent(ctx, n.TraceParent), "notifications.deliver",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(label.String("messaging.message_id", n.MessageID)),
+	)
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	user, err := s.users.GetUserWithID(ctx, n.UID)
+	if err != nil {
+		return fmt.Errorf("could not get user from database: %s", err)
+	}
+
+	if err := s.limiter.Wait(ctx); err != nil {
+		return fmt.Errorf("notification rate limiter error: %s", err)
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, pushTimeout)
+	defer cancel()
+
+	resp, err := s.send(sendCtx, createNotificationText(n), user.Subscription)
+	if err != nil {
+		metrics.PushDeliveries.WithLabelValues("error").Inc()
+		return fmt.Errorf("got error from web push delivery service: %s", err)
+	}
+
+	resp.Body.Close()
+	metrics.PushDeliveries.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+	span.SetAttributes(semconv.HTTPStatusCodeKey.Int(resp.StatusCode))
+
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("got non-201 response from push service: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// contextClient sends push requests with a context, which webpush doesn't do by itself.
+type contextClient struct {
+	ctx context.Context
+}
+
+func (c contextClient) Do(req *http.Request) (*http.Response, error) {
+	return http.DefaultClient.Do(req.WithContext(c.ctx))
+}
+
+// send delivers a single message to a subscriber through their push service. The request is
+// cancelled with ctx. The caller is responsible for closing the response body.
+func (s *Sender) send(ctx context.Context, msg []byte, sub *webpush.Subscription) (*http.Response, error) {
+	return webpush.SendNotification(msg, sub, &webpush.Options{
+		HTTPClient:      contextClient{ctx},
+		Subscriber:      s.subscriber,
+		TTL:             10,
+		VAPIDPublicKey:  s.pubKey,
+		VAPIDPrivateKey: s.privKey,
+	})
+}
+
+// PushResponse contains the details of a response from a web push service.
+type PushResponse struct {
+	StatusCode int    `json:"status_code"`
+	Status     string `json:"status"`
+	Body       string `json:"body,omitempty"`
+}
+
+// Delivered indicates whether or not the push service accepted the notification.
+func (p PushResponse) Delivered() bool {
+	return p.StatusCode == http.StatusCreated
+}
+
+// SendTest sends a sample notification to the given user and reports the response from their
+// push service. Test notifications are subject to the same rate limit as other notifications. An
+// error is only returned if the push service could not be reached.
+func (s *Sender) SendTest(ctx context.Context, user sql.UserRequest) (PushResponse, error) {
+	if err := s.limiter.Wait(ctx); err != nil {
+		return PushResponse{}, fmt.Errorf("notification rate limiter error: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pushTimeout)
+	defer cancel()
+
+	resp, err := s.send(ctx, []byte(testNotificationText), user.Subscription)
+	if err != nil {
+		return PushResponse{}, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxPushResponseSize))
+	if err != nil {
+		return PushResponse{}, err
+	}
+
+	return PushResponse{
+		StatusCode: resp.StatusCode,
+		Status:     http.StatusText(resp.StatusCode),
+		Body:       strings.TrimSpace(string(body)),
+	}, nil
+}
+
+// Broadcast queues a custom message for delivery to every user inside of area, or to every user
+// if area is nil. Messages are delivered by the regular notification threads, so broadcasts are
+// subject to the same rate limit as air quality alerts. It returns the number of users the message
+// was queued for.
+func (s *Sender) Broadcast(ctx context.Context, message string, area geo.Area) (int, error) {
+	if message == "" {
+		return 0, errors.New("broadcast message cannot be empty")
+	} else if len(message) > MaxMessageLength {
+		return 0, errors.New("broadcast message is too long")
+	}
+
+	var (
+		users []sql.UserRequest
+		err   error
+	)
+
+	if area == nil {
+		users, err = s.users.GetAllUsers(ctx)
+	} else {
+		users, err = s.users.GetUsersInArea(ctx, area)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	if queued, err := queueInBatches(ctx, users, message, s.datastore.AddToNotificationStream); err != nil {
+		return queued, err
+	}
+
+	logging.FromContext(ctx).Infof("queued broadcast for %d users", len(users))
+
+	return len(users), nil
+}
+
+// queueInBatches adds a message for every user to the notification stream with add, in batches of
+// broadcastBatchSize. It returns the number of users the message was queued for.
+func queueInBatches(
+	ctx context.Context, users []sql.UserRequest, message string,
+	add func(context.Context, ...redis.NotificationStream) error,
+) (int, error) {
+	batch := make([]redis.NotificationStream, 0, broadcastBatchSize)
+	for i, user := range users {
+		batch = append(batch, redis.NotificationStream{
+			UID:     user.ID,
+			Message: message,
+		})
+
+		if len(batch) == broadcastBatchSize || i == len(users)-1 {
+			if err := add(ctx, batch...); err != nil {
+				return i + 1 - len(batch), err
+			}
+
+			batch = batch[:0]
+		}
+	}
+
+	return len(users), nil
+}
+
 // Run starts all notification delivery threads.
 func (s *Sender) Run() {
 	var i uint
@@ -117,8 +325,9 @@ func (s *Sender) Shutdown() {
 	)
 
 	for ; i < s.Threads; i++ {
+		wg.Add(1)
+
 		go func() {
-			wg.Add(1)
 			s.stop <- true
 			// Wait for ack.
 			<-s.ack
@@ -130,6 +339,10 @@ func (s *Sender) Shutdown() {
 }
 
 func createNotificationText(n redis.NotificationStream) []byte {
+	if n.Message != "" {
+		return []byte(n.Message)
+	}
+
 	msgBuff := bytes.NewBufferString("The AQI is ")
 
 	msgBuff.WriteString(decimal.NewFromFloat(n.AQI).Round(1).String())
@@ -3,10 +3,21 @@
 package notifications
 
 import (
+	"context"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/SherClockHolmes/webpush-go"
 	"github.com/mrflynn/air-alert/internal/database/redis"
+	"github.com/mrflynn/air-alert/internal/database/sql"
+	"golang.org/x/time/rate"
 )
 
 func TestCreateNotificationText(t *testing.T) {
@@ -34,3 +45,224 @@ func TestCreateNotificationText(t *testing.T) {
 		t.Errorf("expected notification text to be %s, got %s", expected, result)
 	}
 }
+
+func createTestSubscription(t *testing.T, endpoint string) *webpush.Subscription {
+	curve := elliptic.P256()
+
+	_, x, y, err := elliptic.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate subscription keys: %s", err)
+	}
+
+	auth := make([]byte, 16)
+	if _, err := rand.Read(auth); err != nil {
+		t.Fatalf("could not generate subscription auth secret: %s", err)
+	}
+
+	return &webpush.Subscription{
+		Endpoint: endpoint,
+		Keys: webpush.Keys{
+			Auth:   base64.RawURLEncoding.EncodeToString(auth),
+			P256dh: base64.RawURLEncoding.EncodeToString(elliptic.Marshal(curve, x, y)),
+		},
+	}
+}
+
+func TestSendTest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	privKey, pubKey, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatalf("could not generate vapid keys: %s", err)
+	}
+
+	sender := &Sender{
+		pubKey:     pubKey,
+		privKey:    privKey,
+		subscriber: "admin@localhost",
+		limiter:    rate.NewLimiter(rate.Inf, 1),
+	}
+
+	resp, err := sender.SendTest(context.Background(), sql.UserRequest{
+		Subscription: createTestSubscription(t, server.URL),
+	})
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if !resp.Delivered() {
+		t.Errorf("expected notification to be delivered, got status %d", resp.StatusCode)
+	}
+}
+
+func TestSendTestRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+		w.Write([]byte("subscription has expired\n"))
+	}))
+	defer server.Close()
+
+	privKey, pubKey, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatalf("could not generate vapid keys: %s", err)
+	}
+
+	sender := &Sender{
+		pubKey:     pubKey,
+		privKey:    privKey,
+		subscriber: "admin@localhost",
+		limiter:    rate.NewLimiter(rate.Inf, 1),
+	}
+
+	resp, err := sender.SendTest(context.Background(), sql.UserRequest{
+		Subscription: createTestSubscription(t, server.URL),
+	})
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	expected := PushResponse{
+		StatusCode: http.StatusGone,
+		Status:     "Gone",
+		Body:       "subscription has expired",
+	}
+
+	if resp != expected {
+		t.Errorf("expected %#v, got %#v", expected, resp)
+	}
+}
+
+func TestSendTestLimitsBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(strings.Repeat("a", 2*maxPushResponseSize)))
+	}))
+	defer server.Close()
+
+	privKey, pubKey, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatalf("could not generate vapid keys: %s", err)
+	}
+
+	sender := &Sender{
+		pubKey:     pubKey,
+		privKey:    privKey,
+		subscriber: "admin@localhost",
+		limiter:    rate.NewLimiter(rate.Inf, 1),
+	}
+
+	resp, err := sender.SendTest(context.Background(), sql.UserRequest{
+		Subscription: createTestSubscription(t, server.URL),
+	})
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if len(resp.Body) != maxPushResponseSize {
+		t.Errorf("expected body to be cut to %d bytes, got %d", maxPushResponseSize, len(resp.Body))
+	}
+}
+
+func TestSendTestCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	privKey, pubKey, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatalf("could not generate vapid keys: %s", err)
+	}
+
+	sender := &Sender{
+		pubKey:     pubKey,
+		privKey:    privKey,
+		subscriber: "admin@localhost",
+		limiter:    rate.NewLimiter(rate.Inf, 1),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := sender.SendTest(ctx, sql.UserRequest{
+		Subscription: createTestSubscription(t, server.URL),
+	}); err == nil {
+		t.Error("expected error from cancelled request")
+	}
+}
+
+func TestCreateNotificationTextWithMessage(t *testing.T) {
+	notification := redis.NotificationStream{
+		AQI:      50.125,
+		Forecast: redis.AQIIncreasing,
+		Message:  "Wildfire advisory in effect.",
+	}
+
+	expected := []byte("Wildfire advisory in effect.")
+	result := createNotificationText(notification)
+
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("expected notification text to be %s, got %s", expected, result)
+	}
+}
+
+func TestQueueInBatches(t *testing.T) {
+	users := make([]sql.UserRequest, 2*broadcastBatchSize+1)
+	for i := range users {
+		users[i].ID = i + 1
+	}
+
+	var sizes []int
+	queued, err := queueInBatches(context.Background(), users, "test",
+		func(_ context.Context, batch ...redis.NotificationStream) error {
+			sizes = append(sizes, len(batch))
+
+			if batch[0].Message != "test" || batch[0].UID != (len(sizes)-1)*broadcastBatchSize+1 {
+				t.Errorf("got unexpected first notification in batch %d: %+v", len(sizes), batch[0])
+			}
+
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if queued != len(users) {
+		t.Errorf("expected %d users to be queued, got %d", len(users), queued)
+	}
+
+	if expected := []int{broadcastBatchSize, broadcastBatchSize, 1}; !reflect.DeepEqual(sizes, expected) {
+		t.Errorf("expected batches of %v, got %v", expected, sizes)
+	}
+}
+
+func TestQueueInBatchesError(t *testing.T) {
+	users := make([]sql.UserRequest, 2*broadcastBatchSize)
+
+	calls := 0
+	queued, err := queueInBatches(context.Background(), users, "test",
+		func(context.Context, ...redis.NotificationStream) error {
+			if calls++; calls == 2 {
+				return errors.New("stream unavailable")
+			}
+
+			return nil
+		},
+	)
+	if err == nil {
+		t.Fatal("expected error from failed batch")
+	}
+
+	if queued != broadcastBatchSize {
+		t.Errorf("expected only the first batch of %d users to be queued, got %d", broadcastBatchSize, queued)
+	}
+}
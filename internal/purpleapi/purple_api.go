@@ -9,9 +9,14 @@ import (
 	"time"
 
 	jsoniter "github.com/json-iterator/go"
+	"github.com/mrflynn/air-alert/internal/metrics"
+	"github.com/mrflynn/air-alert/internal/tracing"
 	"github.com/shopspring/decimal"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/semconv"
 	"golang.org/x/time/rate"
 )
 
@@ -100,35 +105,63 @@ func decode(r io.ReadCloser) ([]Response, error) {
 }
 
 // Get grabs data from Purple Air's API and returns a list of current measurements.
-func Get(ctx context.Context) ([]Response, error) {
-	var err error
+func Get(ctx context.Context) (data []Response, err error) {
 	var resp *http.Response
 
+	ctx, span := tracing.Start(ctx, "purpleair.get", trace.WithSpanKind(trace.SpanKindClient))
+	defer func() {
+		span.SetAttributes(label.Int("purpleair.sensors", len(data)))
+		tracing.End(span, err)
+	}()
+
 	// Make sure rate limiter has configured value.
 	limiter.SetLimit(rate.Every(viper.GetDuration("purpleair.rate_limit_timeout")))
 
 	for i := 0; i < 5; i++ {
+		span.SetAttributes(label.Int("purpleair.attempts", i+1))
+
 		err = limiter.Wait(ctx)
 		if err != nil {
 			return []Response{}, err
 		}
 
-		resp, err = http.Get(viper.GetString("purpleair.url"))
+		var req *http.Request
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, viper.GetString("purpleair.url"), nil)
+		if err != nil {
+			return []Response{}, err
+		}
+
+		start := time.Now()
+		resp, err = http.DefaultClient.Do(req)
+		metrics.PurpleAirRequestDuration.Observe(time.Since(start).Seconds())
+
 		if err != nil {
+			metrics.PurpleAirFailures.WithLabelValues(metrics.FailureRequest).Inc()
 			return []Response{}, err
 		}
 
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(resp.StatusCode))
+
 		if resp.StatusCode == http.StatusOK {
 			break
 		}
 
+		resp.Body.Close()
+
 		log.Debugf(`hit purple air api rate limit. retrying in %.1f seconds`, limiter.Limit())
 	}
 
 	if resp.StatusCode != http.StatusOK {
+		metrics.PurpleAirFailures.WithLabelValues(metrics.FailureRateLimited).Inc()
 		return []Response{}, errors.New("failed to get sensor data due to rate limiting")
 	}
 
 	defer resp.Body.Close()
-	return decode(resp.Body)
+
+	data, err = decode(resp.Body)
+	if err != nil {
+		metrics.PurpleAirFailures.WithLabelValues(metrics.FailureDecode).Inc()
+	}
+
+	return data, err
 }
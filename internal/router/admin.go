@@ -0,0 +1,450 @@
+package router
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	utils "github.com/mrflynn/air-alert/internal"
+	"github.com/mrflynn/air-alert/internal/database/redis"
+	pg "github.com/mrflynn/air-alert/internal/database/sql"
+	"github.com/mrflynn/air-alert/internal/geo"
+	"github.com/mrflynn/air-alert/internal/notifications"
+	"github.com/mrflynn/air-alert/internal/task"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// adminAuth creates a middleware that only allows requests carrying a bearer token whose SHA-256
+// hash is in the list of hashes. If no hashes are given then every request is rejected.
+func adminAuth(hashes []string) fiber.Handler {
+	if len(hashes) < 1 {
+		log.Warn("no admin tokens configured. admin endpoints are disabled")
+	}
+
+	return func(ctx *fiber.Ctx) error {
+		header := ctx.Get(fiber.HeaderAuthorization)
+		if !strings.HasPrefix(header, "Bearer ") {
+			return errorInfo{
+				err: fiber.ErrUnauthorized,
+				why: "missing bearer token",
+			}
+		}
+
+		hash := []byte(utils.HashToken(strings.TrimPrefix(header, "Bearer ")))
+		for _, h := range hashes {
+			if subtle.ConstantTimeCompare(hash, []byte(strings.ToLower(h))) == 1 {
+				return ctx.Next()
+			}
+		}
+
+		requestLogger(ctx).Warnf("rejected admin request from %s", getClientIP(ctx))
+
+		return errorInfo{
+			err: fiber.ErrUnauthorized,
+			why: "invalid bearer token",
+		}
+	}
+}
+
+type broadcastRequest struct {
+	Message string      `json:"message"`
+	Circle  *geo.Circle `json:"circle,omitempty"`
+	Polygon geo.Polygon `json:"polygon,omitempty"`
+}
+
+func (b broadcastRequest) area() (geo.Area, error) {
+	switch {
+	case b.Circle != nil && b.Polygon != nil:
+		return nil, errorInfo{
+			err: fiber.ErrBadRequest,
+			why: "only one of circle or polygon can be provided",
+		}
+	case b.Circle != nil:
+		if b.Circle.Radius <= 0 {
+			return nil, errorInfo{
+				err: fiber.ErrBadRequest,
+				why: "circle radius must be positive",
+			}
+		} else if err := geo.ValidatePoint(b.Circle.Longitude, b.Circle.Latitude); err != nil {
+			return nil, errorInfo{
+				err: fiber.ErrBadRequest,
+				why: err.Error(),
+			}
+		}
+
+		return *b.Circle, nil
+	case b.Polygon != nil:
+		if err := b.Polygon.Validate(); err != nil {
+			return nil, errorInfo{
+				err: fiber.ErrBadRequest,
+				why: err.Error(),
+			}
+		}
+
+		return b.Polygon, nil
+	default:
+		return nil, nil
+	}
+}
+
+func broadcastMessage(ctx *fiber.Ctx, notifier *notifications.Sender) error {
+	var req broadcastRequest
+
+	err := ctx.BodyParser(&req)
+	if err != nil {
+		requestLogger(ctx).Errorf("could not parse broadcast request: %s", err)
+
+		return errorInfo{
+			err: fiber.ErrBadRequest,
+			why: "could not parse broadcast request",
+		}
+	}
+
+	if req.Message == "" || len(req.Message) > notifications.MaxMessageLength {
+		return errorInfo{
+			err: fiber.ErrBadRequest,
+			why: fmt.Sprintf("message must be between 1 and %d bytes", notifications.MaxMessageLength),
+		}
+	}
+
+	area, err := req.area()
+	if err != nil {
+		return err
+	}
+
+	count, err := notifier.Broadcast(requestContext(ctx), req.Message, area)
+	if err != nil {
+		requestLogger(ctx).Errorf("could not queue broadcast: %s", err)
+
+		return errorInfo{
+			err: fiber.ErrInternalServerError,
+			why: "could not queue broadcast",
+		}
+	}
+
+	ctx.Status(fiber.StatusAccepted)
+
+	return sendJSON(ctx, fiber.Map{"queued": count})
+}
+
+// adminUser is the representation of a user returned by the admin API. Subscription keys are
+// omitted since they are used to authenticate the user.
+type adminUser struct {
+	ID            int        `json:"id"`
+	Endpoint      string     `json:"endpoint"`
+	Longitude     float64    `json:"longitude"`
+	Latitude      float64    `json:"latitude"`
+	AQIThreshold  float64    `json:"threshold"`
+	LastCrossover *time.Time `json:"last_crossover"`
+}
+
+func toAdminUser(u pg.UserRequest) adminUser {
+	user := adminUser{
+		ID:           u.ID,
+		Endpoint:     u.Subscription.Endpoint,
+		Longitude:    u.Longitude,
+		Latitude:     u.Latitude,
+		AQIThreshold: u.AQIThreshold,
+	}
+
+	if u.LastCrossover.Valid {
+		user.LastCrossover = &u.LastCrossover.Time
+	}
+
+	return user
+}
+
+func getIntQuery(ctx *fiber.Ctx, key string, defaultValue int) (int, error) {
+	raw := ctx.Query(key)
+	if raw == "" {
+		return defaultValue, nil
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 0 {
+		return 0, errorInfo{
+			err: fiber.ErrBadRequest,
+			why: fmt.Sprintf("invalid %s parameter", key),
+		}
+	}
+
+	return value, nil
+}
+
+func getFloatQuery(ctx *fiber.Ctx, key string) (float64, error) {
+	value, err := strconv.ParseFloat(ctx.Query(key), 64)
+	if err != nil {
+		return 0, errorInfo{
+			err: fiber.ErrBadRequest,
+			why: fmt.Sprintf("invalid or missing %s parameter", key),
+		}
+	}
+
+	return value, nil
+}
+
+func getSearchArea(ctx *fiber.Ctx) (geo.Area, error) {
+	latitude, err := getFloatQuery(ctx, "latitude")
+	if err != nil {
+		return nil, err
+	}
+
+	longitude, err := getFloatQuery(ctx, "longitude")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := geo.ValidatePoint(longitude, latitude); err != nil {
+		return nil, errorInfo{
+			err: fiber.ErrBadRequest,
+			why: err.Error(),
+		}
+	}
+
+	radius := 2000.0
+	if ctx.Query("radius") != "" {
+		radius, err = getFloatQuery(ctx, "radius")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return geo.Circle{
+		Point:  geo.Point{Latitude: latitude, Longitude: longitude},
+		Radius: radius,
+	}, nil
+}
+
+func listUsers(ctx *fiber.Ctx, database *pg.Controller) error {
+	var (
+		users []pg.UserRequest
+		area  geo.Area
+		err   error
+	)
+
+	// If a location is given, search for users around it. Otherwise page through all users.
+	if ctx.Query("latitude") != "" || ctx.Query("longitude") != "" {
+		area, err = getSearchArea(ctx)
+		if err != nil {
+			return err
+		}
+
+		users, err = database.GetUsersInArea(requestContext(ctx), area)
+	} else {
+		var offset, limit int
+
+		offset, err = getIntQuery(ctx, "offset", 0)
+		if err != nil {
+			return err
+		}
+
+		limit, err = getIntQuery(ctx, "limit", defaultPageSize)
+		if err != nil {
+			return err
+		} else if limit > maxPageSize {
+			limit = maxPageSize
+		}
+
+		users, err = database.GetUsers(requestContext(ctx), offset, limit)
+	}
+
+	if err != nil {
+		requestLogger(ctx).Errorf("could not get users: %s", err)
+
+		return errorInfo{
+			err: fiber.ErrInternalServerError,
+			why: "could not get users from database",
+		}
+	}
+
+	results := make([]adminUser, 0, len(users))
+	for _, u := range users {
+		results = append(results, toAdminUser(u))
+	}
+
+	return sendJSON(ctx, results)
+}
+
+func getUserIDParameter(ctx *fiber.Ctx) (int, error) {
+	id, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return 0, errorInfo{
+			err: fiber.ErrBadRequest,
+			why: "invalid user id",
+		}
+	}
+
+	return id, nil
+}
+
+func getUser(ctx *fiber.Ctx, database *pg.Controller) error {
+	id, err := getUserIDParameter(ctx)
+	if err != nil {
+		return err
+	}
+
+	user, err := database.GetUserWithID(requestContext(ctx), id)
+	if err == sql.ErrNoRows {
+		return errorInfo{
+			err: fiber.ErrNotFound,
+			why: "user not found",
+		}
+	} else if err != nil {
+		requestLogger(ctx).Errorf("could not get user %d: %s", id, err)
+
+		return errorInfo{
+			err: fiber.ErrInternalServerError,
+			why: "could not get user from database",
+		}
+	}
+
+	return sendJSON(ctx, toAdminUser(user))
+}
+
+func deleteUser(ctx *fiber.Ctx, database *pg.Controller) error {
+	id, err := getUserIDParameter(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = database.DeleteUserWithID(requestContext(ctx), id)
+	if err == sql.ErrNoRows {
+		return errorInfo{
+			err: fiber.ErrNotFound,
+			why: "user not found",
+		}
+	} else if err != nil {
+		requestLogger(ctx).Errorf("could not delete user %d: %s", id, err)
+
+		return errorInfo{
+			err: fiber.ErrInternalServerError,
+			why: "could not delete user",
+		}
+	}
+
+	requestLogger(ctx).Infof("admin deleted user %d", id)
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+func getNotificationStreamInfo(ctx *fiber.Ctx, datastore *redis.Controller) error {
+	count, err := getIntQuery(ctx, "count", defaultPageSize)
+	if err != nil {
+		return err
+	} else if count > maxPageSize {
+		count = maxPageSize
+	}
+
+	info, err := datastore.GetNotificationStreamInfo(
+		requestContext(ctx), viper.GetString("web.notifications.group"), int64(count),
+	)
+	if err != nil {
+		requestLogger(ctx).Errorf("could not get notification stream info: %s", err)
+
+		return errorInfo{
+			err: fiber.ErrInternalServerError,
+			why: "could not get notification stream info",
+		}
+	}
+
+	return sendJSON(ctx, info)
+}
+
+func getTaskStatus(ctx *fiber.Ctx, tasks *task.Runner) error {
+	statuses, err := tasks.Status(requestContext(ctx))
+	if err != nil {
+		requestLogger(ctx).Errorf("could not get task status: %s", err)
+
+		return errorInfo{
+			err: fiber.ErrInternalServerError,
+			why: "could not get task status",
+		}
+	}
+
+	return sendJSON(ctx, statuses)
+}
+
+func getTaskHistory(ctx *fiber.Ctx, tasks *task.Runner) error {
+	count, err := getIntQuery(ctx, "count", defaultPageSize)
+	if err != nil {
+		return err
+	} else if count > maxPageSize {
+		count = maxPageSize
+	}
+
+	runs, err := tasks.History(requestContext(ctx), ctx.Params("name"), int64(count))
+	if err == task.ErrTaskNotFound {
+		return errorInfo{
+			err: fiber.ErrNotFound,
+			why: "task not found",
+		}
+	} else if err != nil {
+		requestLogger(ctx).Errorf("could not get task history: %s", err)
+
+		return errorInfo{
+			err: fiber.ErrInternalServerError,
+			why: "could not get task history",
+		}
+	}
+
+	return sendJSON(ctx, runs)
+}
+
+func runTask(ctx *fiber.Ctx, tasks *task.Runner) error {
+	err := tasks.Trigger(requestContext(ctx), ctx.Params("name"))
+	if err == task.ErrTaskNotFound {
+		return errorInfo{
+			err: fiber.ErrNotFound,
+			why: "task not found",
+		}
+	} else if err == task.ErrTaskRunning {
+		return errorInfo{
+			err: fiber.ErrConflict,
+			why: "task is already running",
+		}
+	} else if err != nil {
+		requestLogger(ctx).Errorf("could not trigger task: %s", err)
+
+		return errorInfo{
+			err: fiber.ErrInternalServerError,
+			why: "could not trigger task",
+		}
+	}
+
+	return ctx.SendStatus(fiber.StatusAccepted)
+}
+
+// pauseTask pauses the task if pause is true and resumes it otherwise.
+func pauseTask(ctx *fiber.Ctx, tasks *task.Runner, pause bool) error {
+	action, change := "resume", tasks.Resume
+	if pause {
+		action, change = "pause", tasks.Pause
+	}
+
+	err := change(requestContext(ctx), ctx.Params("name"))
+	if err == task.ErrTaskNotFound {
+		return errorInfo{
+			err: fiber.ErrNotFound,
+			why: "task not found",
+		}
+	} else if err != nil {
+		requestLogger(ctx).Errorf("could not %s task: %s", action, err)
+
+		return errorInfo{
+			err: fiber.ErrInternalServerError,
+			why: fmt.Sprintf("could not %s task", action),
+		}
+	}
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,90 @@
+// +build unit
+
+package router
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	utils "github.com/mrflynn/air-alert/internal"
+)
+
+func createAdminTestApp(hashes ...string) *fiber.App {
+	app := fiber.New(fiber.Config{
+		ErrorHandler: func(ctx *fiber.Ctx, err error) error {
+			if info, ok := err.(errorInfo); ok {
+				return ctx.Status(info.err.Code).SendString(info.why)
+			}
+
+			return ctx.SendStatus(fiber.StatusInternalServerError)
+		},
+	})
+
+	app.Get("/admin", adminAuth(hashes), func(ctx *fiber.Ctx) error {
+		return ctx.SendStatus(fiber.StatusOK)
+	})
+
+	return app
+}
+
+func TestAdminAuth(t *testing.T) {
+	app := createAdminTestApp(utils.HashToken("secret"))
+
+	req := httptest.NewRequest("GET", "/admin", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if resp.StatusCode != fiber.StatusOK {
+		t.Errorf("expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestAdminAuthInvalidToken(t *testing.T) {
+	app := createAdminTestApp(utils.HashToken("secret"))
+
+	req := httptest.NewRequest("GET", "/admin", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if resp.StatusCode != fiber.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", fiber.StatusUnauthorized, resp.StatusCode)
+	}
+}
+
+func TestAdminAuthMissingToken(t *testing.T) {
+	app := createAdminTestApp(utils.HashToken("secret"))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/admin", nil))
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if resp.StatusCode != fiber.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", fiber.StatusUnauthorized, resp.StatusCode)
+	}
+}
+
+func TestAdminAuthNoTokensConfigured(t *testing.T) {
+	app := createAdminTestApp()
+
+	req := httptest.NewRequest("GET", "/admin", nil)
+	req.Header.Set("Authorization", "Bearer ")
+
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if resp.StatusCode != fiber.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", fiber.StatusUnauthorized, resp.StatusCode)
+	}
+}
@@ -0,0 +1,423 @@
+package router
+
+import (
+	stdjson "encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mrflynn/air-alert/internal/database/redis"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	apiV1Prefix  = "/api/v1"
+	apiV1Version = "1.0.0"
+
+	mimeProblemJSON = "application/problem+json"
+)
+
+// envelope wraps every successful response from the versioned API.
+type envelope struct {
+	Data interface{} `json:"data"`
+	Meta *meta       `json:"meta,omitempty"`
+}
+
+// meta contains optional information about the data in an envelope.
+type meta struct {
+	Count int `json:"count" description:"Number of items in data."`
+}
+
+// problem is an RFC 7807 problem details object.
+type problem struct {
+	Type     string `json:"type" description:"URI reference identifying the problem type."`
+	Title    string `json:"title" description:"Short summary of the problem type."`
+	Status   int    `json:"status" description:"HTTP status code."`
+	Detail   string `json:"detail,omitempty" description:"Explanation specific to this occurrence of the problem."`
+	Instance string `json:"instance,omitempty" description:"URI reference identifying this occurrence of the problem."`
+}
+
+type locationAQI struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Radius    float64 `json:"radius" description:"Search radius in meters."`
+	AQI       float64 `json:"aqi" description:"Average of the most recent AQI readings in range."`
+	Readings  int     `json:"readings" description:"Number of readings included in the average."`
+}
+
+type reading struct {
+	Time time.Time `json:"time"`
+	PM25 float64   `json:"pm25" description:"PM2.5 concentration in µg/m³."`
+	AQI  float64   `json:"aqi"`
+}
+
+type sensorReadings struct {
+	SensorID int       `json:"sensor_id"`
+	Readings []reading `json:"readings" description:"Readings ordered from newest to oldest."`
+}
+
+// apiRoute describes a single versioned API endpoint. The same description is used to register
+// the route and to document it in the OpenAPI specification.
+type apiRoute struct {
+	Method      string
+	Path        string
+	Summary     string
+	OperationID string
+	Tags        []string
+	Parameters  []openAPIParameter
+	// Response is a value of the type wrapped in the data field of the response envelope.
+	Response interface{}
+	// Bare routes send Response as is instead of wrapping it in an envelope.
+	Bare bool
+	// MediaType of successful responses. Defaults to application/json.
+	MediaType string
+	// Exports routes can also send their readings as CSV or NDJSON.
+	Exports bool
+	Handler fiber.Handler
+}
+
+var locationParameters = []openAPIParameter{
+	{
+		Name:     "latitude",
+		In:       "path",
+		Required: true,
+		Schema: &openAPISchema{
+			Type: "number", Format: "double", Minimum: float64Ptr(-90), Maximum: float64Ptr(90),
+		},
+	},
+	{
+		Name:     "longitude",
+		In:       "path",
+		Required: true,
+		Schema: &openAPISchema{
+			Type: "number", Format: "double", Minimum: float64Ptr(-180), Maximum: float64Ptr(180),
+		},
+	},
+	{
+		Name:        "radius",
+		In:          "query",
+		Description: "Search radius in meters.",
+		Schema:      &openAPISchema{Type: "number", Format: "double", Default: 2000.0},
+	},
+}
+
+var formatParameter = openAPIParameter{
+	Name:        "format",
+	In:          "query",
+	Description: "Format of the response. Takes precedence over the Accept header.",
+	Schema:      &openAPISchema{Type: "string", Enum: []string{formatJSON, formatCSV, formatNDJSON}},
+}
+
+var sensorAreaParameters = []openAPIParameter{
+	{
+		Name:        "bbox",
+		In:          "query",
+		Description: "Bounding box as minLat,minLon,maxLat,maxLon. Takes precedence over latitude and longitude.",
+		Schema:      &openAPISchema{Type: "string"},
+	},
+	{
+		Name:   "latitude",
+		In:     "query",
+		Schema: &openAPISchema{Type: "number", Format: "double", Minimum: float64Ptr(-90), Maximum: float64Ptr(90)},
+	},
+	{
+		Name:   "longitude",
+		In:     "query",
+		Schema: &openAPISchema{Type: "number", Format: "double", Minimum: float64Ptr(-180), Maximum: float64Ptr(180)},
+	},
+	locationParameters[2],
+}
+
+func (r *Router) apiV1Routes() []apiRoute {
+	return []apiRoute{
+		{
+			Method:      fiber.MethodGet,
+			Path:        "/locations/:latitude/:longitude/aqi",
+			Summary:     "Get the average AQI around a location",
+			OperationID: "getLocationAQI",
+			Tags:        []string{"locations"},
+			Parameters:  locationParameters,
+			Response:    locationAQI{},
+			Handler: func(ctx *fiber.Ctx) error {
+				return getLocationAQI(ctx, r.datastore)
+			},
+		},
+		{
+			Method:      fiber.MethodGet,
+			Path:        "/locations/:latitude/:longitude/readings",
+			Summary:     "Get recent readings from sensors around a location",
+			OperationID: "getLocationReadings",
+			Tags:        []string{"locations"},
+			Parameters:  locationParameters,
+			Response:    []sensorReadings{},
+			Exports:     true,
+			Handler: func(ctx *fiber.Ctx) error {
+				return getLocationReadings(ctx, r.datastore)
+			},
+		},
+		{
+			Method:      fiber.MethodGet,
+			Path:        "/sensors",
+			Summary:     "Get sensors in an area as GeoJSON",
+			OperationID: "getSensors",
+			Tags:        []string{"sensors"},
+			Parameters:  sensorAreaParameters,
+			Response:    featureCollection{},
+			Bare:        true,
+			MediaType:   mimeGeoJSON,
+			Handler: func(ctx *fiber.Ctx) error {
+				return getSensorFeatures(ctx, r.datastore)
+			},
+		},
+		{
+			Method:      fiber.MethodGet,
+			Path:        "/sensors/viewport",
+			Summary:     "Get sensors in a map viewport as GeoJSON, clustered when zoomed out",
+			OperationID: "getViewportSensors",
+			Tags:        []string{"sensors"},
+			Parameters: []openAPIParameter{
+				{
+					Name:        "bbox",
+					In:          "query",
+					Description: "Bounding box of the viewport as minLat,minLon,maxLat,maxLon.",
+					Required:    true,
+					Schema:      &openAPISchema{Type: "string"},
+				},
+				{
+					Name:        "zoom",
+					In:          "query",
+					Description: "Zoom level of the map. Estimated from the bounding box if omitted.",
+					Schema:      &openAPISchema{Type: "integer", Minimum: float64Ptr(0), Maximum: float64Ptr(maxZoom)},
+				},
+			},
+			Response:  featureCollection{},
+			Bare:      true,
+			MediaType: mimeGeoJSON,
+			Handler: func(ctx *fiber.Ctx) error {
+				return getViewportFeatures(ctx, r.datastore)
+			},
+		},
+		{
+			Method:      fiber.MethodGet,
+			Path:        "/sensors/:id/readings",
+			Summary:     "Get the reading history of a sensor",
+			OperationID: "getSensorReadings",
+			Tags:        []string{"sensors"},
+			Parameters: []openAPIParameter{
+				{
+					Name:     "id",
+					In:       "path",
+					Required: true,
+					Schema:   &openAPISchema{Type: "integer", Format: "int32"},
+				},
+			},
+			Response: sensorReadings{},
+			Exports:  true,
+			Handler: func(ctx *fiber.Ctx) error {
+				return getSensorReadings(ctx, r.datastore)
+			},
+		},
+	}
+}
+
+// addAPIV1Routes registers the versioned API along with its OpenAPI specification.
+func (r *Router) addAPIV1Routes(handlers ...fiber.Handler) {
+	routes := r.apiV1Routes()
+
+	// The document never changes, so it only needs to be encoded once.
+	document, err := stdjson.Marshal(buildOpenAPIDocument(apiV1Prefix, apiV1Version, routes))
+	if err != nil {
+		log.Fatalf("could not encode openapi document: %s", err)
+	}
+
+	api := r.app.Group(apiV1Prefix, append([]fiber.Handler{problemDetails}, handlers...)...)
+	for _, route := range routes {
+		api.Add(route.Method, route.Path, route.Handler)
+	}
+
+	api.Get("/openapi.json", func(ctx *fiber.Ctx) error {
+		return ctx.Type("json", "utf-8").Send(document)
+	})
+
+	// Fiber writes a plain text response when no route matches, so unknown paths under the API
+	// prefix are caught here instead to keep every error a problem details response.
+	api.Use(func(ctx *fiber.Ctx) error {
+		return fiber.ErrNotFound
+	})
+}
+
+// problemDetails is a middleware that converts errors returned by later handlers into RFC 7807
+// problem details responses.
+func problemDetails(ctx *fiber.Ctx) error {
+	err := ctx.Next()
+	if err == nil {
+		return nil
+	}
+
+	status := fiber.StatusInternalServerError
+	detail := ""
+
+	switch e := err.(type) {
+	case errorInfo:
+		status = e.err.Code
+		detail = e.why
+	case *fiber.Error:
+		status = e.Code
+		detail = e.Message
+	default:
+		requestLogger(ctx).Errorf("unexpected api error: %s", err)
+	}
+
+	return sendProblem(ctx, status, detail)
+}
+
+func sendProblem(ctx *fiber.Ctx, status int, detail string) error {
+	ctx.Status(status)
+
+	err := sendJSON(ctx, problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: ctx.OriginalURL(),
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx.Set(fiber.HeaderContentType, mimeProblemJSON)
+
+	return nil
+}
+
+func sendEnvelope(ctx *fiber.Ctx, data interface{}, m *meta) error {
+	return sendJSON(ctx, envelope{Data: data, Meta: m})
+}
+
+func toReadings(data []*redis.RawQualityData) []reading {
+	readings := make([]reading, 0, len(data))
+	for _, d := range data {
+		readings = append(readings, reading{
+			Time: time.Unix(int64(d.Time), 0).UTC(),
+			PM25: d.PM25,
+			AQI:  d.AQI,
+		})
+	}
+
+	sort.Slice(readings, func(i, j int) bool {
+		return readings[i].Time.After(readings[j].Time)
+	})
+
+	return readings
+}
+
+func getLocationAQI(ctx *fiber.Ctx, datastore *redis.Controller) error {
+	long, lat, radius, err := getLocationParameters(ctx)
+	if err != nil {
+		return err
+	}
+
+	aqi, count, err := computeAverageAQI(requestContext(ctx), datastore, long, lat, radius)
+	if err != nil {
+		return err
+	}
+
+	aqi, _ = decimal.NewFromFloat(aqi).Round(1).Float64()
+
+	return sendEnvelope(ctx, locationAQI{
+		Latitude:  lat,
+		Longitude: long,
+		Radius:    radius,
+		AQI:       aqi,
+		Readings:  count,
+	}, nil)
+}
+
+func getLocationReadings(ctx *fiber.Ctx, datastore *redis.Controller) error {
+	long, lat, radius, err := getLocationParameters(ctx)
+	if err != nil {
+		return err
+	}
+
+	format, err := getExportFormat(ctx)
+	if err != nil {
+		return err
+	} else if format != formatJSON {
+		return exportReadingsInRange(ctx, datastore, format, long, lat, radius)
+	}
+
+	results, err := datastore.GetAQIFromSensorsInRange(requestContext(ctx), long, lat, radius)
+	if err != nil {
+		requestLogger(ctx).Errorf("database error: %s", err)
+
+		return errorInfo{
+			err: fiber.ErrInternalServerError,
+			why: "could not get sensor data from database",
+		}
+	}
+
+	sensors := make([]sensorReadings, 0, len(results))
+	for _, r := range results {
+		sensors = append(sensors, sensorReadings{
+			SensorID: r.ID,
+			Readings: toReadings(r.Data),
+		})
+	}
+
+	sort.Slice(sensors, func(i, j int) bool {
+		return sensors[i].SensorID < sensors[j].SensorID
+	})
+
+	return sendEnvelope(ctx, sensors, &meta{Count: len(sensors)})
+}
+
+func getSensorReadings(ctx *fiber.Ctx, datastore *redis.Controller) error {
+	id, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil || id < 0 {
+		return errorInfo{
+			err: fiber.ErrBadRequest,
+			why: "invalid sensor id",
+		}
+	}
+
+	format, err := getExportFormat(ctx)
+	if err != nil {
+		return err
+	} else if format != formatJSON {
+		return exportSensorReadings(ctx, datastore, format, id)
+	}
+
+	data, err := datastore.GetTimeSeriesData(requestContext(ctx), -1, id)
+	if err != nil {
+		requestLogger(ctx).Errorf("GetTimeSeriesData error: %s", err)
+
+		return errorInfo{
+			err: fiber.ErrInternalServerError,
+			why: "could not get sensor data from database",
+		}
+	}
+
+	if len(data) == 0 {
+		return errorInfo{
+			err: fiber.ErrNotFound,
+			why: "no readings found for sensor",
+		}
+	}
+
+	raw := make([]*redis.RawQualityData, 0, len(data))
+	for key, item := range data {
+		if key.ID() == id {
+			raw = append(raw, item)
+		}
+	}
+
+	readings := toReadings(raw)
+
+	return sendEnvelope(ctx, sensorReadings{
+		SensorID: id,
+		Readings: readings,
+	}, &meta{Count: len(readings)})
+}
@@ -0,0 +1,380 @@
+// +build unit
+
+package router
+
+import (
+	"context"
+	stdjson "encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/mrflynn/air-alert/internal/database/redis"
+	"github.com/mrflynn/air-alert/internal/purpleapi"
+	"github.com/spf13/viper"
+)
+
+func createAPIV1TestApp() *Router {
+	r := &Router{app: fiber.New()}
+	r.addAPIV1Routes()
+
+	return r
+}
+
+// validateSchema checks that value, which must be decoded from JSON into interface{} values,
+// conforms to schema. It only supports the parts of OpenAPI schemas that are created by
+// schemaGenerator.
+func validateSchema(document openAPIDocument, schema *openAPISchema, value interface{}, path string) error {
+	if schema.Ref != "" {
+		name := strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+
+		resolved, ok := document.Components.Schemas[name]
+		if !ok {
+			return fmt.Errorf("%s: unknown schema reference %s", path, schema.Ref)
+		}
+
+		return validateSchema(document, resolved, value, path)
+	}
+
+	if value == nil {
+		if schema.Nullable {
+			return nil
+		}
+
+		return fmt.Errorf("%s: unexpected null value", path)
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected object, got %T", path, value)
+		}
+
+		for _, name := range schema.Required {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%s: missing required property %s", path, name)
+			}
+		}
+
+		for name, v := range object {
+			property, ok := schema.Properties[name]
+			if !ok {
+				if len(schema.Properties) > 0 {
+					return fmt.Errorf("%s: unexpected property %s", path, name)
+				}
+
+				continue
+			}
+
+			if err := validateSchema(document, property, v, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected array, got %T", path, value)
+		}
+
+		for i, item := range items {
+			if err := validateSchema(document, schema.Items, item, path+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+	case "number", "integer":
+		number, ok := value.(float64)
+		if !ok {
+			return fmt.Errorf("%s: expected number, got %T", path, value)
+		}
+
+		if schema.Type == "integer" && number != math.Trunc(number) {
+			return fmt.Errorf("%s: expected integer, got %v", path, number)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected string, got %T", path, value)
+		}
+
+		if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				return fmt.Errorf("%s: invalid date-time: %s", path, err)
+			}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected boolean, got %T", path, value)
+		}
+	}
+
+	return nil
+}
+
+func validateJSON(t *testing.T, document openAPIDocument, schema *openAPISchema, body []byte) {
+	t.Helper()
+
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		t.Fatalf("could not decode response %q: %s", body, err)
+	}
+
+	if err := validateSchema(document, schema, value, "$"); err != nil {
+		t.Errorf("response %s does not match schema: %s", body, err)
+	}
+}
+
+func TestOpenAPIDocumentContainsRoutes(t *testing.T) {
+	r := &Router{}
+	routes := r.apiV1Routes()
+	document := buildOpenAPIDocument(apiV1Prefix, apiV1Version, routes)
+
+	for _, route := range routes {
+		path := toOpenAPIPath(route.Path)
+
+		item, ok := document.Paths[path]
+		if !ok {
+			t.Errorf("path %s is missing from document", path)
+			continue
+		}
+
+		operation := *item.operation(route.Method)
+		if operation == nil {
+			t.Errorf("operation %s %s is missing from document", route.Method, path)
+			continue
+		}
+
+		if operation.OperationID != route.OperationID {
+			t.Errorf("expected operation id %s, got %s", route.OperationID, operation.OperationID)
+		}
+
+		for _, match := range pathParamRegex.FindAllStringSubmatch(route.Path, -1) {
+			found := false
+			for _, p := range operation.Parameters {
+				if p.In == "path" && p.Name == match[1] {
+					found = true
+				}
+			}
+
+			if !found {
+				t.Errorf("path parameter %s of %s is not documented", match[1], path)
+			}
+		}
+
+		for _, status := range []string{"200", "400", "default"} {
+			if _, ok := operation.Responses[status]; !ok {
+				t.Errorf("response %s of %s %s is not documented", status, route.Method, path)
+			}
+		}
+	}
+}
+
+func TestToOpenAPIPath(t *testing.T) {
+	got := toOpenAPIPath("/locations/:latitude/:longitude/aqi")
+	if got != "/locations/{latitude}/{longitude}/aqi" {
+		t.Errorf("got unexpected path %s", got)
+	}
+}
+
+func TestServeOpenAPIDocument(t *testing.T) {
+	r := createAPIV1TestApp()
+
+	resp, err := r.app.Test(httptest.NewRequest("GET", "/api/v1/openapi.json", nil))
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+	}
+
+	var document openAPIDocument
+	if err := stdjson.NewDecoder(resp.Body).Decode(&document); err != nil {
+		t.Fatalf("could not decode document: %s", err)
+	}
+
+	if document.OpenAPI != "3.0.3" {
+		t.Errorf("got unexpected openapi version %s", document.OpenAPI)
+	}
+
+	if len(document.Paths) != len(r.apiV1Routes()) {
+		t.Errorf("expected %d paths, got %d", len(r.apiV1Routes()), len(document.Paths))
+	}
+}
+
+func TestProblemDetailsConformToSpec(t *testing.T) {
+	r := createAPIV1TestApp()
+	document := buildOpenAPIDocument(apiV1Prefix, apiV1Version, r.apiV1Routes())
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/api/v1/locations/abc/-122.4/aqi", fiber.StatusBadRequest},
+		{"/api/v1/locations/37.7/abc/readings", fiber.StatusBadRequest},
+		{"/api/v1/locations/37.7/-122.4/readings?radius=abc", fiber.StatusBadRequest},
+		{"/api/v1/sensors/abc/readings", fiber.StatusBadRequest},
+		{"/api/v1/unknown", fiber.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		resp, err := r.app.Test(httptest.NewRequest("GET", test.path, nil))
+		if err != nil {
+			t.Fatalf("got unexpected error: %s", err)
+		}
+
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.path, test.status, resp.StatusCode)
+		}
+
+		if contentType := resp.Header.Get(fiber.HeaderContentType); contentType != mimeProblemJSON {
+			t.Errorf("%s: expected content type %s, got %s", test.path, mimeProblemJSON, contentType)
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("could not read body: %s", err)
+		}
+
+		operation := document.Paths["/locations/{latitude}/{longitude}/aqi"].Get
+		schema := operation.Responses["400"].Content[mimeProblemJSON].Schema
+		validateJSON(t, document, schema, body)
+
+		var p problem
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Fatalf("could not decode problem: %s", err)
+		}
+
+		if p.Status != test.status || p.Instance != test.path {
+			t.Errorf("%s: got unexpected problem %+v", test.path, p)
+		}
+	}
+}
+
+// createAPIV1TestStore creates a datastore backed by an in-memory Redis server that holds readings
+// from two sensors, 1 and 2, near 37.7,-122.4.
+func createAPIV1TestStore(t *testing.T) *redis.Controller {
+	t.Helper()
+
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("could not start redis server: %s", err)
+	}
+	t.Cleanup(server.Close)
+
+	viper.Set("database.redis.addr", server.Addr())
+	viper.Set("database.redis.ingest_batch_size", 500)
+	viper.Set("aqi_cache.geohash_precision", 6)
+
+	datastore, err := redis.NewController(redis.WithAQIRefreshInterval(5 * time.Minute))
+	if err != nil {
+		t.Fatalf("could not create datastore: %s", err)
+	}
+	t.Cleanup(func() { datastore.Shutdown() })
+
+	now := time.Now().Unix()
+	data := []purpleapi.Response{
+		{ID: 1, Location: purpleapi.Outside, LastUpdated: now, Latitude: 37.7, Longitude: -122.4, PM25: 12.5},
+		{ID: 2, Location: purpleapi.Outside, LastUpdated: now, Latitude: 37.701, Longitude: -122.401, PM25: 30},
+	}
+
+	ctx := context.Background()
+	if err := datastore.SetSensorLocationData(ctx, data); err != nil {
+		t.Fatalf("could not store sensor locations: %s", err)
+	}
+
+	if _, _, err := datastore.SetAirQuality(ctx, data); err != nil {
+		t.Fatalf("could not store readings: %s", err)
+	}
+
+	return datastore
+}
+
+func TestEnvelopesConformToSpec(t *testing.T) {
+	r := &Router{app: fiber.New(), datastore: createAPIV1TestStore(t)}
+	r.addAPIV1Routes()
+
+	document := buildOpenAPIDocument(apiV1Prefix, apiV1Version, r.apiV1Routes())
+
+	tests := []struct {
+		path     string
+		spec     string
+		mimeType string
+		// contains is part of the expected body, since empty responses conform to the spec too.
+		contains string
+	}{
+		{
+			"/api/v1/locations/37.7/-122.4/aqi",
+			"/locations/{latitude}/{longitude}/aqi", fiber.MIMEApplicationJSON, `"readings":2`,
+		},
+		{
+			"/api/v1/locations/37.7/-122.4/readings",
+			"/locations/{latitude}/{longitude}/readings", fiber.MIMEApplicationJSON, `"sensor_id":2`,
+		},
+		{
+			"/api/v1/sensors/1/readings",
+			"/sensors/{id}/readings", fiber.MIMEApplicationJSON, `"pm25":12.5`,
+		},
+		{
+			"/api/v1/sensors?bbox=37.6,-122.5,37.8,-122.3",
+			"/sensors", mimeGeoJSON, `"sensor_id":2`,
+		},
+		{
+			"/api/v1/sensors/viewport?bbox=37.6,-122.5,37.8,-122.3&zoom=14",
+			"/sensors/viewport", mimeGeoJSON, `"sensor_id":2`,
+		},
+	}
+
+	for _, test := range tests {
+		resp, err := r.app.Test(httptest.NewRequest("GET", test.path, nil))
+		if err != nil {
+			t.Fatalf("%s: got unexpected error: %s", test.path, err)
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("%s: could not read body: %s", test.path, err)
+		}
+
+		if resp.StatusCode != fiber.StatusOK {
+			t.Errorf("%s: expected status %d, got %d: %s", test.path, fiber.StatusOK, resp.StatusCode, body)
+			continue
+		}
+
+		if !strings.Contains(string(body), test.contains) {
+			t.Errorf("%s: expected response to contain %s, got %s", test.path, test.contains, body)
+		}
+
+		schema := document.Paths[test.spec].Get.Responses["200"].Content[test.mimeType].Schema
+		validateJSON(t, document, schema, body)
+	}
+}
+
+func TestToReadingsOrder(t *testing.T) {
+	readings := toReadings([]*redis.RawQualityData{
+		{Time: 1600000000, PM25: 1},
+		{Time: 1600000120, PM25: 3},
+		{Time: 1600000060, PM25: 2},
+	})
+
+	if len(readings) != 3 {
+		t.Fatalf("expected 3 readings, got %d", len(readings))
+	}
+
+	for i, expected := range []float64{3, 2, 1} {
+		if readings[i].PM25 != expected {
+			t.Errorf("expected reading %d to have pm25 %v, got %v", i, expected, readings[i].PM25)
+		}
+	}
+
+	if readings[0].Time.Location() != time.UTC {
+		t.Errorf("expected UTC time, got %s", readings[0].Time.Location())
+	}
+}
@@ -0,0 +1,93 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const clientIPKey = "client_ip"
+
+// parseTrustedProxies parses a list of IP addresses and CIDR ranges of proxies whose address
+// headers are trusted.
+func parseTrustedProxies(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf(`invalid trusted proxy address "%s"`, value)
+			}
+
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid trusted proxy range "%s": %s`, value, err)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+func isTrusted(ip net.IP, proxies []*net.IPNet) bool {
+	for _, network := range proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clientIP is a middleware that finds the address of the client that sent a request. Requests from
+// trusted proxies are attributed to the last untrusted address in header, which may list several
+// addresses like X-Forwarded-For. Proxies append the address they received the request from, so
+// addresses before it could have been set by the client and aren't used. Headers of other requests
+// are ignored.
+func clientIP(header string, proxies []*net.IPNet) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		remote := ctx.Context().RemoteIP()
+		ip := remote.String()
+
+		if header != "" && isTrusted(remote, proxies) {
+			addresses := strings.Split(ctx.Get(header), ",")
+			for i := len(addresses) - 1; i >= 0; i-- {
+				address := net.ParseIP(strings.TrimSpace(addresses[i]))
+				if address == nil {
+					break
+				}
+
+				ip = address.String()
+				if !isTrusted(address, proxies) {
+					break
+				}
+			}
+		}
+
+		ctx.Locals(clientIPKey, ip)
+
+		return ctx.Next()
+	}
+}
+
+// getClientIP returns the address of the client that sent the request, as found by the clientIP
+// middleware.
+func getClientIP(ctx *fiber.Ctx) string {
+	if ip, ok := ctx.Locals(clientIPKey).(string); ok {
+		return ip
+	}
+
+	return ctx.IP()
+}
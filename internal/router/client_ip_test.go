@@ -0,0 +1,73 @@
+// +build unit
+
+package router
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestClientIP(t *testing.T) {
+	// Requests made with app.Test come from 0.0.0.0.
+	tests := []struct {
+		name      string
+		trusted   []string
+		forwarded string
+		expected  string
+	}{
+		{"untrusted proxy", []string{"10.0.0.0/8"}, "1.2.3.4", "0.0.0.0"},
+		{"trusted proxy", []string{"0.0.0.0"}, "1.2.3.4", "1.2.3.4"},
+		{"spoofed address", []string{"0.0.0.0", "10.0.0.0/8"}, "5.6.7.8, 1.2.3.4, 10.0.0.5", "1.2.3.4"},
+		{"only proxies", []string{"0.0.0.0", "10.0.0.0/8"}, "10.0.0.5", "10.0.0.5"},
+		{"missing header", []string{"0.0.0.0"}, "", "0.0.0.0"},
+		{"invalid header", []string{"0.0.0.0"}, "unknown", "0.0.0.0"},
+	}
+
+	for _, test := range tests {
+		proxies, err := parseTrustedProxies(test.trusted)
+		if err != nil {
+			t.Fatalf("%s: got unexpected error: %s", test.name, err)
+		}
+
+		app := fiber.New()
+		app.Use(clientIP(fiber.HeaderXForwardedFor, proxies))
+		app.Get("/", func(ctx *fiber.Ctx) error {
+			return ctx.SendString(getClientIP(ctx))
+		})
+
+		req := httptest.NewRequest("GET", "/", nil)
+		if test.forwarded != "" {
+			req.Header.Set(fiber.HeaderXForwardedFor, test.forwarded)
+		}
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("%s: got unexpected error: %s", test.name, err)
+		}
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		if string(body) != test.expected {
+			t.Errorf("%s: expected client ip %s, got %s", test.name, test.expected, body)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"10.244.0.0/16", "192.168.1.1", "::1"})
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if len(proxies) != 3 || proxies[1].String() != "192.168.1.1/32" || proxies[2].String() != "::1/128" {
+		t.Errorf("got unexpected proxies %v", proxies)
+	}
+
+	for _, value := range []string{"10.0.0.0/33", "proxy"} {
+		if _, err := parseTrustedProxies([]string{value}); err == nil {
+			t.Errorf("expected error for %s", value)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package router
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/mrflynn/air-alert/internal/database/redis"
+	"github.com/mrflynn/air-alert/internal/logging"
+)
+
+const (
+	formatJSON   = "json"
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+
+	mimeCSV    = "text/csv"
+	mimeNDJSON = "application/x-ndjson"
+
+	// exportBatchSize is the number of sensors whose readings are fetched at once while streaming
+	// an export.
+	exportBatchSize = 50
+	// exportTimeout is how long fetching the readings of a whole export may take.
+	exportTimeout = 30 * time.Second
+)
+
+// readingRow is a single reading in a CSV or NDJSON export.
+type readingRow struct {
+	SensorID int       `json:"sensor_id"`
+	Time     time.Time `json:"time"`
+	PM25     float64   `json:"pm25"`
+	AQI      float64   `json:"aqi"`
+}
+
+// getExportFormat returns the format that readings should be sent in. The format query parameter
+// takes precedence over the Accept header.
+func getExportFormat(ctx *fiber.Ctx) (string, error) {
+	switch format := strings.ToLower(ctx.Query("format")); format {
+	case formatJSON, formatCSV, formatNDJSON:
+		return format, nil
+	case "":
+	default:
+		return "", errorInfo{
+			err: fiber.ErrBadRequest,
+			why: "invalid format parameter, expected json, csv or ndjson",
+		}
+	}
+
+	format, ok := negotiateFormat(ctx.Get(fiber.HeaderAccept))
+	if !ok {
+		return "", errorInfo{
+			err: fiber.ErrNotAcceptable,
+			why: "readings are only available as json, csv or ndjson",
+		}
+	}
+
+	return format, nil
+}
+
+// exportMediaTypes maps every accepted media type to its export format.
+var exportMediaTypes = []struct {
+	mediaType string
+	format    string
+}{
+	{fiber.MIMEApplicationJSON, formatJSON},
+	{mimeCSV, formatCSV},
+	{mimeNDJSON, formatNDJSON},
+	{"application/ndjson", formatNDJSON},
+}
+
+// negotiateFormat picks the export format preferred by an Accept header. Media ranges are
+// weighted by their quality values, and earlier ranges win ties. JSON is used if the header is
+// empty.
+func negotiateFormat(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return formatJSON, true
+	}
+
+	var (
+		best    string
+		quality float64
+	)
+
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaRange := strings.ToLower(strings.TrimSpace(params[0]))
+
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if value, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = value
+				}
+			}
+		}
+
+		if q <= quality {
+			continue
+		}
+
+		for _, m := range exportMediaTypes {
+			if mediaRange == m.mediaType || mediaRange == "*/*" ||
+				(strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(m.mediaType, mediaRange[:len(mediaRange)-1])) {
+				best, quality = m.format, q
+				break
+			}
+		}
+	}
+
+	return best, best != ""
+}
+
+// rowWriter encodes reading rows in an export format.
+type rowWriter interface {
+	Write(row readingRow) error
+	Flush() error
+}
+
+type csvRowWriter struct {
+	w   *bufio.Writer
+	csv *csv.Writer
+}
+
+func (c *csvRowWriter) Write(row readingRow) error {
+	return c.csv.Write([]string{
+		strconv.Itoa(row.SensorID),
+		row.Time.Format(time.RFC3339),
+		strconv.FormatFloat(row.PM25, 'f', -1, 64),
+		strconv.FormatFloat(row.AQI, 'f', -1, 64),
+	})
+}
+
+func (c *csvRowWriter) Flush() error {
+	c.csv.Flush()
+	if err := c.csv.Error(); err != nil {
+		return err
+	}
+
+	return c.w.Flush()
+}
+
+type ndjsonRowWriter struct {
+	w       *bufio.Writer
+	encoder *jsoniter.Encoder
+}
+
+func (n *ndjsonRowWriter) Write(row readingRow) error {
+	// The encoder ends every value with a newline.
+	return n.encoder.Encode(row)
+}
+
+func (n *ndjsonRowWriter) Flush() error {
+	return n.w.Flush()
+}
+
+// newRowWriter creates a rowWriter for the given format. CSV exports start with a header row.
+func newRowWriter(format string, w *bufio.Writer) (rowWriter, error) {
+	if format == formatNDJSON {
+		return &ndjsonRowWriter{w: w, encoder: json.NewEncoder(w)}, nil
+	}
+
+	writer := &csvRowWriter{w: w, csv: csv.NewWriter(w)}
+	if err := writer.csv.Write([]string{"sensor_id", "time", "pm25", "aqi"}); err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+// toReadingRows flattens time series data into rows ordered by sensor and then from newest to
+// oldest.
+func toReadingRows(data map[redis.UnionKey]*redis.RawQualityData) []readingRow {
+	rows := make([]readingRow, 0, len(data))
+	for key, item := range data {
+		rows = append(rows, readingRow{
+			SensorID: key.ID(),
+			Time:     time.Unix(int64(item.Time), 0).UTC(),
+			PM25:     item.PM25,
+			AQI:      item.AQI,
+		})
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].SensorID != rows[j].SensorID {
+			return rows[i].SensorID < rows[j].SensorID
+		}
+
+		return rows[i].Time.After(rows[j].Time)
+	})
+
+	return rows
+}
+
+// writeReadings fetches the readings of the given sensors in batches and writes them as rows,
+// so that only one batch is held in memory at a time. At most count readings are written per
+// sensor, or all of them if count is negative.
+func writeReadings(ctx context.Context, w *bufio.Writer, datastore *redis.Controller, format string, count int64, ids []int) error {
+	rows, err := newRowWriter(format, w)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(ids); start += exportBatchSize {
+		end := start + exportBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		data, err := datastore.GetTimeSeriesData(ctx, count, ids[start:end]...)
+		if err != nil {
+			logging.FromContext(ctx).Errorf("GetTimeSeriesData error: %s", err)
+			return err
+		}
+
+		for _, row := range toReadingRows(data) {
+			if err := rows.Write(row); err != nil {
+				return err
+			}
+		}
+
+		if err := rows.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return rows.Flush()
+}
+
+// streamReadings sends the readings of the given sensors as CSV or NDJSON. Rows are written while
+// they are fetched, so an error after the response has started can only cut the export short.
+func streamReadings(ctx *fiber.Ctx, datastore *redis.Controller, format string, count int64, ids []int) error {
+	switch format {
+	case formatCSV:
+		ctx.Set(fiber.HeaderContentType, mimeCSV+"; charset=utf-8")
+		ctx.Set(fiber.HeaderContentDisposition, `attachment; filename="readings.csv"`)
+	case formatNDJSON:
+		ctx.Set(fiber.HeaderContentType, mimeNDJSON)
+	}
+
+	logger := requestLogger(ctx)
+
+	ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		c, cancel := context.WithTimeout(logging.WithLogger(context.Background(), logger), exportTimeout)
+		defer cancel()
+
+		// Most errors here come from clients that went away before the export was done.
+		if err := writeReadings(c, w, datastore, format, count, ids); err != nil {
+			logger.Debugf("readings export stopped early: %s", err)
+		}
+	})
+
+	return nil
+}
+
+// exportReadingsInRange streams the recent readings of every sensor in the given radius around a
+// point.
+func exportReadingsInRange(ctx *fiber.Ctx, datastore *redis.Controller, format string, long, lat, radius float64) error {
+	ids, err := datastore.GetSensorsInRange(requestContext(ctx), long, lat, radius)
+	if err != nil {
+		requestLogger(ctx).Errorf("GetSensorsInRange error: %s", err)
+
+		return errorInfo{
+			err: fiber.ErrInternalServerError,
+			why: "could not get sensor ids from database",
+		}
+	}
+
+	sort.Ints(ids)
+
+	return streamReadings(ctx, datastore, format, redis.RecentReadingsCount, ids)
+}
+
+// exportSensorReadings streams the reading history of a single sensor.
+func exportSensorReadings(ctx *fiber.Ctx, datastore *redis.Controller, format string, id int) error {
+	sensors, err := datastore.GetSensorLocations(requestContext(ctx), id)
+	if err != nil {
+		requestLogger(ctx).Errorf("GetSensorLocations error: %s", err)
+
+		return errorInfo{
+			err: fiber.ErrInternalServerError,
+			why: "could not get sensor from database",
+		}
+	}
+
+	if len(sensors) < 1 {
+		return errorInfo{
+			err: fiber.ErrNotFound,
+			why: "sensor not found",
+		}
+	}
+
+	return streamReadings(ctx, datastore, format, -1, []int{id})
+}
@@ -0,0 +1,185 @@
+// +build unit
+
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mrflynn/air-alert/internal/database/redis"
+)
+
+func TestGetExportFormat(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", func(ctx *fiber.Ctx) error {
+		format, err := getExportFormat(ctx)
+		if info, ok := err.(errorInfo); ok {
+			return ctx.Status(info.err.Code).SendString(info.why)
+		}
+
+		return ctx.SendString(format)
+	})
+
+	tests := []struct {
+		query  string
+		accept string
+		status int
+		format string
+	}{
+		{"", "", fiber.StatusOK, formatJSON},
+		{"", "*/*", fiber.StatusOK, formatJSON},
+		{"", "text/csv", fiber.StatusOK, formatCSV},
+		{"", "application/x-ndjson", fiber.StatusOK, formatNDJSON},
+		{"", "application/ndjson", fiber.StatusOK, formatNDJSON},
+		{"", "text/html, text/csv;q=0.9", fiber.StatusOK, formatCSV},
+		{"", "text/html", fiber.StatusNotAcceptable, ""},
+		{"?format=csv", "application/json", fiber.StatusOK, formatCSV},
+		{"?format=NDJSON", "", fiber.StatusOK, formatNDJSON},
+		{"?format=xml", "", fiber.StatusBadRequest, ""},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/"+test.query, nil)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("got unexpected error: %s", err)
+		}
+
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		if resp.StatusCode != test.status {
+			t.Errorf("%q %q: expected status %d, got %d", test.query, test.accept, test.status, resp.StatusCode)
+		} else if test.status == fiber.StatusOK && string(body) != test.format {
+			t.Errorf("%q %q: expected format %s, got %s", test.query, test.accept, test.format, body)
+		}
+	}
+}
+
+func TestToReadingRows(t *testing.T) {
+	rows := toReadingRows(map[redis.UnionKey]*redis.RawQualityData{
+		{2, 1600000000}: {Time: 1600000000, PM25: 3},
+		{1, 1600000000}: {Time: 1600000000, PM25: 1},
+		{1, 1600000120}: {Time: 1600000120, PM25: 2},
+	})
+
+	expected := []readingRow{
+		{SensorID: 1, Time: time.Unix(1600000120, 0).UTC(), PM25: 2},
+		{SensorID: 1, Time: time.Unix(1600000000, 0).UTC(), PM25: 1},
+		{SensorID: 2, Time: time.Unix(1600000000, 0).UTC(), PM25: 3},
+	}
+
+	if len(rows) != len(expected) {
+		t.Fatalf("expected %d rows, got %d", len(expected), len(rows))
+	}
+
+	for i := range rows {
+		if rows[i] != expected[i] {
+			t.Errorf("row %d: expected %+v, got %+v", i, expected[i], rows[i])
+		}
+	}
+}
+
+func writeTestRows(t *testing.T, format string) string {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+
+	rows, err := newRowWriter(format, w)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	rows.Write(readingRow{SensorID: 1, Time: time.Unix(1600000000, 0).UTC(), PM25: 12.5, AQI: 52.1})
+	rows.Write(readingRow{SensorID: 2, Time: time.Unix(1600000120, 0).UTC(), PM25: 3})
+
+	if err := rows.Flush(); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	return buf.String()
+}
+
+func TestCSVRowWriter(t *testing.T) {
+	expected := "sensor_id,time,pm25,aqi\n" +
+		"1,2020-09-13T12:26:40Z,12.5,52.1\n" +
+		"2,2020-09-13T12:28:40Z,3,0\n"
+
+	if output := writeTestRows(t, formatCSV); output != expected {
+		t.Errorf("expected %q, got %q", expected, output)
+	}
+}
+
+func TestNDJSONRowWriter(t *testing.T) {
+	expected := `{"sensor_id":1,"time":"2020-09-13T12:26:40Z","pm25":12.5,"aqi":52.1}` + "\n" +
+		`{"sensor_id":2,"time":"2020-09-13T12:28:40Z","pm25":3,"aqi":0}` + "\n"
+
+	if output := writeTestRows(t, formatNDJSON); output != expected {
+		t.Errorf("expected %q, got %q", expected, output)
+	}
+}
+
+func TestWriteReadingsWithoutSensors(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+
+	if err := writeReadings(nil, w, nil, formatCSV, -1, nil); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if buf.String() != "sensor_id,time,pm25,aqi\n" {
+		t.Errorf("expected only the header row, got %q", buf.String())
+	}
+}
+
+func TestReadingsSpecListsExportFormats(t *testing.T) {
+	r := &Router{}
+	document := buildOpenAPIDocument(apiV1Prefix, apiV1Version, r.apiV1Routes())
+
+	for _, path := range []string{"/locations/{latitude}/{longitude}/readings", "/sensors/{id}/readings"} {
+		operation := document.Paths[path].Get
+		content := operation.Responses["200"].Content
+
+		for _, mediaType := range []string{fiber.MIMEApplicationJSON, mimeCSV, mimeNDJSON} {
+			if _, ok := content[mediaType]; !ok {
+				t.Errorf("%s: expected %s response", path, mediaType)
+			}
+		}
+
+		last := operation.Parameters[len(operation.Parameters)-1]
+		if last.Name != "format" {
+			t.Errorf("%s: expected format parameter, got %s", path, last.Name)
+		}
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		format string
+		ok     bool
+	}{
+		{"", formatJSON, true},
+		{"text/*", formatCSV, true},
+		{"application/*", formatJSON, true},
+		{"text/csv;q=0.5, application/x-ndjson", formatNDJSON, true},
+		{"application/json;q=0.1, text/csv;q=0.8, */*;q=0.2", formatCSV, true},
+		{"text/csv;q=0, application/json", formatJSON, true},
+		{"text/csv;q=0", "", false},
+		{"image/png", "", false},
+	}
+
+	for _, test := range tests {
+		format, ok := negotiateFormat(test.accept)
+		if format != test.format || ok != test.ok {
+			t.Errorf("%q: expected %q %t, got %q %t", test.accept, test.format, test.ok, format, ok)
+		}
+	}
+}
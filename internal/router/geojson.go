@@ -0,0 +1,216 @@
+package router
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	utils "github.com/mrflynn/air-alert/internal"
+	"github.com/mrflynn/air-alert/internal/database/redis"
+	"github.com/mrflynn/air-alert/internal/geo"
+	"github.com/mrflynn/air-alert/internal/logging"
+	"github.com/mrflynn/go-aqi"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const mimeGeoJSON = "application/geo+json"
+
+// The types below are the subset of GeoJSON (RFC 7946) that is needed to describe sensors.
+
+type featureCollection struct {
+	Type     string    `json:"type" description:"Always FeatureCollection."`
+	Features []feature `json:"features"`
+	// Truncated is a foreign member that is set when some sensors were left out.
+	Truncated bool `json:"truncated,omitempty" description:"Set when there were too many sensors to return them all."`
+}
+
+type feature struct {
+	Type       string           `json:"type" description:"Always Feature."`
+	ID         int              `json:"id,omitempty" description:"Sensor ID. Clusters do not have an ID."`
+	Geometry   pointGeometry    `json:"geometry"`
+	Properties sensorProperties `json:"properties"`
+}
+
+type pointGeometry struct {
+	Type        string     `json:"type" description:"Always Point."`
+	Coordinates [2]float64 `json:"coordinates" description:"Longitude and latitude of the sensor."`
+}
+
+type sensorProperties struct {
+	SensorID   int       `json:"sensor_id,omitempty"`
+	AQI        float64   `json:"aqi" description:"Most recent PM2.5 AQI."`
+	PM25       float64   `json:"pm25" description:"Most recent PM2.5 concentration in µg/m³."`
+	Category   string    `json:"category" description:"EPA category of the most recent AQI."`
+	LastSeen   time.Time `json:"last_seen"`
+	Confidence int       `json:"confidence" description:"Percentage of readings from the last hour in the same category as the most recent reading. For clusters, the percentage of sensors in the same category as the cluster."`
+	Cluster    bool      `json:"cluster,omitempty"`
+	PointCount int       `json:"point_count,omitempty" description:"Number of sensors in the cluster."`
+}
+
+func newFeatureCollection(locations []redis.SensorLocation, history map[int][]*redis.RawQualityData) featureCollection {
+	collection := featureCollection{
+		Type:     "FeatureCollection",
+		Features: make([]feature, 0, len(locations)),
+	}
+
+	for _, location := range locations {
+		properties, ok := newSensorProperties(location.ID, history[location.ID])
+		if !ok {
+			continue
+		}
+
+		collection.Features = append(collection.Features, feature{
+			Type: "Feature",
+			ID:   location.ID,
+			Geometry: pointGeometry{
+				Type:        "Point",
+				Coordinates: [2]float64{location.Longitude, location.Latitude},
+			},
+			Properties: properties,
+		})
+	}
+
+	return collection
+}
+
+// newSensorProperties summarizes the reading history of a sensor. It returns false if the sensor
+// has no readings.
+func newSensorProperties(id int, history []*redis.RawQualityData) (sensorProperties, bool) {
+	if len(history) < 1 {
+		return sensorProperties{}, false
+	}
+
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].Time > history[j].Time
+	})
+
+	latest := history[0]
+
+	result, err := aqi.Calculate(aqi.PM25{Concentration: latest.PM25})
+	if err != nil {
+		log.WithField(logging.FieldSensorID, id).Debugf("could not calculate aqi: %s", err)
+		return sensorProperties{}, false
+	}
+
+	index := utils.AQIIndex(result.AQI)
+
+	matches := 0
+	for _, reading := range history {
+		if r, err := aqi.Calculate(aqi.PM25{Concentration: reading.PM25}); err == nil && utils.AQIIndex(r.AQI) == index {
+			matches++
+		}
+	}
+
+	roundedAQI, _ := decimal.NewFromFloat(result.AQI).Round(1).Float64()
+
+	return sensorProperties{
+		SensorID:   id,
+		AQI:        roundedAQI,
+		PM25:       latest.PM25,
+		Category:   index.Name,
+		LastSeen:   time.Unix(int64(latest.Time), 0).UTC(),
+		Confidence: matches * 100 / len(history),
+	}, true
+}
+
+func getSensorArea(ctx *fiber.Ctx) (geo.Area, error) {
+	if bbox := ctx.Query("bbox"); bbox != "" {
+		bounds, err := geo.ParseBounds(bbox)
+		if err != nil {
+			return nil, errorInfo{
+				err: fiber.ErrBadRequest,
+				why: err.Error(),
+			}
+		}
+
+		return bounds, nil
+	}
+
+	return getSearchArea(ctx)
+}
+
+// confidenceHistory returns how many readings besides the most recent one are needed to compute the
+// confidence of a sensor. Sensors get at most one new reading per AQI refresh, and only readings
+// from the last hour are kept.
+func confidenceHistory(datastore *redis.Controller) int64 {
+	interval := datastore.AQIRefreshInterval()
+	if interval <= 0 {
+		return -1
+	}
+
+	return int64(math.Ceil(float64(time.Hour) / float64(interval)))
+}
+
+// getSensorHistory returns up to count + 1 of the most recent readings of each sensor, or every
+// reading if count is negative.
+func getSensorHistory(ctx context.Context, datastore *redis.Controller, locations []redis.SensorLocation, count int64) (map[int][]*redis.RawQualityData, error) {
+	history := make(map[int][]*redis.RawQualityData, len(locations))
+	if len(locations) < 1 {
+		return history, nil
+	}
+
+	ids := make([]int, 0, len(locations))
+	for _, location := range locations {
+		ids = append(ids, location.ID)
+	}
+
+	data, err := datastore.GetTimeSeriesData(ctx, count, ids...)
+	if err != nil {
+		logging.FromContext(ctx).Errorf("GetTimeSeriesData error: %s", err)
+
+		return nil, errorInfo{
+			err: fiber.ErrInternalServerError,
+			why: "could not get sensor data from database",
+		}
+	}
+
+	for key, item := range data {
+		history[key.ID()] = append(history[key.ID()], item)
+	}
+
+	return history, nil
+}
+
+func sendGeoJSON(ctx *fiber.Ctx, collection featureCollection) error {
+	err := sendJSON(ctx, collection)
+	if err != nil {
+		return err
+	}
+
+	ctx.Set(fiber.HeaderContentType, mimeGeoJSON)
+
+	return nil
+}
+
+// getSensorFeatures returns the sensors in the requested area. At most web.api.max_sensors are
+// returned, preferring those closest to the center of the area.
+func getSensorFeatures(ctx *fiber.Ctx, datastore *redis.Controller) error {
+	area, err := getSensorArea(ctx)
+	if err != nil {
+		return err
+	}
+
+	locations, truncated, err := datastore.GetSensorsInArea(requestContext(ctx), area, viper.GetInt("web.api.max_sensors"))
+	if err != nil {
+		requestLogger(ctx).Errorf("GetSensorsInArea error: %s", err)
+
+		return errorInfo{
+			err: fiber.ErrInternalServerError,
+			why: "could not get sensor locations from database",
+		}
+	}
+
+	history, err := getSensorHistory(requestContext(ctx), datastore, locations, confidenceHistory(datastore))
+	if err != nil {
+		return err
+	}
+
+	collection := newFeatureCollection(locations, history)
+	collection.Truncated = truncated
+
+	return sendGeoJSON(ctx, collection)
+}
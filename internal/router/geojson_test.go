@@ -0,0 +1,142 @@
+// +build unit
+
+package router
+
+import (
+	stdjson "encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mrflynn/air-alert/internal/database/redis"
+	"github.com/spf13/viper"
+)
+
+func TestNewFeatureCollection(t *testing.T) {
+	locations := []redis.SensorLocation{
+		{ID: 1, Longitude: -122.4, Latitude: 37.7},
+		{ID: 2, Longitude: -122.3, Latitude: 37.8},
+	}
+
+	history := map[int][]*redis.RawQualityData{
+		1: {
+			{Time: 1600000000, PM25: 40},
+			{Time: 1600000600, PM25: 5},
+			{Time: 1600000300, PM25: 6},
+			{Time: 1600000120, PM25: 7},
+		},
+	}
+
+	collection := newFeatureCollection(locations, history)
+	if collection.Type != "FeatureCollection" {
+		t.Errorf("got unexpected type %s", collection.Type)
+	}
+
+	// Sensors without any readings are left out.
+	if len(collection.Features) != 1 {
+		t.Fatalf("expected 1 feature, got %d", len(collection.Features))
+	}
+
+	f := collection.Features[0]
+	if f.ID != 1 || f.Geometry.Coordinates != [2]float64{-122.4, 37.7} {
+		t.Errorf("got unexpected feature %+v", f)
+	}
+
+	expected := sensorProperties{
+		SensorID:   1,
+		AQI:        20.8,
+		PM25:       5,
+		Category:   "Good",
+		LastSeen:   time.Unix(1600000600, 0).UTC(),
+		Confidence: 75,
+	}
+
+	if f.Properties != expected {
+		t.Errorf("expected %+v, got %+v", expected, f.Properties)
+	}
+}
+
+func TestSensorFeaturesConformToSpec(t *testing.T) {
+	r := &Router{}
+	document := buildOpenAPIDocument(apiV1Prefix, apiV1Version, r.apiV1Routes())
+
+	collection := newFeatureCollection(
+		[]redis.SensorLocation{{ID: 1, Longitude: -122.4, Latitude: 37.7}},
+		map[int][]*redis.RawQualityData{1: {{Time: 1600000000, PM25: 12}}},
+	)
+
+	body, err := json.Marshal(collection)
+	if err != nil {
+		t.Fatalf("could not encode feature collection: %s", err)
+	}
+
+	schema := document.Paths["/sensors"].Get.Responses["200"].Content[mimeGeoJSON].Schema
+	validateJSON(t, document, schema, body)
+}
+
+func TestSensorFeaturesInvalidArea(t *testing.T) {
+	r := createAPIV1TestApp()
+
+	for _, path := range []string{
+		"/api/v1/sensors",
+		"/api/v1/sensors?bbox=37.9,-122.5,37.0,-121.8",
+		"/api/v1/sensors?latitude=100&longitude=0",
+	} {
+		resp, err := r.app.Test(httptest.NewRequest("GET", path, nil))
+		if err != nil {
+			t.Fatalf("got unexpected error: %s", err)
+		}
+
+		if resp.StatusCode != fiber.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", path, fiber.StatusBadRequest, resp.StatusCode)
+		}
+	}
+}
+
+func TestSensorFeaturesLimit(t *testing.T) {
+	r := &Router{app: fiber.New(), datastore: createAPIV1TestStore(t)}
+	r.addAPIV1Routes()
+
+	defer viper.Set("web.api.max_sensors", nil)
+
+	tests := []struct {
+		query      string
+		maxSensors int
+		features   int
+		truncated  bool
+	}{
+		{"bbox=37.6,-122.5,37.8,-122.3", 1, 1, true},
+		{"bbox=37.6,-122.5,37.8,-122.3", 2, 2, false},
+		{"bbox=37.6,-122.5,37.8,-122.3", 0, 2, false},
+		{"latitude=37.7&longitude=-122.4&radius=2000", 1, 1, true},
+	}
+
+	for _, test := range tests {
+		viper.Set("web.api.max_sensors", test.maxSensors)
+
+		resp, err := r.app.Test(httptest.NewRequest("GET", "/api/v1/sensors?"+test.query, nil))
+		if err != nil {
+			t.Fatalf("got unexpected error: %s", err)
+		}
+
+		var collection featureCollection
+		if err := stdjson.NewDecoder(resp.Body).Decode(&collection); err != nil {
+			t.Fatalf("could not decode response: %s", err)
+		}
+
+		name := test.query + " max " + strconv.Itoa(test.maxSensors)
+		if len(collection.Features) != test.features || collection.Truncated != test.truncated {
+			t.Errorf(
+				"%s: expected %d features and truncated %t, got %d features and truncated %t",
+				name, test.features, test.truncated, len(collection.Features), collection.Truncated,
+			)
+		}
+
+		// Sensor 1 is closest to the center of both areas.
+		if len(collection.Features) > 0 && collection.Features[0].ID != 1 {
+			t.Errorf("%s: expected sensor 1 first, got %d", name, collection.Features[0].ID)
+		}
+	}
+}
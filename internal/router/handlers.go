@@ -1,17 +1,35 @@
 package router
 
 import (
+	"context"
+	"database/sql"
+
 	"github.com/gofiber/fiber/v2"
 	jsoniter "github.com/json-iterator/go"
-	utils "github.com/mrflynn/air-alert/internal"
 	"github.com/mrflynn/air-alert/internal/database/redis"
-	"github.com/mrflynn/air-alert/internal/database/sql"
+	pg "github.com/mrflynn/air-alert/internal/database/sql"
+	"github.com/mrflynn/air-alert/internal/logging"
+	"github.com/mrflynn/air-alert/internal/notifications"
 	"github.com/shopspring/decimal"
-	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 )
 
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
+func sendJSON(ctx *fiber.Ctx, v interface{}) error {
+	err := json.NewEncoder(ctx.Type("json", "utf-8").Response().BodyWriter()).Encode(v)
+	if err != nil {
+		requestLogger(ctx).Errorf("error in marshalling API response data: %s", err)
+
+		return errorInfo{
+			err: fiber.ErrInternalServerError,
+			why: "error marshalling json object",
+		}
+	}
+
+	return nil
+}
+
 func getLocationParameters(ctx *fiber.Ctx) (float64, float64, float64, error) {
 	var (
 		value             decimal.Decimal
@@ -62,9 +80,16 @@ func getAQIReadings(ctx *fiber.Ctx, datastore *redis.Controller) error {
 		return err
 	}
 
-	results, err := datastore.GetAQIFromSensorsInRange(ctx.Context(), long, lat, radius)
+	format, err := getExportFormat(ctx)
 	if err != nil {
-		log.Errorf("database error: %s", err)
+		return err
+	} else if format != formatJSON {
+		return exportReadingsInRange(ctx, datastore, format, long, lat, radius)
+	}
+
+	results, err := datastore.GetAQIFromSensorsInRange(requestContext(ctx), long, lat, radius)
+	if err != nil {
+		requestLogger(ctx).Errorf("database error: %s", err)
 
 		return errorInfo{
 			err: fiber.ErrInternalServerError,
@@ -72,18 +97,25 @@ func getAQIReadings(ctx *fiber.Ctx, datastore *redis.Controller) error {
 		}
 	}
 
-	err = json.NewEncoder(ctx.Type("json", "utf-8").Response().BodyWriter()).Encode(results)
+	return sendJSON(ctx, results)
+}
 
+// computeAverageAQI returns the average of the most recent AQI readings from all sensors in the
+// given radius around a point, along with the number of sensors that contributed to the average.
+// Averages for radii that cover the geohash cell of the point are shared by all points in the cell
+// and cached until new AQI data is stored.
+func computeAverageAQI(ctx context.Context, datastore *redis.Controller, long, lat, radius float64) (float64, int, error) {
+	cell, err := datastore.GetAQIAround(ctx, long, lat, radius, viper.GetInt("aqi_cache.geohash_precision"))
 	if err != nil {
-		log.Errorf("error in marshalling API response data: %s", err)
+		logging.FromContext(ctx).Errorf("GetAQIAround error: %s", err)
 
-		return errorInfo{
+		return 0, 0, errorInfo{
 			err: fiber.ErrInternalServerError,
-			why: "error marshalling json object",
+			why: "could not get sensor data from database",
 		}
 	}
 
-	return nil
+	return cell.AQI, cell.Readings, nil
 }
 
 func getAverageAQI(ctx *fiber.Ctx, datastore *redis.Controller) error {
@@ -92,75 +124,97 @@ func getAverageAQI(ctx *fiber.Ctx, datastore *redis.Controller) error {
 		return err
 	}
 
-	ids, err := datastore.GetSensorsInRange(ctx.Context(), long, lat, radius)
+	aqi, _, err := computeAverageAQI(requestContext(ctx), datastore, long, lat, radius)
 	if err != nil {
-		log.Errorf("GetSensorsInRange error: %s", err)
+		return err
+	}
+
+	return ctx.SendString(decimal.NewFromFloat(aqi).Round(1).String())
+}
+
+func subscribeToNotifications(ctx *fiber.Ctx, database *pg.Controller) error {
+	var req pg.UserRequest
+
+	err := ctx.BodyParser(&req)
+	if err != nil {
+		requestLogger(ctx).Errorf("could not parse subscription request: %s", err)
 
 		return errorInfo{
-			err: fiber.ErrInternalServerError,
-			why: "could not get sensor ids from database",
+			err: fiber.ErrBadRequest,
+			why: "could not parse subscription request",
 		}
 	}
 
-	data, err := datastore.GetTimeSeriesData(ctx.Context(), 1, ids...)
+	id, err := database.CreateUser(requestContext(ctx), req)
 	if err != nil {
-		log.Errorf("GetTimeSeriesData error: %s", err)
+		requestLogger(ctx).Errorf("could not create user: %s", err)
 
 		return errorInfo{
 			err: fiber.ErrInternalServerError,
-			why: "could not get sensor data from database",
+			why: "could not subscribe user",
 		}
 	}
 
-	var (
-		aqi       float64
-		prevCount int
-	)
-
-	for _, d := range data {
-		if !utils.IsNil(d.AQI) {
-			aqi = utils.RecalculateAverage(d.AQI, aqi, prevCount)
-			prevCount++
-		}
-	}
+	requestLogger(ctx).Infof("registered user %d", id)
 
-	return ctx.SendString(decimal.NewFromFloat(aqi).Round(1).String())
+	return ctx.SendStatus(fiber.StatusCreated)
 }
 
-func subscribeToNotifications(ctx *fiber.Ctx, database *sql.Controller) error {
-	var req sql.UserRequest
+func testSubscription(ctx *fiber.Ctx, database *pg.Controller, notifier *notifications.Sender) error {
+	var req pg.UserRequest
 
 	err := ctx.BodyParser(&req)
-	if err != nil {
-		log.Errorf("could not parse subscription request: %s", err)
+	if err != nil || req.Subscription == nil {
+		requestLogger(ctx).Errorf("could not parse test notification request: %s", err)
 
 		return errorInfo{
 			err: fiber.ErrBadRequest,
-			why: "could not parse subscription request",
+			why: "could not parse test notification request",
 		}
 	}
 
-	id, err := database.CreateUser(ctx.Context(), req)
-	if err != nil {
-		log.Errorf("could not create user: %s", err)
+	// The subscription keys act as the credentials for this endpoint, so only existing
+	// subscribers are able to request a test notification.
+	user, err := database.GetUserWithSubscription(requestContext(ctx), req.Subscription)
+	if err == sql.ErrNoRows {
+		return errorInfo{
+			err: fiber.ErrNotFound,
+			why: "subscription not found",
+		}
+	} else if err != nil {
+		requestLogger(ctx).Errorf("could not get user: %s", err)
 
 		return errorInfo{
 			err: fiber.ErrInternalServerError,
-			why: "could not subscribe user",
+			why: "could not get subscription",
 		}
 	}
 
-	log.Infof("registered user %d", id)
+	resp, err := notifier.SendTest(requestContext(ctx), user)
+	if err != nil {
+		requestLogger(ctx).Errorf("could not send test notification to user %d: %s", user.ID, err)
 
-	return ctx.SendStatus(fiber.StatusCreated)
+		return errorInfo{
+			err: fiber.ErrBadGateway,
+			why: "could not reach push service",
+		}
+	}
+
+	requestLogger(ctx).Infof("sent test notification to user %d (status %d)", user.ID, resp.StatusCode)
+
+	if !resp.Delivered() {
+		ctx.Status(fiber.StatusBadGateway)
+	}
+
+	return sendJSON(ctx, resp)
 }
 
-func unsubscribeFromNofications(ctx *fiber.Ctx, database *sql.Controller) error {
-	var req sql.UserRequest
+func unsubscribeFromNofications(ctx *fiber.Ctx, database *pg.Controller) error {
+	var req pg.UserRequest
 
 	err := ctx.BodyParser(&req)
 	if err != nil {
-		log.Errorf("could not parse unsubscribe request: %s", err)
+		requestLogger(ctx).Errorf("could not parse unsubscribe request: %s", err)
 
 		return errorInfo{
 			err: fiber.ErrBadRequest,
@@ -168,9 +222,9 @@ func unsubscribeFromNofications(ctx *fiber.Ctx, database *sql.Controller) error
 		}
 	}
 
-	err = database.DeleteUser(ctx.Context(), req)
+	err = database.DeleteUser(requestContext(ctx), req)
 	if err != nil {
-		log.Errorf("could not delete user: %s", err)
+		requestLogger(ctx).Errorf("could not delete user: %s", err)
 
 		return errorInfo{
 			err: fiber.ErrInternalServerError,
@@ -178,7 +232,7 @@ func unsubscribeFromNofications(ctx *fiber.Ctx, database *sql.Controller) error
 		}
 	}
 
-	log.Info("unsubscribed user")
+	requestLogger(ctx).Info("unsubscribed user")
 
 	return ctx.SendStatus(fiber.StatusOK)
 }
@@ -0,0 +1,127 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// readinessTimeout is how long all readiness checks together may take.
+	readinessTimeout = 5 * time.Second
+
+	statusOK   = "ok"
+	statusFail = "fail"
+)
+
+// checkResult is the outcome of a single readiness check.
+type checkResult struct {
+	Name        string     `json:"name"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	LastUpdated *time.Time `json:"last_updated,omitempty"`
+	AgeSeconds  *int64     `json:"age_seconds,omitempty"`
+}
+
+// readiness is the response of the readiness endpoint.
+type readiness struct {
+	Status string        `json:"status"`
+	Checks []checkResult `json:"checks"`
+}
+
+// readinessCheck checks a single dependency of the server.
+type readinessCheck func(context.Context) checkResult
+
+// pingCheck creates a check that fails if ping returns an error.
+func pingCheck(name string, ping func(context.Context) error) readinessCheck {
+	return func(ctx context.Context) checkResult {
+		if err := ping(ctx); err != nil {
+			return checkResult{Name: name, Status: statusFail, Error: err.Error()}
+		}
+
+		return checkResult{Name: name, Status: statusOK}
+	}
+}
+
+// freshnessCheck creates a check that fails if data was never updated or was last updated more
+// than maxAge ago.
+func freshnessCheck(name string, lastUpdate func(context.Context) (time.Time, error), maxAge time.Duration) readinessCheck {
+	return func(ctx context.Context) checkResult {
+		updated, err := lastUpdate(ctx)
+		if err != nil {
+			return checkResult{Name: name, Status: statusFail, Error: err.Error()}
+		} else if updated.IsZero() {
+			return checkResult{Name: name, Status: statusFail, Error: "never updated"}
+		}
+
+		age := time.Since(updated)
+		seconds := int64(age.Seconds())
+
+		result := checkResult{
+			Name:        name,
+			Status:      statusOK,
+			LastUpdated: &updated,
+			AgeSeconds:  &seconds,
+		}
+
+		if age > maxAge {
+			result.Status = statusFail
+			result.Error = fmt.Sprintf("last updated more than %s ago", maxAge)
+		}
+
+		return result
+	}
+}
+
+// runChecks runs all checks at once and collects their results in order.
+func runChecks(ctx context.Context, checks []readinessCheck) readiness {
+	results := readiness{
+		Status: statusOK,
+		Checks: make([]checkResult, len(checks)),
+	}
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+
+		go func(i int, check readinessCheck) {
+			defer wg.Done()
+			results.Checks[i] = check(ctx)
+		}(i, check)
+	}
+
+	wg.Wait()
+
+	for _, result := range results.Checks {
+		if result.Status != statusOK {
+			results.Status = statusFail
+		}
+	}
+
+	return results
+}
+
+// checkLiveness reports that the process is running. It does not check any dependencies, so that
+// the server isn't restarted because of an outage elsewhere.
+func checkLiveness(ctx *fiber.Ctx) error {
+	return sendJSON(ctx, struct {
+		Status string `json:"status"`
+	}{statusOK})
+}
+
+// checkReadiness runs every readiness check and responds with the result of each one. The status
+// is 503 if any check failed.
+func checkReadiness(ctx *fiber.Ctx, checks []readinessCheck) error {
+	c, cancel := context.WithTimeout(requestContext(ctx), readinessTimeout)
+	defer cancel()
+
+	results := runChecks(c, checks)
+	if results.Status != statusOK {
+		ctx.Status(fiber.StatusServiceUnavailable)
+	}
+
+	return sendJSON(ctx, results)
+}
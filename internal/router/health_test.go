@@ -0,0 +1,85 @@
+// +build unit
+
+package router
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestFreshnessCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		updated time.Time
+		err     error
+		status  string
+	}{
+		{"fresh", time.Now().Add(-time.Minute), nil, statusOK},
+		{"stale", time.Now().Add(-time.Hour), nil, statusFail},
+		{"never", time.Time{}, nil, statusFail},
+		{"error", time.Time{}, errors.New("connection refused"), statusFail},
+	}
+
+	for _, test := range tests {
+		check := freshnessCheck(test.name, func(context.Context) (time.Time, error) {
+			return test.updated, test.err
+		}, 15*time.Minute)
+
+		result := check(context.Background())
+		if result.Status != test.status {
+			t.Errorf("%s: expected status %s, got %s", test.name, test.status, result.Status)
+		}
+
+		if test.status == statusFail && result.Error == "" {
+			t.Errorf("%s: expected error message", test.name)
+		}
+	}
+}
+
+func TestCheckReadiness(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"ready", nil, fiber.StatusOK},
+		{"not ready", errors.New("connection refused"), fiber.StatusServiceUnavailable},
+	}
+
+	for _, test := range tests {
+		checks := []readinessCheck{
+			pingCheck("redis", func(context.Context) error { return nil }),
+			pingCheck("postgres", func(context.Context) error { return test.err }),
+		}
+
+		app := fiber.New()
+		app.Get("/readyz", func(ctx *fiber.Ctx) error {
+			return checkReadiness(ctx, checks)
+		})
+
+		resp, err := app.Test(httptest.NewRequest("GET", "/readyz", nil))
+		if err != nil {
+			t.Fatalf("%s: got unexpected error: %s", test.name, err)
+		}
+
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.status, resp.StatusCode)
+		}
+
+		var body readiness
+		data, _ := ioutil.ReadAll(resp.Body)
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Fatalf("%s: could not decode response: %s", test.name, err)
+		}
+
+		if len(body.Checks) != 2 || body.Checks[0].Name != "redis" || body.Checks[1].Name != "postgres" {
+			t.Errorf("%s: got unexpected checks %+v", test.name, body.Checks)
+		}
+	}
+}
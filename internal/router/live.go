@@ -0,0 +1,388 @@
+package router
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	utils "github.com/mrflynn/air-alert/internal"
+	"github.com/mrflynn/air-alert/internal/database/redis"
+	"github.com/mrflynn/air-alert/internal/geo"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// liveHeartbeatInterval is how often a comment is sent on idle event streams so that proxies
+	// and clients do not close them.
+	liveHeartbeatInterval = 15 * time.Second
+	// liveWriteTimeout is how long a single event may take to be written before the client is
+	// considered gone.
+	liveWriteTimeout = 10 * time.Second
+	// liveQueryTimeout is how long computing the AQI for an event may take.
+	liveQueryTimeout = 5 * time.Second
+	// maxSubscriberCells is the most update cells that the areas of a subscriber are matched
+	// against. Subscribers with larger areas are signaled by every update.
+	maxSubscriberCells = 256
+)
+
+// liveAQI is the payload of an aqi event.
+type liveAQI struct {
+	AQI      float64   `json:"aqi"`
+	Category string    `json:"category"`
+	Trend    string    `json:"trend"`
+	Sensors  int       `json:"sensors"`
+	Time     time.Time `json:"time"`
+}
+
+// trend describes how the AQI changed since the previous event.
+func trend(previous, current float64) string {
+	switch {
+	case current > previous:
+		return "rising"
+	case current < previous:
+		return "falling"
+	default:
+		return "steady"
+	}
+}
+
+// liveSubscriber is a single stream waiting for new AQI data in any of its areas.
+type liveSubscriber struct {
+	// cells are the update cells that overlap with the areas of the subscriber, unless everywhere
+	// is set because there were too many of them.
+	cells      []string
+	everywhere bool
+	// updates is signaled when new data is stored in the area. It holds at most one signal, so
+	// updates that arrive while the subscriber is busy are merged into one.
+	updates chan struct{}
+}
+
+// signal tells the subscriber about new data without waiting for it.
+func (s *liveSubscriber) signal() {
+	select {
+	case s.updates <- struct{}{}:
+	default:
+	}
+}
+
+// subscriberCells returns the update cells that overlap with any of the areas, or false if there
+// are more than maxSubscriberCells of them.
+func subscriberCells(areas []geo.Bounds) ([]string, bool) {
+	seen := make(map[string]struct{})
+	cells := make([]string, 0, len(areas))
+
+	for _, area := range areas {
+		hashes, ok := geo.GeohashesInBounds(area, redis.AQIUpdatePrecision, maxSubscriberCells)
+		if !ok {
+			return nil, false
+		}
+
+		for _, hash := range hashes {
+			if _, ok := seen[hash]; !ok {
+				seen[hash] = struct{}{}
+				cells = append(cells, hash)
+			}
+		}
+
+		if len(cells) > maxSubscriberCells {
+			return nil, false
+		}
+	}
+
+	return cells, true
+}
+
+// aqiHub fans out announcements of new AQI data from Redis to every stream on this server.
+type aqiHub struct {
+	datastore *redis.Controller
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu          sync.Mutex
+	subscribers map[*liveSubscriber]struct{}
+	// cells indexes subscribers by the update cells that overlap with their areas.
+	cells  map[string]map[*liveSubscriber]struct{}
+	closer io.Closer
+}
+
+func newAQIHub(datastore *redis.Controller) *aqiHub {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &aqiHub{
+		datastore:   datastore,
+		ctx:         ctx,
+		cancel:      cancel,
+		subscribers: make(map[*liveSubscriber]struct{}),
+		cells:       make(map[string]map[*liveSubscriber]struct{}),
+	}
+}
+
+// start subscribes to announcements of new AQI data and forwards them to event streams until the
+// hub is closed.
+func (h *aqiHub) start() error {
+	updates, closer, err := h.datastore.SubscribeAQIUpdates(h.ctx)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	h.closer = closer
+	h.mu.Unlock()
+
+	go func() {
+		for update := range updates {
+			h.notify(update)
+		}
+	}()
+
+	return nil
+}
+
+// notify signals every subscriber with an area that overlaps with any of the cells of the update.
+func (h *aqiHub) notify(update redis.AQIUpdate) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, cell := range update.Cells {
+		for sub := range h.cells[cell] {
+			sub.signal()
+		}
+	}
+
+	for sub := range h.subscribers {
+		if sub.everywhere {
+			sub.signal()
+		}
+	}
+}
+
+// index adds the subscriber to the index of every cell it overlaps with. The caller must hold
+// h.mu.
+func (h *aqiHub) index(sub *liveSubscriber) {
+	for _, cell := range sub.cells {
+		if h.cells[cell] == nil {
+			h.cells[cell] = make(map[*liveSubscriber]struct{})
+		}
+
+		h.cells[cell][sub] = struct{}{}
+	}
+}
+
+// unindex removes the subscriber from the index. The caller must hold h.mu.
+func (h *aqiHub) unindex(sub *liveSubscriber) {
+	for _, cell := range sub.cells {
+		delete(h.cells[cell], sub)
+
+		if len(h.cells[cell]) == 0 {
+			delete(h.cells, cell)
+		}
+	}
+}
+
+func (h *aqiHub) subscribe(areas ...geo.Bounds) *liveSubscriber {
+	cells, ok := subscriberCells(areas)
+
+	sub := &liveSubscriber{
+		cells:      cells,
+		everywhere: !ok,
+		updates:    make(chan struct{}, 1),
+	}
+
+	h.mu.Lock()
+	h.subscribers[sub] = struct{}{}
+	h.index(sub)
+	h.mu.Unlock()
+
+	return sub
+}
+
+// setAreas replaces the areas that a subscriber is waiting for new data in.
+func (h *aqiHub) setAreas(sub *liveSubscriber, areas []geo.Bounds) {
+	cells, ok := subscriberCells(areas)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.unindex(sub)
+	sub.cells = cells
+	sub.everywhere = !ok
+
+	if _, ok := h.subscribers[sub]; ok {
+		h.index(sub)
+	}
+}
+
+func (h *aqiHub) unsubscribe(sub *liveSubscriber) {
+	h.mu.Lock()
+	h.unindex(sub)
+	delete(h.subscribers, sub)
+	h.mu.Unlock()
+}
+
+// count returns the number of subscribers of the hub.
+func (h *aqiHub) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.subscribers)
+}
+
+// done is closed once the hub is closed.
+func (h *aqiHub) done() <-chan struct{} {
+	return h.ctx.Done()
+}
+
+// close stops the Redis subscription and ends every stream.
+func (h *aqiHub) close() error {
+	h.cancel()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closer == nil {
+		return nil
+	}
+
+	return h.closer.Close()
+}
+
+// writeEvent writes a single server-sent event.
+func writeEvent(w *bufio.Writer, event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	w.WriteString("event: " + event + "\n")
+	w.WriteString("data: ")
+	w.Write(data)
+	w.WriteString("\n\n")
+
+	return nil
+}
+
+// aqiStream writes AQI events for a single location.
+type aqiStream struct {
+	hub  *aqiHub
+	area geo.Circle
+
+	previous *float64
+}
+
+// next computes the current AQI of the stream's area.
+func (s *aqiStream) next() (liveAQI, error) {
+	ctx, cancel := context.WithTimeout(s.hub.ctx, liveQueryTimeout)
+	defer cancel()
+
+	value, count, err := computeAverageAQI(ctx, s.hub.datastore, s.area.Longitude, s.area.Latitude, s.area.Radius)
+	if err != nil {
+		return liveAQI{}, err
+	}
+
+	value, _ = decimal.NewFromFloat(value).Round(1).Float64()
+
+	event := liveAQI{
+		AQI:      value,
+		Category: utils.AQIIndex(value).Name,
+		Trend:    "steady",
+		Sensors:  count,
+		Time:     time.Now().UTC(),
+	}
+
+	if s.previous != nil {
+		event.Trend = trend(*s.previous, value)
+	}
+	s.previous = &value
+
+	return event, nil
+}
+
+func streamAQI(ctx *fiber.Ctx, hub *aqiHub) error {
+	long, lat, radius, err := getLocationParameters(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := geo.ValidatePoint(long, lat); err != nil {
+		return errorInfo{
+			err: fiber.ErrBadRequest,
+			why: err.Error(),
+		}
+	}
+
+	area := geo.Circle{
+		Point:  geo.Point{Latitude: lat, Longitude: long},
+		Radius: radius,
+	}
+
+	ctx.Set(fiber.HeaderContentType, "text/event-stream")
+	ctx.Set(fiber.HeaderCacheControl, "no-cache")
+	ctx.Set(fiber.HeaderConnection, "keep-alive")
+	// Stop reverse proxies such as nginx from buffering the stream.
+	ctx.Set("X-Accel-Buffering", "no")
+
+	conn := ctx.Context().Conn()
+	logger := requestLogger(ctx)
+
+	ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		sub := hub.subscribe(area.Bounds())
+		defer hub.unsubscribe(sub)
+
+		stream := &aqiStream{hub: hub, area: area}
+
+		// The server only sets a deadline once per response, so it is extended before every write
+		// to keep long running streams open.
+		flush := func() error {
+			if conn != nil {
+				conn.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+			}
+
+			return w.Flush()
+		}
+
+		send := func() error {
+			event, err := stream.next()
+			if err != nil {
+				// The client keeps the last value and gets a fresh one with the next update.
+				logger.Errorf("could not compute live aqi: %s", err)
+				return nil
+			}
+
+			if err := writeEvent(w, "aqi", event); err != nil {
+				return err
+			}
+
+			return flush()
+		}
+
+		if err := send(); err != nil {
+			return
+		}
+
+		heartbeat := time.NewTicker(liveHeartbeatInterval)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-hub.done():
+				return
+			case <-sub.updates:
+				err = send()
+			case <-heartbeat.C:
+				w.WriteString(": keep-alive\n\n")
+				err = flush()
+			}
+
+			if err != nil {
+				logger.Debugf("closing aqi event stream: %s", err)
+				return
+			}
+		}
+	})
+
+	return nil
+}
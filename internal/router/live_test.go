@@ -0,0 +1,178 @@
+// +build unit
+
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mrflynn/air-alert/internal/database/redis"
+	"github.com/mrflynn/air-alert/internal/geo"
+)
+
+func TestTrend(t *testing.T) {
+	tests := []struct {
+		previous, current float64
+		trend             string
+	}{
+		{10, 20, "rising"},
+		{20, 10, "falling"},
+		{15, 15, "steady"},
+	}
+
+	for _, test := range tests {
+		if got := trend(test.previous, test.current); got != test.trend {
+			t.Errorf("%f -> %f: expected %s, got %s", test.previous, test.current, test.trend, got)
+		}
+	}
+}
+
+func TestAQIHubNotify(t *testing.T) {
+	hub := newAQIHub(nil)
+	defer hub.close()
+
+	sub := hub.subscribe(geo.Circle{
+		Point:  geo.Point{Latitude: 37.7, Longitude: -122.4},
+		Radius: 2000,
+	}.Bounds())
+
+	// New York.
+	hub.notify(redis.AQIUpdate{Cells: []string{"dr5r"}})
+
+	select {
+	case <-sub.updates:
+		t.Errorf("expected no signal for update outside of area")
+	default:
+	}
+
+	// Several updates before the subscriber catches up are merged into one signal.
+	for i := 0; i < 2; i++ {
+		hub.notify(redis.AQIUpdate{Cells: []string{"9q8y", "dr5r"}})
+	}
+
+	if len(sub.updates) != 1 {
+		t.Errorf("expected 1 signal, got %d", len(sub.updates))
+	}
+
+	<-sub.updates
+	hub.unsubscribe(sub)
+
+	hub.notify(redis.AQIUpdate{Cells: []string{"9q8y"}})
+
+	if len(sub.updates) != 0 {
+		t.Errorf("expected no signal after unsubscribing")
+	}
+
+	if len(hub.cells) != 0 {
+		t.Errorf("expected cell index to be empty after unsubscribing, got %v", hub.cells)
+	}
+}
+
+func TestAQIHubSetAreas(t *testing.T) {
+	hub := newAQIHub(nil)
+	defer hub.close()
+
+	sub := hub.subscribe()
+	defer hub.unsubscribe(sub)
+
+	hub.notify(redis.AQIUpdate{Cells: []string{"9q8y"}})
+	if len(sub.updates) != 0 {
+		t.Errorf("expected no signal without areas")
+	}
+
+	hub.setAreas(sub, []geo.Bounds{{MinLatitude: 40.7, MinLongitude: -74.0, MaxLatitude: 40.7, MaxLongitude: -74.0}})
+
+	hub.notify(redis.AQIUpdate{Cells: []string{"9q8y"}})
+	if len(sub.updates) != 0 {
+		t.Errorf("expected no signal for update outside of area")
+	}
+
+	hub.notify(redis.AQIUpdate{Cells: []string{"dr5r"}})
+	if len(sub.updates) != 1 {
+		t.Errorf("expected signal for update inside of new area")
+	}
+}
+
+func TestAQIHubLargeArea(t *testing.T) {
+	hub := newAQIHub(nil)
+	defer hub.close()
+
+	// Areas with too many cells are signaled by every update.
+	sub := hub.subscribe(geo.Bounds{MinLatitude: -90, MinLongitude: -180, MaxLatitude: 90, MaxLongitude: 180})
+	defer hub.unsubscribe(sub)
+
+	if !sub.everywhere || len(hub.cells) != 0 {
+		t.Errorf("expected subscriber not to be indexed by cell, got %v", hub.cells)
+	}
+
+	hub.notify(redis.AQIUpdate{Cells: []string{"dr5r"}})
+	if len(sub.updates) != 1 {
+		t.Errorf("expected signal for any update")
+	}
+}
+
+func TestAQIHubClose(t *testing.T) {
+	hub := newAQIHub(nil)
+
+	if err := hub.close(); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	select {
+	case <-hub.done():
+	default:
+		t.Errorf("expected hub to be done after closing")
+	}
+}
+
+func TestWriteEvent(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+
+	err := writeEvent(w, "aqi", liveAQI{AQI: 42.5, Category: "Good", Trend: "rising", Sensors: 3})
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	w.Flush()
+
+	expected := "event: aqi\n" +
+		`data: {"aqi":42.5,"category":"Good","trend":"rising","sensors":3,"time":"0001-01-01T00:00:00Z"}` +
+		"\n\n"
+
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestStreamAQIInvalidParameters(t *testing.T) {
+	app := fiber.New()
+	hub := newAQIHub(nil)
+	defer hub.close()
+
+	app.Get("/aqi/:latitude/:longitude/events", func(ctx *fiber.Ctx) error {
+		err := streamAQI(ctx, hub)
+		if info, ok := err.(errorInfo); ok {
+			return ctx.Status(info.err.Code).SendString(info.why)
+		}
+
+		return err
+	})
+
+	for _, path := range []string{
+		"/aqi/abc/-122.4/events",
+		"/aqi/100/-122.4/events",
+		"/aqi/37.7/-122.4/events?radius=abc",
+	} {
+		resp, err := app.Test(httptest.NewRequest("GET", path, nil))
+		if err != nil {
+			t.Fatalf("got unexpected error: %s", err)
+		}
+
+		if resp.StatusCode != fiber.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", path, fiber.StatusBadRequest, resp.StatusCode)
+		}
+	}
+}
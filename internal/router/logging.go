@@ -0,0 +1,34 @@
+package router
+
+import (
+	"github.com/gofiber/fiber/v2"
+	fiberutils "github.com/gofiber/fiber/v2/utils"
+	"github.com/mrflynn/air-alert/internal/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxRequestIDLength is the longest request ID accepted from a caller. Longer IDs are replaced so
+// that callers can't flood the logs.
+const maxRequestIDLength = 128
+
+// requestID is a middleware that tags every request with an ID, which is taken from the
+// X-Request-ID header if the caller set one. The ID is sent back in the same header and is included
+// in every message logged while handling the request.
+func requestID(ctx *fiber.Ctx) error {
+	id := ctx.Get(fiber.HeaderXRequestID)
+	if id == "" || len(id) > maxRequestIDLength {
+		id = fiberutils.UUID()
+	}
+
+	ctx.Set(fiber.HeaderXRequestID, id)
+
+	logger := log.WithField(logging.FieldRequestID, id)
+	ctx.Locals(requestContextKey, logging.WithLogger(requestContext(ctx), logger))
+
+	return ctx.Next()
+}
+
+// requestLogger returns the logger for messages about the current request.
+func requestLogger(ctx *fiber.Ctx) *log.Entry {
+	return logging.FromContext(requestContext(ctx))
+}
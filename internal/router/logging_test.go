@@ -0,0 +1,55 @@
+// +build unit
+
+package router
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mrflynn/air-alert/internal/logging"
+)
+
+func TestRequestID(t *testing.T) {
+	app := fiber.New()
+	app.Use(requestID)
+
+	app.Get("/", func(ctx *fiber.Ctx) error {
+		return ctx.SendString(requestLogger(ctx).Data[logging.FieldRequestID].(string))
+	})
+
+	tests := []struct {
+		header   string
+		expected string
+	}{
+		{"", ""},
+		{"abc-123", "abc-123"},
+		{strings.Repeat("a", maxRequestIDLength+1), ""},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if test.header != "" {
+			req.Header.Set(fiber.HeaderXRequestID, test.header)
+		}
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("got unexpected error: %s", err)
+		}
+
+		id := resp.Header.Get(fiber.HeaderXRequestID)
+		if test.expected != "" && id != test.expected {
+			t.Errorf("expected request id %s, got %s", test.expected, id)
+		} else if test.expected == "" && (id == "" || id == test.header) {
+			t.Errorf("expected a generated request id, got %q", id)
+		}
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		if string(body) != id {
+			t.Errorf("expected logger to have request id %s, got %s", id, body)
+		}
+	}
+}
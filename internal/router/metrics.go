@@ -0,0 +1,121 @@
+package router
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mrflynn/air-alert/internal/database/redis"
+	"github.com/mrflynn/air-alert/internal/database/sql"
+	"github.com/mrflynn/air-alert/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+)
+
+// metricsQueryTimeout is how long the database queries of a single scrape may take.
+const metricsQueryTimeout = 5 * time.Second
+
+// requestMetrics is a middleware that records the duration of every request by route. Routes are
+// labelled by their registered path so that path parameters don't create new series.
+func requestMetrics(ctx *fiber.Ctx) error {
+	start := time.Now()
+	err := ctx.Next()
+
+	metrics.HTTPRequestDuration.
+		WithLabelValues(ctx.Method(), ctx.Route().Path, strconv.Itoa(responseStatus(ctx, err))).
+		Observe(time.Since(start).Seconds())
+
+	return err
+}
+
+var (
+	notificationStreamLengthDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metrics.Namespace, "notifications", "stream_length"),
+		"Number of notifications waiting to be delivered, including pending ones.",
+		nil, nil,
+	)
+	notificationStreamPendingDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metrics.Namespace, "notifications", "stream_pending"),
+		"Number of notifications read by a sender but not yet delivered.",
+		nil, nil,
+	)
+	pushSubscribersDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metrics.Namespace, "notifications", "subscribers"),
+		"Number of users subscribed to push notifications.",
+		nil, nil,
+	)
+	liveSubscribersDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metrics.Namespace, "live", "subscribers"),
+		"Number of live AQI event streams and WebSocket connections on this server.",
+		nil, nil,
+	)
+	websocketConnectionsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metrics.Namespace, "websocket", "connections"),
+		"Number of open WebSocket connections on this server.",
+		nil, nil,
+	)
+)
+
+// stateCollector reports gauges that are read from the databases and the router when Prometheus
+// scrapes the server, rather than being updated as things happen.
+type stateCollector struct {
+	datastore *redis.Controller
+	database  *sql.Controller
+	group     string
+	live      *aqiHub
+	sockets   *wsLimits
+}
+
+func (c *stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- notificationStreamLengthDesc
+	ch <- notificationStreamPendingDesc
+	ch <- pushSubscribersDesc
+	ch <- liveSubscribersDesc
+	ch <- websocketConnectionsDesc
+}
+
+func (c *stateCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), metricsQueryTimeout)
+	defer cancel()
+
+	if info, err := c.datastore.GetNotificationStreamInfo(ctx, c.group, 0); err != nil {
+		ch <- prometheus.NewInvalidMetric(notificationStreamLengthDesc, err)
+		ch <- prometheus.NewInvalidMetric(notificationStreamPendingDesc, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(notificationStreamLengthDesc, prometheus.GaugeValue, float64(info.Length))
+		ch <- prometheus.MustNewConstMetric(notificationStreamPendingDesc, prometheus.GaugeValue, float64(info.Pending))
+	}
+
+	if count, err := c.database.CountUsers(ctx); err != nil {
+		ch <- prometheus.NewInvalidMetric(pushSubscribersDesc, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(pushSubscribersDesc, prometheus.GaugeValue, float64(count))
+	}
+
+	ch <- prometheus.MustNewConstMetric(liveSubscribersDesc, prometheus.GaugeValue, float64(c.live.count()))
+	ch <- prometheus.MustNewConstMetric(websocketConnectionsDesc, prometheus.GaugeValue, float64(c.sockets.connections()))
+}
+
+// metricsHandler serves the metrics of the whole process together with the given collectors in
+// the Prometheus text format. A failing collector is logged and left out of the response instead
+// of failing the whole scrape.
+func metricsHandler(collectors ...prometheus.Collector) fiber.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors...)
+
+	handler := fasthttpadaptor.NewFastHTTPHandler(promhttp.HandlerFor(
+		prometheus.Gatherers{prometheus.DefaultGatherer, registry},
+		promhttp.HandlerOpts{
+			ErrorLog:      log.StandardLogger(),
+			ErrorHandling: promhttp.ContinueOnError,
+		},
+	))
+
+	return func(ctx *fiber.Ctx) error {
+		handler(ctx.Context())
+		return nil
+	}
+}
@@ -0,0 +1,140 @@
+// +build unit
+
+package router
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mrflynn/air-alert/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/spf13/viper"
+)
+
+func TestRequestMetrics(t *testing.T) {
+	app := fiber.New(fiber.Config{
+		ErrorHandler: func(ctx *fiber.Ctx, err error) error {
+			return ctx.SendStatus(fiber.StatusInternalServerError)
+		},
+	})
+	app.Use(requestMetrics)
+
+	app.Get("/metrics-test/:id", func(ctx *fiber.Ctx) error {
+		return ctx.SendString(ctx.Params("id"))
+	})
+
+	app.Get("/metrics-test/:id/error", func(ctx *fiber.Ctx) error {
+		return errorInfo{err: fiber.ErrBadRequest, why: "bad request"}
+	})
+
+	for _, path := range []string{"/metrics-test/1", "/metrics-test/2", "/metrics-test/3/error"} {
+		if _, err := app.Test(httptest.NewRequest("GET", path, nil)); err != nil {
+			t.Fatalf("got unexpected error: %s", err)
+		}
+	}
+
+	tests := []struct {
+		route  string
+		status string
+		count  uint64
+	}{
+		{"/metrics-test/:id", "200", 2},
+		{"/metrics-test/:id/error", "400", 1},
+	}
+
+	for _, test := range tests {
+		var m dto.Metric
+		if err := metrics.HTTPRequestDuration.WithLabelValues("GET", test.route, test.status).(prometheus.Histogram).Write(&m); err != nil {
+			t.Fatalf("got unexpected error: %s", err)
+		}
+
+		if count := m.GetHistogram().GetSampleCount(); count != test.count {
+			t.Errorf("%s %s: expected %d requests, got %d", test.route, test.status, test.count, count)
+		}
+	}
+}
+
+type failingCollector struct {
+	desc *prometheus.Desc
+}
+
+func (f failingCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- f.desc
+}
+
+func (f failingCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.NewInvalidMetric(f.desc, errors.New("database unavailable"))
+}
+
+func TestMetricsHandler(t *testing.T) {
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "metrics_handler_test", Help: "Test gauge."})
+	gauge.Set(42)
+
+	failing := failingCollector{
+		desc: prometheus.NewDesc("metrics_handler_failing", "Always fails.", nil, nil),
+	}
+
+	app := fiber.New()
+	app.Get("/metrics", metricsHandler(gauge, failing))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/metrics", nil))
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	for _, expected := range []string{"metrics_handler_test 42", "go_goroutines"} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("expected metrics to contain %q", expected)
+		}
+	}
+}
+
+func hasRoute(app *fiber.App, method, path string) bool {
+	for _, routes := range app.Stack() {
+		for _, route := range routes {
+			if route.Method == method && route.Path == path {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func TestMetricsServedSeparately(t *testing.T) {
+	viper.Set("web.metrics.enable", true)
+	viper.Set("web.metrics.addr", ":9090")
+	viper.Set("web.template_dir", t.TempDir())
+	defer viper.Set("web.metrics.enable", nil)
+	defer viper.Set("web.metrics.addr", nil)
+	defer viper.Set("web.template_dir", nil)
+
+	router, err := NewRouter(createAPIV1TestStore(t), nil, nil, nil)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	router.addRoutes()
+
+	if router.MetricsAddress != ":9090" {
+		t.Errorf("expected metrics address :9090, got %s", router.MetricsAddress)
+	}
+
+	if hasRoute(router.app, fiber.MethodGet, "/metrics") {
+		t.Errorf("expected metrics not to be served by the public router")
+	}
+
+	if router.metrics == nil || !hasRoute(router.metrics, fiber.MethodGet, "/metrics") {
+		t.Errorf("expected metrics to be served by the metrics router")
+	}
+}
@@ -0,0 +1,356 @@
+package router
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The types below are a minimal subset of the OpenAPI 3 specification. They only cover what is
+// needed to describe this API.
+
+type openAPIDocument struct {
+	OpenAPI    string                      `json:"openapi"`
+	Info       openAPIInfo                 `json:"info"`
+	Servers    []openAPIServer             `json:"servers"`
+	Paths      map[string]*openAPIPathItem `json:"paths"`
+	Components openAPIComponents           `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type openAPIServer struct {
+	URL string `json:"url"`
+}
+
+type openAPIPathItem struct {
+	Get    *openAPIOperation `json:"get,omitempty"`
+	Put    *openAPIOperation `json:"put,omitempty"`
+	Post   *openAPIOperation `json:"post,omitempty"`
+	Delete *openAPIOperation `json:"delete,omitempty"`
+	Patch  *openAPIOperation `json:"patch,omitempty"`
+}
+
+// operation returns the field of the path item that holds the operation for method.
+func (p *openAPIPathItem) operation(method string) **openAPIOperation {
+	switch method {
+	case fiber.MethodPut:
+		return &p.Put
+	case fiber.MethodPost:
+		return &p.Post
+	case fiber.MethodDelete:
+		return &p.Delete
+	case fiber.MethodPatch:
+		return &p.Patch
+	default:
+		return &p.Get
+	}
+}
+
+type openAPIOperation struct {
+	Summary     string                      `json:"summary"`
+	OperationID string                      `json:"operationId"`
+	Tags        []string                    `json:"tags,omitempty"`
+	Parameters  []openAPIParameter          `json:"parameters,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Headers     map[string]*openAPIHeader   `json:"headers,omitempty"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIHeader struct {
+	Description string         `json:"description,omitempty"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]*openAPISchema `json:"schemas"`
+}
+
+type openAPISchema struct {
+	Ref         string                    `json:"$ref,omitempty"`
+	Type        string                    `json:"type,omitempty"`
+	Format      string                    `json:"format,omitempty"`
+	Description string                    `json:"description,omitempty"`
+	Properties  map[string]*openAPISchema `json:"properties,omitempty"`
+	Required    []string                  `json:"required,omitempty"`
+	Items       *openAPISchema            `json:"items,omitempty"`
+	Minimum     *float64                  `json:"minimum,omitempty"`
+	Maximum     *float64                  `json:"maximum,omitempty"`
+	Default     interface{}               `json:"default,omitempty"`
+	Enum        []string                  `json:"enum,omitempty"`
+	Nullable    bool                      `json:"nullable,omitempty"`
+}
+
+func float64Ptr(f float64) *float64 {
+	return &f
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	pathParamRegex = regexp.MustCompile(`:([A-Za-z0-9_]+)`)
+)
+
+// schemaGenerator creates OpenAPI schemas from Go types. Named struct types are added to the
+// components section of the document and referenced by name.
+type schemaGenerator struct {
+	schemas map[string]*openAPISchema
+}
+
+func newSchemaGenerator() *schemaGenerator {
+	return &schemaGenerator{
+		schemas: make(map[string]*openAPISchema),
+	}
+}
+
+func (g *schemaGenerator) schemaFor(t reflect.Type) *openAPISchema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := g.schemaFor(t.Elem())
+		if schema.Ref != "" {
+			return schema
+		}
+
+		schema.Nullable = true
+		return schema
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &openAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &openAPISchema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &openAPISchema{Type: "array", Items: g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &openAPISchema{Type: "object"}
+	case reflect.Struct:
+		if t == timeType {
+			return &openAPISchema{Type: "string", Format: "date-time"}
+		}
+
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+
+		if _, ok := g.schemas[t.Name()]; !ok {
+			// Reserve the name first in case the type refers to itself.
+			g.schemas[t.Name()] = &openAPISchema{}
+			*g.schemas[t.Name()] = *g.structSchema(t)
+		}
+
+		return &openAPISchema{Ref: "#/components/schemas/" + t.Name()}
+	default:
+		return &openAPISchema{}
+	}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) *openAPISchema {
+	schema := &openAPISchema{
+		Type:       "object",
+		Properties: make(map[string]*openAPISchema, t.NumField()),
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+
+		name, omitEmpty, ok := jsonFieldName(field)
+		if !ok {
+			continue
+		}
+
+		// Flatten embedded structs like encoding/json does.
+		if field.Anonymous && field.Tag.Get("json") == "" && field.Type.Kind() == reflect.Struct {
+			embedded := g.structSchema(field.Type)
+			for k, v := range embedded.Properties {
+				schema.Properties[k] = v
+			}
+
+			schema.Required = append(schema.Required, embedded.Required...)
+			continue
+		}
+
+		schema.Properties[name] = g.schemaFor(field.Type)
+		if description := field.Tag.Get("description"); description != "" {
+			schema.Properties[name].Description = description
+		}
+
+		if !omitEmpty {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+
+	return schema
+}
+
+func jsonFieldName(field reflect.StructField) (string, bool, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, false
+	}
+
+	parts := strings.Split(tag, ",")
+
+	name := parts[0]
+	if name == "" {
+		name = field.Name
+	}
+
+	omitEmpty := false
+	for _, option := range parts[1:] {
+		if option == "omitempty" {
+			omitEmpty = true
+		}
+	}
+
+	return name, omitEmpty, true
+}
+
+// toOpenAPIPath converts a Fiber route path (e.g. /sensors/:id) to an OpenAPI path
+// (e.g. /sensors/{id}).
+func toOpenAPIPath(path string) string {
+	return pathParamRegex.ReplaceAllString(path, "{$1}")
+}
+
+// buildOpenAPIDocument creates an OpenAPI document describing the given routes, which are mounted
+// under prefix.
+func buildOpenAPIDocument(prefix, version string, routes []apiRoute) openAPIDocument {
+	generator := newSchemaGenerator()
+	problemSchema := generator.schemaFor(reflect.TypeOf(problem{}))
+
+	problemResponse := func(description string) *openAPIResponse {
+		return &openAPIResponse{
+			Description: description,
+			Content: map[string]openAPIMediaType{
+				mimeProblemJSON: {Schema: problemSchema},
+			},
+		}
+	}
+
+	rateLimitHeaders := map[string]*openAPIHeader{
+		"RateLimit-Limit": {
+			Description: "Number of requests allowed per minute.",
+			Schema:      &openAPISchema{Type: "integer"},
+		},
+		"RateLimit-Remaining": {
+			Description: "Number of requests remaining in the current window.",
+			Schema:      &openAPISchema{Type: "integer"},
+		},
+		"RateLimit-Reset": {
+			Description: "Number of seconds until the quota is fully restored.",
+			Schema:      &openAPISchema{Type: "integer"},
+		},
+	}
+
+	document := openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info: openAPIInfo{
+			Title:       "Air Alert API",
+			Description: "Air quality data derived from Purple Air sensors.",
+			Version:     version,
+		},
+		Servers: []openAPIServer{{URL: prefix}},
+		Paths:   make(map[string]*openAPIPathItem, len(routes)),
+	}
+
+	for _, route := range routes {
+		path := toOpenAPIPath(route.Path)
+		if _, ok := document.Paths[path]; !ok {
+			document.Paths[path] = &openAPIPathItem{}
+		}
+
+		responseType := reflect.TypeOf(envelope{})
+		if route.Bare {
+			responseType = reflect.TypeOf(route.Response)
+		} else if route.Response != nil {
+			responseType = reflect.StructOf([]reflect.StructField{
+				{
+					Name: "Data",
+					Type: reflect.TypeOf(route.Response),
+					Tag:  `json:"data"`,
+				},
+				{
+					Name: "Meta",
+					Type: reflect.TypeOf(&meta{}),
+					Tag:  `json:"meta,omitempty"`,
+				},
+			})
+		}
+
+		mediaType := route.MediaType
+		if mediaType == "" {
+			mediaType = fiber.MIMEApplicationJSON
+		}
+
+		success := &openAPIResponse{
+			Description: "Successful response.",
+			Headers:     rateLimitHeaders,
+			Content: map[string]openAPIMediaType{
+				mediaType: {Schema: generator.schemaFor(responseType)},
+			},
+		}
+
+		parameters := route.Parameters
+		if route.Exports {
+			parameters = append(append([]openAPIParameter{}, parameters...), formatParameter)
+
+			success.Content[mimeCSV] = openAPIMediaType{
+				Schema: &openAPISchema{
+					Type:        "string",
+					Description: "CSV with a sensor_id,time,pm25,aqi header row.",
+				},
+			}
+			// NDJSON cannot be described directly, so the schema describes a single line.
+			success.Content[mimeNDJSON] = openAPIMediaType{
+				Schema: generator.schemaFor(reflect.TypeOf(readingRow{})),
+			}
+		}
+
+		*document.Paths[path].operation(route.Method) = &openAPIOperation{
+			Summary:     route.Summary,
+			OperationID: route.OperationID,
+			Tags:        route.Tags,
+			Parameters:  parameters,
+			Responses: map[string]*openAPIResponse{
+				strconv.Itoa(fiber.StatusOK):              success,
+				strconv.Itoa(fiber.StatusBadRequest):      problemResponse("Invalid request parameters."),
+				strconv.Itoa(fiber.StatusTooManyRequests): problemResponse("Rate limit exceeded."),
+				"default": problemResponse("Unexpected error."),
+			},
+		}
+	}
+
+	document.Components.Schemas = generator.schemas
+
+	return document
+}
@@ -0,0 +1,177 @@
+package router
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	utils "github.com/mrflynn/air-alert/internal"
+	"github.com/mrflynn/air-alert/internal/database/redis"
+	pg "github.com/mrflynn/air-alert/internal/database/sql"
+)
+
+const (
+	apiKeyHeader = "X-API-Key"
+	apiKeyQuery  = "api_key"
+
+	// rateLimitPeriod is the period over which rate limits are measured.
+	rateLimitPeriod = time.Minute
+	// apiKeyCacheSize is the maximum number of API key lookups that are cached.
+	apiKeyCacheSize = 10000
+)
+
+func getAPIKey(ctx *fiber.Ctx) string {
+	if key := ctx.Get(apiKeyHeader); key != "" {
+		return key
+	}
+
+	return ctx.Query(apiKeyQuery)
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+// apiKeyLookup returns the details of an API key. It returns sql.ErrNoRows if the key doesn't
+// exist.
+type apiKeyLookup func(ctx context.Context, key string) (pg.APIKey, error)
+
+// rateLimitTaker takes a token from the rate limit bucket with the given identity.
+type rateLimitTaker func(ctx context.Context, identity string, limit int64, period time.Duration) (redis.RateLimitResult, error)
+
+type apiKeyCacheEntry struct {
+	apiKey  pg.APIKey
+	err     error
+	expires time.Time
+}
+
+// apiKeyCache keeps the result of API key lookups in memory for a while, so that requests don't
+// have to hit the database every time. Keys that don't exist are cached too. Revoked keys keep
+// working until their entry expires.
+type apiKeyCache struct {
+	lookup  apiKeyLookup
+	ttl     time.Duration
+	maxSize int
+	now     func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]apiKeyCacheEntry
+}
+
+// newAPIKeyCache creates a cache of at most maxSize lookups that are kept for ttl. A ttl of zero
+// or less disables caching.
+func newAPIKeyCache(lookup apiKeyLookup, ttl time.Duration, maxSize int) *apiKeyCache {
+	return &apiKeyCache{
+		lookup:  lookup,
+		ttl:     ttl,
+		maxSize: maxSize,
+		now:     time.Now,
+		entries: make(map[string]apiKeyCacheEntry),
+	}
+}
+
+// get returns the details of the given API key, from the cache if possible.
+func (c *apiKeyCache) get(ctx context.Context, key string) (pg.APIKey, error) {
+	if c.ttl <= 0 {
+		return c.lookup(ctx, key)
+	}
+
+	// Only hashes of keys are kept, like in the database.
+	hash := utils.HashToken(key)
+
+	c.mu.Lock()
+	entry, ok := c.entries[hash]
+	c.mu.Unlock()
+
+	if ok && c.now().Before(entry.expires) {
+		return entry.apiKey, entry.err
+	}
+
+	apiKey, err := c.lookup(ctx, key)
+	if err != nil && err != sql.ErrNoRows {
+		return apiKey, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if len(c.entries) >= c.maxSize {
+		for h, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, h)
+			}
+		}
+	}
+
+	// Requests with lots of different invalid keys shouldn't be able to grow the cache forever.
+	if len(c.entries) < c.maxSize {
+		c.entries[hash] = apiKeyCacheEntry{apiKey: apiKey, err: err, expires: now.Add(c.ttl)}
+	}
+
+	return apiKey, err
+}
+
+// rateLimit creates a middleware that enforces per-minute request quotas. Requests with an API key
+// are counted against the key's quota while anonymous requests are counted against the quota of
+// the client's IP address, as found by the clientIP middleware. A quota of zero or less disables rate limiting.
+func rateLimit(anonymousLimit, keyLimit int64, take rateLimitTaker, lookup apiKeyLookup) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		identity := "ip:" + getClientIP(ctx)
+		limit := anonymousLimit
+
+		if key := getAPIKey(ctx); key != "" {
+			apiKey, err := lookup(requestContext(ctx), key)
+			if err == sql.ErrNoRows {
+				return errorInfo{
+					err: fiber.ErrUnauthorized,
+					why: "invalid api key",
+				}
+			} else if err != nil {
+				requestLogger(ctx).Errorf("could not get api key: %s", err)
+
+				return errorInfo{
+					err: fiber.ErrInternalServerError,
+					why: "could not verify api key",
+				}
+			}
+
+			identity = "key:" + strconv.Itoa(apiKey.ID)
+			limit = keyLimit
+
+			if apiKey.RateLimit > 0 {
+				limit = int64(apiKey.RateLimit)
+			}
+		}
+
+		if limit <= 0 {
+			return ctx.Next()
+		}
+
+		result, err := take(requestContext(ctx), identity, limit, rateLimitPeriod)
+		if err != nil {
+			// Don't take the API down with the rate limiter.
+			requestLogger(ctx).Errorf("could not check rate limit: %s", err)
+			return ctx.Next()
+		}
+
+		ctx.Set("RateLimit-Limit", strconv.FormatInt(result.Limit, 10))
+		ctx.Set("RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+		ctx.Set("RateLimit-Reset", formatSeconds(result.Reset))
+
+		if !result.Allowed {
+			ctx.Set(fiber.HeaderRetryAfter, formatSeconds(result.RetryAfter))
+
+			return errorInfo{
+				err: fiber.ErrTooManyRequests,
+				why: "rate limit exceeded",
+			}
+		}
+
+		return ctx.Next()
+	}
+}
@@ -0,0 +1,301 @@
+// +build unit
+
+package router
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mrflynn/air-alert/internal/database/redis"
+	pg "github.com/mrflynn/air-alert/internal/database/sql"
+)
+
+// fakeRateLimiter records the buckets that tokens are taken from and returns result for all of
+// them.
+type fakeRateLimiter struct {
+	result     redis.RateLimitResult
+	err        error
+	identities []string
+	limits     []int64
+}
+
+func (f *fakeRateLimiter) take(_ context.Context, identity string, limit int64, _ time.Duration) (redis.RateLimitResult, error) {
+	f.identities = append(f.identities, identity)
+	f.limits = append(f.limits, limit)
+
+	return f.result, f.err
+}
+
+func lookupTestKey(_ context.Context, key string) (pg.APIKey, error) {
+	switch key {
+	case "default":
+		return pg.APIKey{ID: 1}, nil
+	case "custom":
+		return pg.APIKey{ID: 2, RateLimit: 100}, nil
+	case "broken":
+		return pg.APIKey{}, errors.New("connection refused")
+	default:
+		return pg.APIKey{}, sql.ErrNoRows
+	}
+}
+
+func createRateLimitTestApp(limiter *fakeRateLimiter, anonymousLimit int64) *fiber.App {
+	app := fiber.New(fiber.Config{
+		ErrorHandler: func(ctx *fiber.Ctx, err error) error {
+			if info, ok := err.(errorInfo); ok {
+				return ctx.Status(info.err.Code).SendString(info.why)
+			}
+
+			return ctx.SendStatus(fiber.StatusInternalServerError)
+		},
+	})
+
+	app.Get("/", rateLimit(anonymousLimit, 600, limiter.take, lookupTestKey), func(ctx *fiber.Ctx) error {
+		return ctx.SendStatus(fiber.StatusOK)
+	})
+
+	return app
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	limiter := &fakeRateLimiter{result: redis.RateLimitResult{
+		Allowed:   true,
+		Limit:     60,
+		Remaining: 59,
+		Reset:     1500 * time.Millisecond,
+	}}
+	app := createRateLimitTestApp(limiter, 60)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if resp.StatusCode != fiber.StatusOK {
+		t.Errorf("expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+	}
+
+	headers := map[string]string{
+		"RateLimit-Limit":      "60",
+		"RateLimit-Remaining":  "59",
+		"RateLimit-Reset":      "2",
+		fiber.HeaderRetryAfter: "",
+	}
+
+	for header, expected := range headers {
+		if value := resp.Header.Get(header); value != expected {
+			t.Errorf("expected %s header to be %q, got %q", header, expected, value)
+		}
+	}
+
+	if len(limiter.identities) != 1 || limiter.identities[0] != "ip:0.0.0.0" || limiter.limits[0] != 60 {
+		t.Errorf("expected token from anonymous bucket with limit 60, got %v %v", limiter.identities, limiter.limits)
+	}
+}
+
+func TestRateLimitExceeded(t *testing.T) {
+	limiter := &fakeRateLimiter{result: redis.RateLimitResult{
+		Limit:      60,
+		Reset:      time.Minute,
+		RetryAfter: 900 * time.Millisecond,
+	}}
+	app := createRateLimitTestApp(limiter, 60)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if resp.StatusCode != fiber.StatusTooManyRequests {
+		t.Errorf("expected status %d, got %d", fiber.StatusTooManyRequests, resp.StatusCode)
+	}
+
+	if value := resp.Header.Get(fiber.HeaderRetryAfter); value != "1" {
+		t.Errorf("expected Retry-After header to be 1, got %q", value)
+	}
+
+	if value := resp.Header.Get("RateLimit-Remaining"); value != "0" {
+		t.Errorf("expected RateLimit-Remaining header to be 0, got %q", value)
+	}
+}
+
+func TestRateLimitAPIKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		status   int
+		identity string
+		limit    int64
+	}{
+		{"default limit", "default", fiber.StatusOK, "key:1", 600},
+		{"custom limit", "custom", fiber.StatusOK, "key:2", 100},
+		{"invalid key", "invalid", fiber.StatusUnauthorized, "", 0},
+		{"lookup error", "broken", fiber.StatusInternalServerError, "", 0},
+	}
+
+	for _, test := range tests {
+		// Keys are accepted in the header and in the query string.
+		for _, useQuery := range []bool{false, true} {
+			limiter := &fakeRateLimiter{result: redis.RateLimitResult{Allowed: true}}
+			app := createRateLimitTestApp(limiter, 60)
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if useQuery {
+				req = httptest.NewRequest("GET", "/?api_key="+test.key, nil)
+			} else {
+				req.Header.Set(apiKeyHeader, test.key)
+			}
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("%s: got unexpected error: %s", test.name, err)
+			}
+
+			if resp.StatusCode != test.status {
+				t.Errorf("%s: expected status %d, got %d", test.name, test.status, resp.StatusCode)
+			}
+
+			if test.identity == "" {
+				if len(limiter.identities) != 0 {
+					t.Errorf("%s: expected no token to be taken, got %v", test.name, limiter.identities)
+				}
+
+				continue
+			}
+
+			if len(limiter.identities) != 1 || limiter.identities[0] != test.identity || limiter.limits[0] != test.limit {
+				t.Errorf(
+					"%s: expected token from %s with limit %d, got %v %v",
+					test.name, test.identity, test.limit, limiter.identities, limiter.limits,
+				)
+			}
+		}
+	}
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	limiter := &fakeRateLimiter{}
+	app := createRateLimitTestApp(limiter, 0)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if resp.StatusCode != fiber.StatusOK {
+		t.Errorf("expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+	}
+
+	if len(limiter.identities) != 0 || resp.Header.Get("RateLimit-Limit") != "" {
+		t.Error("expected rate limiting to be disabled")
+	}
+}
+
+func TestRateLimitUnavailable(t *testing.T) {
+	limiter := &fakeRateLimiter{err: errors.New("connection refused")}
+	app := createRateLimitTestApp(limiter, 60)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if resp.StatusCode != fiber.StatusOK {
+		t.Errorf("expected requests to be allowed without rate limiter, got status %d", resp.StatusCode)
+	}
+}
+
+func TestAPIKeyCache(t *testing.T) {
+	lookups := map[string]int{}
+	cache := newAPIKeyCache(func(ctx context.Context, key string) (pg.APIKey, error) {
+		lookups[key]++
+		return lookupTestKey(ctx, key)
+	}, time.Minute, 2)
+
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if apiKey, err := cache.get(context.Background(), "custom"); err != nil || apiKey.ID != 2 {
+			t.Errorf("expected key 2, got %+v (%v)", apiKey, err)
+		}
+
+		if _, err := cache.get(context.Background(), "invalid"); err != sql.ErrNoRows {
+			t.Errorf("expected sql.ErrNoRows for invalid key, got %v", err)
+		}
+
+		// Other errors aren't cached.
+		if _, err := cache.get(context.Background(), "broken"); err == nil {
+			t.Error("expected error from failed lookup")
+		}
+	}
+
+	if lookups["custom"] != 1 || lookups["invalid"] != 1 || lookups["broken"] != 2 {
+		t.Errorf("got unexpected lookups %v", lookups)
+	}
+
+	// The cache is full, so this key isn't cached.
+	cache.get(context.Background(), "default")
+	cache.get(context.Background(), "default")
+
+	if lookups["default"] != 2 {
+		t.Errorf("expected key to be looked up twice when cache is full, got %d", lookups["default"])
+	}
+
+	// Expired entries are looked up again and make room for new ones.
+	now = now.Add(time.Minute)
+
+	cache.get(context.Background(), "custom")
+	cache.get(context.Background(), "default")
+	cache.get(context.Background(), "default")
+
+	if lookups["custom"] != 2 || lookups["default"] != 3 {
+		t.Errorf("got unexpected lookups after expiry %v", lookups)
+	}
+}
+
+func TestAPIKeyCacheDisabled(t *testing.T) {
+	lookups := 0
+	cache := newAPIKeyCache(func(ctx context.Context, key string) (pg.APIKey, error) {
+		lookups++
+		return lookupTestKey(ctx, key)
+	}, 0, apiKeyCacheSize)
+
+	cache.get(context.Background(), "default")
+	cache.get(context.Background(), "default")
+
+	if lookups != 2 {
+		t.Errorf("expected every lookup to hit the database, got %d lookups", lookups)
+	}
+}
+
+func TestRateLimitBehindProxy(t *testing.T) {
+	limiter := &fakeRateLimiter{result: redis.RateLimitResult{Allowed: true}}
+
+	proxies, err := parseTrustedProxies([]string{"0.0.0.0"})
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	app := fiber.New()
+	app.Use(clientIP(fiber.HeaderXForwardedFor, proxies))
+	app.Get("/", rateLimit(60, 600, limiter.take, lookupTestKey), func(ctx *fiber.Ctx) error {
+		return ctx.SendStatus(fiber.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(fiber.HeaderXForwardedFor, "1.2.3.4")
+
+	if _, err := app.Test(req); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if len(limiter.identities) != 1 || limiter.identities[0] != "ip:1.2.3.4" {
+		t.Errorf("expected token from bucket of the forwarded client, got %v", limiter.identities)
+	}
+}
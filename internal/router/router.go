@@ -13,6 +13,8 @@ import (
 	utils "github.com/mrflynn/air-alert/internal"
 	"github.com/mrflynn/air-alert/internal/database/redis"
 	"github.com/mrflynn/air-alert/internal/database/sql"
+	"github.com/mrflynn/air-alert/internal/notifications"
+	"github.com/mrflynn/air-alert/internal/task"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"golang.org/x/crypto/acme/autocert"
@@ -20,11 +22,17 @@ import (
 
 // Router is the main application HTTP router.
 type Router struct {
-	Address string
+	Address        string
+	MetricsAddress string
 
 	app       *fiber.App
+	metrics   *fiber.App
 	datastore *redis.Controller
 	database  *sql.Controller
+	notifier  *notifications.Sender
+	tasks     *task.Runner
+	live      *aqiHub
+	sockets   *wsLimits
 }
 
 type errorInfo struct {
@@ -37,7 +45,12 @@ func (e errorInfo) Error() string {
 }
 
 // NewRouter creates a new Router struct from the given context.
-func NewRouter(datastore *redis.Controller, database *sql.Controller) *Router {
+func NewRouter(datastore *redis.Controller, database *sql.Controller, notifier *notifications.Sender, tasks *task.Runner) (*Router, error) {
+	proxies, err := parseTrustedProxies(viper.GetStringSlice("web.proxy.trusted"))
+	if err != nil {
+		return nil, err
+	}
+
 	router := &Router{
 		Address: viper.GetString("web.addr"),
 		app: fiber.New(fiber.Config{
@@ -56,14 +69,46 @@ func NewRouter(datastore *redis.Controller, database *sql.Controller) *Router {
 		}),
 		datastore: datastore,
 		database:  database,
+		notifier:  notifier,
+		tasks:     tasks,
+		live:      newAQIHub(datastore),
+		sockets: newWSLimits(
+			viper.GetInt("web.websocket.max_connections"),
+			viper.GetInt("web.websocket.max_connections_per_ip"),
+		),
 	}
 
+	// Registered first so that every request is traced, tagged with an ID and its duration is
+	// recorded.
+	router.app.Use(requestTracing)
+	router.app.Use(requestID)
+	router.app.Use(requestMetrics)
+	router.app.Use(clientIP(viper.GetString("web.proxy.header"), proxies))
 	router.app.Static("/", viper.GetString("web.static_dir"))
 
-	return router
+	// Metrics are served on their own address so that they can be kept off the public network.
+	if viper.GetBool("web.metrics.enable") {
+		router.MetricsAddress = viper.GetString("web.metrics.addr")
+		router.metrics = fiber.New(fiber.Config{
+			DisableStartupMessage: true,
+			ReadTimeout:           10 * time.Second,
+			WriteTimeout:          30 * time.Second,
+			IdleTimeout:           30 * time.Second,
+		})
+	}
+
+	return router, nil
 }
 
 func (r *Router) addRoutes() {
+	apiKeys := newAPIKeyCache(r.database.GetAPIKey, viper.GetDuration("web.api.key_cache_ttl"), apiKeyCacheSize)
+	limiter := rateLimit(
+		viper.GetInt64("web.api.anonymous_rate_limit"),
+		viper.GetInt64("web.api.key_rate_limit"),
+		r.datastore.TakeRateLimitToken,
+		apiKeys.get,
+	)
+
 	r.app.Get("/", func(ctx *fiber.Ctx) error {
 		return ctx.Render("components/home", fiber.Map{}, "index")
 	})
@@ -75,7 +120,7 @@ func (r *Router) addRoutes() {
 	r.app.Get("/subscribe/key", func(ctx *fiber.Ctx) error {
 		key, err := base64.RawURLEncoding.DecodeString(viper.GetString("web.notifications.public_key"))
 		if err != nil {
-			log.Errorf("could not decode vapid public key: %s", err)
+			requestLogger(ctx).Errorf("could not decode vapid public key: %s", err)
 
 			return errorInfo{
 				err: fiber.ErrInternalServerError,
@@ -86,20 +131,104 @@ func (r *Router) addRoutes() {
 		return ctx.Send(key)
 	})
 
+	// Test notifications are sent right away, so they are limited like the API.
+	r.app.Post("/subscription/test", limiter, func(ctx *fiber.Ctx) error {
+		return testSubscription(ctx, r.database, r.notifier)
+	})
+
 	r.app.Delete("/unsubscribe", func(ctx *fiber.Ctx) error {
 		return unsubscribeFromNofications(ctx, r.database)
 	})
 
-	r.app.Get("/aqi/:latitude/:longitude", func(ctx *fiber.Ctx) error {
+	r.app.Get("/tiles/:z/:x/:y.png", func(ctx *fiber.Ctx) error {
+		return getTile(ctx, r.datastore)
+	})
+
+	r.app.Get("/healthz", checkLiveness)
+
+	readinessChecks := []readinessCheck{
+		pingCheck("redis", r.datastore.Ping),
+		pingCheck("postgres", r.database.Ping),
+		freshnessCheck("sensor_map", r.datastore.GetLastSensorUpdate, viper.GetDuration("web.health.max_sensor_age")),
+		freshnessCheck("aqi", r.datastore.GetLastAQIUpdate, viper.GetDuration("web.health.max_aqi_age")),
+	}
+
+	r.app.Get("/readyz", func(ctx *fiber.Ctx) error {
+		return checkReadiness(ctx, readinessChecks)
+	})
+
+	if r.metrics != nil {
+		r.metrics.Get("/metrics", metricsHandler(&stateCollector{
+			datastore: r.datastore,
+			database:  r.database,
+			group:     viper.GetString("web.notifications.group"),
+			live:      r.live,
+			sockets:   r.sockets,
+		}))
+	}
+
+	r.app.Get("/aqi/:latitude/:longitude", limiter, func(ctx *fiber.Ctx) error {
 		return getAverageAQI(ctx, r.datastore)
 	})
 
-	api := r.app.Group("/api/v0")
+	r.app.Get("/aqi/:latitude/:longitude/events", limiter, func(ctx *fiber.Ctx) error {
+		return streamAQI(ctx, r.live)
+	})
+
+	r.app.Get("/ws", limiter, func(ctx *fiber.Ctx) error {
+		return serveWebSocket(ctx, r.live, r.sockets, viper.GetInt("web.websocket.max_subscriptions"))
+	})
+
+	admin := r.app.Group("/admin", adminAuth(viper.GetStringSlice("web.admin.tokens")))
+
+	admin.Post("/broadcast", func(ctx *fiber.Ctx) error {
+		return broadcastMessage(ctx, r.notifier)
+	})
+
+	admin.Get("/users", func(ctx *fiber.Ctx) error {
+		return listUsers(ctx, r.database)
+	})
+
+	admin.Get("/users/:id", func(ctx *fiber.Ctx) error {
+		return getUser(ctx, r.database)
+	})
+
+	admin.Delete("/users/:id", func(ctx *fiber.Ctx) error {
+		return deleteUser(ctx, r.database)
+	})
+
+	admin.Get("/notifications", func(ctx *fiber.Ctx) error {
+		return getNotificationStreamInfo(ctx, r.datastore)
+	})
+
+	admin.Get("/tasks", func(ctx *fiber.Ctx) error {
+		return getTaskStatus(ctx, r.tasks)
+	})
+
+	admin.Get("/tasks/:name/history", func(ctx *fiber.Ctx) error {
+		return getTaskHistory(ctx, r.tasks)
+	})
+
+	admin.Post("/tasks/:name/run", func(ctx *fiber.Ctx) error {
+		return runTask(ctx, r.tasks)
+	})
+
+	admin.Post("/tasks/:name/pause", func(ctx *fiber.Ctx) error {
+		return pauseTask(ctx, r.tasks, true)
+	})
+
+	admin.Post("/tasks/:name/resume", func(ctx *fiber.Ctx) error {
+		return pauseTask(ctx, r.tasks, false)
+	})
+
+	api := r.app.Group("/api/v0", limiter)
 	locationGroup := api.Group("/:latitude/:longitude")
 
 	locationGroup.Get("/data", func(ctx *fiber.Ctx) error {
 		return getAQIReadings(ctx, r.datastore)
 	})
+
+	r.addAPIV1Routes(limiter)
 }
 
 // Run starts the router and handles all shutdown operations if an external shutdown signal is
@@ -109,6 +238,10 @@ func (r *Router) Run() error {
 
 	r.addRoutes()
 
+	if err := r.live.start(); err != nil {
+		return err
+	}
+
 	var ln net.Listener
 	if viper.GetBool("web.ssl.enable") {
 		cache, err := utils.NewCache()
@@ -148,12 +281,38 @@ func (r *Router) Run() error {
 		err = r.app.Listener(ln)
 	}()
 
+	if r.metrics != nil {
+		metricsLn, err := net.Listen("tcp4", r.MetricsAddress)
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			log.Infof("metrics are now served at %s", r.MetricsAddress)
+			if err := r.metrics.Listener(metricsLn); err != nil {
+				log.Errorf("could not serve metrics: %s", err)
+			}
+		}()
+	}
+
 	return err
 }
 
 // Shutdown attempts to safely shutdown the router.
 func (r *Router) Shutdown() error {
 	log.Debug("attempting to shutdown router...")
+
+	// Open event streams would otherwise keep the server from shutting down.
+	if err := r.live.close(); err != nil {
+		log.Errorf("could not close live aqi subscription: %s", err)
+	}
+
+	if r.metrics != nil {
+		if err := r.metrics.Shutdown(); err != nil {
+			log.Errorf("could not shutdown metrics server: %s", err)
+		}
+	}
+
 	err := r.app.Shutdown()
 	log.Debug("router has shutdown")
 	return err
@@ -0,0 +1,139 @@
+package router
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mrflynn/air-alert/internal/database/redis"
+	"github.com/mrflynn/air-alert/internal/tiles"
+	"github.com/mrflynn/go-aqi"
+)
+
+// tileInfluenceRadius is the distance in meters over which a sensor affects the heatmap.
+const tileInfluenceRadius = 10000.0
+
+func getTileCoordinates(ctx *fiber.Ctx) (int, int, int, error) {
+	coordinates := make([]int, 0, 3)
+
+	for _, key := range []string{"z", "x", "y"} {
+		value, err := strconv.Atoi(ctx.Params(key))
+		if err != nil {
+			return 0, 0, 0, errorInfo{
+				err: fiber.ErrBadRequest,
+				why: "invalid " + key + " parameter",
+			}
+		}
+
+		coordinates = append(coordinates, value)
+	}
+
+	z, x, y := coordinates[0], coordinates[1], coordinates[2]
+	if !tiles.Valid(z, x, y) {
+		return 0, 0, 0, errorInfo{
+			err: fiber.ErrNotFound,
+			why: "tile not found",
+		}
+	}
+
+	return z, x, y, nil
+}
+
+func sendTile(ctx *fiber.Ctx, tile []byte, maxAge time.Duration) error {
+	ctx.Set(fiber.HeaderCacheControl, "public, max-age="+strconv.Itoa(int(maxAge.Seconds())))
+	return ctx.Type("png").Send(tile)
+}
+
+// getTileSamples returns the latest AQI of every sensor that can affect the given tile.
+func getTileSamples(ctx *fiber.Ctx, datastore *redis.Controller, z, x, y int) ([]tiles.Sample, error) {
+	bounds := tiles.Expand(tiles.Bounds(z, x, y), tileInfluenceRadius)
+
+	locations, err := datastore.GetSensorLocationsInArea(requestContext(ctx), bounds)
+	if err != nil {
+		requestLogger(ctx).Errorf("GetSensorLocationsInArea error: %s", err)
+
+		return nil, errorInfo{
+			err: fiber.ErrInternalServerError,
+			why: "could not get sensor locations from database",
+		}
+	}
+
+	history, err := getSensorHistory(requestContext(ctx), datastore, locations, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make([]tiles.Sample, 0, len(locations))
+	for _, location := range locations {
+		var latest *redis.RawQualityData
+		for _, reading := range history[location.ID] {
+			if latest == nil || reading.Time > latest.Time {
+				latest = reading
+			}
+		}
+
+		if latest == nil {
+			continue
+		}
+
+		result, err := aqi.Calculate(aqi.PM25{Concentration: latest.PM25})
+		if err != nil {
+			continue
+		}
+
+		samples = append(samples, tiles.Sample{
+			Longitude: location.Longitude,
+			Latitude:  location.Latitude,
+			AQI:       result.AQI,
+		})
+	}
+
+	return samples, nil
+}
+
+func getTile(ctx *fiber.Ctx, datastore *redis.Controller) error {
+	z, x, y, err := getTileCoordinates(ctx)
+	if err != nil {
+		return err
+	}
+
+	generation, err := datastore.GetAQIGeneration(requestContext(ctx))
+	if err != nil {
+		requestLogger(ctx).Errorf("could not get aqi generation: %s", err)
+
+		return errorInfo{
+			err: fiber.ErrInternalServerError,
+			why: "could not get tile from cache",
+		}
+	}
+
+	tile, err := datastore.GetTile(requestContext(ctx), generation, z, x, y)
+	if err != nil {
+		// Rendering the tile again is slower but still works.
+		requestLogger(ctx).Errorf("could not get tile %d/%d/%d from cache: %s", z, x, y, err)
+	} else if tile != nil {
+		return sendTile(ctx, tile, datastore.AQIRefreshInterval())
+	}
+
+	samples, err := getTileSamples(ctx, datastore, z, x, y)
+	if err != nil {
+		return err
+	}
+
+	tile, err = tiles.Encode(tiles.Render(z, x, y, samples, tileInfluenceRadius))
+	if err != nil {
+		requestLogger(ctx).Errorf("could not encode tile %d/%d/%d: %s", z, x, y, err)
+
+		return errorInfo{
+			err: fiber.ErrInternalServerError,
+			why: "could not render tile",
+		}
+	}
+
+	err = datastore.SetTile(requestContext(ctx), generation, z, x, y, tile)
+	if err != nil {
+		requestLogger(ctx).Errorf("could not cache tile %d/%d/%d: %s", z, x, y, err)
+	}
+
+	return sendTile(ctx, tile, datastore.AQIRefreshInterval())
+}
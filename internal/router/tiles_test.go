@@ -0,0 +1,47 @@
+// +build unit
+
+package router
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestGetTileInvalidCoordinates(t *testing.T) {
+	app := fiber.New(fiber.Config{
+		ErrorHandler: func(ctx *fiber.Ctx, err error) error {
+			if info, ok := err.(errorInfo); ok {
+				return ctx.Status(info.err.Code).SendString(info.why)
+			}
+
+			return ctx.SendStatus(fiber.StatusInternalServerError)
+		},
+	})
+
+	app.Get("/tiles/:z/:x/:y.png", func(ctx *fiber.Ctx) error {
+		return getTile(ctx, nil)
+	})
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/tiles/abc/0/0.png", fiber.StatusBadRequest},
+		{"/tiles/10/0/abc.png", fiber.StatusBadRequest},
+		{"/tiles/2/0/0.png", fiber.StatusNotFound},
+		{"/tiles/10/1024/0.png", fiber.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		resp, err := app.Test(httptest.NewRequest("GET", test.path, nil))
+		if err != nil {
+			t.Fatalf("got unexpected error: %s", err)
+		}
+
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.path, test.status, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package router
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	fiberutils "github.com/gofiber/fiber/v2/utils"
+	"github.com/mrflynn/air-alert/internal/tracing"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/semconv"
+)
+
+// requestContextKey stores the context of the request, which carries the request span and logger,
+// in the request locals.
+const requestContextKey = "request_context"
+
+// responseStatus returns the status code the request is answered with. Errors are only turned into
+// responses by the error handler once every handler has returned, so the status has to be derived
+// the same way here.
+func responseStatus(ctx *fiber.Ctx, err error) int {
+	if err == nil {
+		return ctx.Response().StatusCode()
+	}
+
+	if info, ok := err.(errorInfo); ok {
+		return info.err.Code
+	}
+
+	return fiber.StatusInternalServerError
+}
+
+// requestTracing is a middleware that records a span for every request. Requests that carry a W3C
+// traceparent header continue the trace of the caller.
+func requestTracing(ctx *fiber.Ctx) error {
+	c := tracing.WithTraceParent(ctx.Context(), ctx.Get("traceparent"))
+	c, span := tracing.Start(c, ctx.Method()+" "+ctx.Path(),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPMethodKey.String(ctx.Method()),
+			// The query string may hold an API key, so only the path is recorded.
+			semconv.HTTPTargetKey.String(fiberutils.ImmutableString(ctx.Path())),
+		),
+	)
+	defer span.End()
+
+	ctx.Locals(requestContextKey, c)
+	err := ctx.Next()
+
+	// Spans are named by route rather than path so that path parameters don't create new names.
+	status := responseStatus(ctx, err)
+	span.SetName(ctx.Method() + " " + ctx.Route().Path)
+	span.SetAttributes(
+		semconv.HTTPRouteKey.String(ctx.Route().Path),
+		semconv.HTTPStatusCodeKey.Int(status),
+	)
+
+	// Client errors are the fault of the caller, so only server errors fail the span.
+	if status >= fiber.StatusInternalServerError {
+		span.SetStatus(codes.Internal, http.StatusText(status))
+	}
+
+	return err
+}
+
+// requestContext returns the context that database calls made while handling a request should use,
+// so that they are recorded as part of the request span and log with the request ID.
+func requestContext(ctx *fiber.Ctx) context.Context {
+	if c, ok := ctx.Locals(requestContextKey).(context.Context); ok {
+		return c
+	}
+
+	return ctx.Context()
+}
@@ -0,0 +1,70 @@
+// +build unit
+
+package router
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/semconv"
+	export "go.opentelemetry.io/otel/sdk/export/trace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []*export.SpanData
+}
+
+func (m *memoryExporter) ExportSpan(ctx context.Context, span *export.SpanData) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.spans = append(m.spans, span)
+}
+
+func TestRequestTracingOmitsQuery(t *testing.T) {
+	m := &memoryExporter{}
+
+	provider, err := sdktrace.NewProvider(sdktrace.WithSyncer(m))
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	previous := global.TraceProvider()
+	global.SetTraceProvider(provider)
+	defer global.SetTraceProvider(previous)
+
+	app := fiber.New()
+	app.Use(requestTracing)
+	app.Get("/aqi/:latitude/:longitude", func(ctx *fiber.Ctx) error {
+		return ctx.SendStatus(fiber.StatusOK)
+	})
+
+	if _, err := app.Test(httptest.NewRequest("GET", "/aqi/37.7/-122.4?api_key=secret", nil)); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(m.spans))
+	}
+
+	for _, attribute := range m.spans[0].Attributes {
+		if attribute.Key == semconv.HTTPTargetKey {
+			if target := attribute.Value.AsString(); target != "/aqi/37.7/-122.4" {
+				t.Errorf("expected target /aqi/37.7/-122.4, got %s", target)
+			}
+
+			return
+		}
+	}
+
+	t.Errorf("expected span to record the target")
+}
@@ -0,0 +1,251 @@
+package router
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	utils "github.com/mrflynn/air-alert/internal"
+	"github.com/mrflynn/air-alert/internal/database/redis"
+	"github.com/mrflynn/air-alert/internal/geo"
+	"github.com/mrflynn/go-aqi"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	// maxZoom is the highest zoom level supported by common web map clients.
+	maxZoom = 22
+	// clusterCellsPerTile is the number of clustering grid cells along each side of a map tile.
+	// With 256 pixel tiles, this clusters sensors that are within roughly 64 pixels of each other.
+	clusterCellsPerTile = 4
+)
+
+// zoomForBounds estimates the zoom level of a map that is showing the given bounds.
+func zoomForBounds(bounds geo.Bounds) int {
+	span := math.Max(bounds.MaxLongitude-bounds.MinLongitude, bounds.MaxLatitude-bounds.MinLatitude)
+	if span <= 0 {
+		return maxZoom
+	}
+
+	zoom := int(math.Floor(math.Log2(360 / span)))
+	if zoom < 0 {
+		return 0
+	} else if zoom > maxZoom {
+		return maxZoom
+	}
+
+	return zoom
+}
+
+func getZoom(ctx *fiber.Ctx, bounds geo.Bounds) (int, error) {
+	if ctx.Query("zoom") == "" {
+		return zoomForBounds(bounds), nil
+	}
+
+	zoom, err := strconv.Atoi(ctx.Query("zoom"))
+	if err != nil || zoom < 0 || zoom > maxZoom {
+		return 0, errorInfo{
+			err: fiber.ErrBadRequest,
+			why: "invalid zoom parameter",
+		}
+	}
+
+	return zoom, nil
+}
+
+// clusterFeatures groups sensor features that fall into the same cell of a global grid. The size
+// of each cell depends on the zoom level, so fewer clusters are created as the map is zoomed in.
+// The grid does not move with the viewport, so clusters stay put while the map is panned. Cells
+// that contain a single sensor are left as is.
+func clusterFeatures(features []feature, zoom int) []feature {
+	cellSize := 360 / math.Pow(2, float64(zoom)) / clusterCellsPerTile
+
+	cells := make(map[[2]int][]feature)
+	keys := make([][2]int, 0)
+
+	for _, f := range features {
+		key := [2]int{
+			int(math.Floor((f.Geometry.Coordinates[0] + 180) / cellSize)),
+			int(math.Floor((f.Geometry.Coordinates[1] + 90) / cellSize)),
+		}
+
+		// Keep track of the order cells are first seen in so that the output is deterministic.
+		if _, ok := cells[key]; !ok {
+			keys = append(keys, key)
+		}
+
+		cells[key] = append(cells[key], f)
+	}
+
+	clustered := make([]feature, 0, len(keys))
+	for _, key := range keys {
+		members := cells[key]
+		if len(members) == 1 {
+			clustered = append(clustered, members[0])
+			continue
+		}
+
+		cluster, ok := newClusterFeature(members)
+		if !ok {
+			clustered = append(clustered, members...)
+			continue
+		}
+
+		clustered = append(clustered, cluster)
+	}
+
+	return clustered
+}
+
+// newClusterFeature creates a single feature that summarizes a group of sensors. The cluster is
+// placed at the centroid of its members and its AQI is calculated from their mean PM2.5.
+func newClusterFeature(members []feature) (feature, bool) {
+	var (
+		longitude, latitude, pm25 float64
+		lastSeen                  time.Time
+	)
+
+	for _, m := range members {
+		longitude += m.Geometry.Coordinates[0]
+		latitude += m.Geometry.Coordinates[1]
+		pm25 += m.Properties.PM25
+
+		if m.Properties.LastSeen.After(lastSeen) {
+			lastSeen = m.Properties.LastSeen
+		}
+	}
+
+	count := float64(len(members))
+
+	result, err := aqi.Calculate(aqi.PM25{Concentration: pm25 / count})
+	if err != nil {
+		log.Debugf("could not calculate aqi for cluster: %s", err)
+		return feature{}, false
+	}
+
+	index := utils.AQIIndex(result.AQI)
+
+	matches := 0
+	for _, m := range members {
+		if m.Properties.Category == index.Name {
+			matches++
+		}
+	}
+
+	roundedAQI, _ := decimal.NewFromFloat(result.AQI).Round(1).Float64()
+	roundedPM25, _ := decimal.NewFromFloat(pm25 / count).Round(2).Float64()
+
+	return feature{
+		Type: "Feature",
+		Geometry: pointGeometry{
+			Type:        "Point",
+			Coordinates: [2]float64{longitude / count, latitude / count},
+		},
+		Properties: sensorProperties{
+			AQI:        roundedAQI,
+			PM25:       roundedPM25,
+			Category:   index.Name,
+			LastSeen:   lastSeen,
+			Confidence: matches * 100 / len(members),
+			Cluster:    true,
+			PointCount: len(members),
+		},
+	}, true
+}
+
+func getViewportFeatures(ctx *fiber.Ctx, datastore *redis.Controller) error {
+	bounds, err := geo.ParseBounds(ctx.Query("bbox"))
+	if err != nil {
+		return errorInfo{
+			err: fiber.ErrBadRequest,
+			why: "invalid or missing bbox parameter: " + err.Error(),
+		}
+	}
+
+	zoom, err := getZoom(ctx, bounds)
+	if err != nil {
+		return err
+	}
+
+	maxSensors := viper.GetInt("web.api.max_sensors")
+	cluster := zoom < viper.GetInt("web.api.cluster_max_zoom")
+
+	// Clusters summarize every sensor in them, so all sensors are clustered before the limit is
+	// applied to the clusters instead.
+	limit := maxSensors
+	if cluster {
+		limit = 0
+	}
+
+	locations, truncated, err := datastore.GetSensorsInArea(requestContext(ctx), bounds, limit)
+	if err != nil {
+		requestLogger(ctx).Errorf("GetSensorsInArea error: %s", err)
+
+		return errorInfo{
+			err: fiber.ErrInternalServerError,
+			why: "could not get sensor locations from database",
+		}
+	}
+
+	// Clusters only need the latest reading of each sensor.
+	count := confidenceHistory(datastore)
+	if cluster {
+		count = 0
+	}
+
+	history, err := getSensorHistory(requestContext(ctx), datastore, locations, count)
+	if err != nil {
+		return err
+	}
+
+	collection := newFeatureCollection(locations, history)
+	collection.Truncated = truncated
+
+	if cluster {
+		collection.Features = clusterFeatures(collection.Features, zoom)
+
+		// Sensors are sorted by distance from the center of the viewport, so the clusters closest
+		// to the center come first.
+		if maxSensors > 0 && len(collection.Features) > maxSensors {
+			collection.Features = collection.Features[:maxSensors]
+			collection.Truncated = true
+		}
+
+		if err := addSensorConfidence(ctx, datastore, collection.Features); err != nil {
+			return err
+		}
+	}
+
+	return sendGeoJSON(ctx, collection)
+}
+
+// addSensorConfidence computes the confidence of the sensors that were left out of clusters from
+// their reading history, since only their latest reading was loaded for clustering.
+func addSensorConfidence(ctx *fiber.Ctx, datastore *redis.Controller, features []feature) error {
+	sensors := make([]redis.SensorLocation, 0)
+	for _, f := range features {
+		if !f.Properties.Cluster {
+			sensors = append(sensors, redis.SensorLocation{ID: f.ID})
+		}
+	}
+
+	history, err := getSensorHistory(requestContext(ctx), datastore, sensors, confidenceHistory(datastore))
+	if err != nil {
+		return err
+	}
+
+	for i, f := range features {
+		if f.Properties.Cluster {
+			continue
+		}
+
+		if properties, ok := newSensorProperties(f.ID, history[f.ID]); ok {
+			features[i].Properties = properties
+		}
+	}
+
+	return nil
+}
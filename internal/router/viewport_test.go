@@ -0,0 +1,215 @@
+// +build unit
+
+package router
+
+import (
+	"context"
+	stdjson "encoding/json"
+	"math"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mrflynn/air-alert/internal/database/redis"
+	"github.com/mrflynn/air-alert/internal/geo"
+	"github.com/mrflynn/air-alert/internal/purpleapi"
+	"github.com/spf13/viper"
+)
+
+var viewportBounds = geo.Bounds{MinLatitude: 37.0, MinLongitude: -123.0, MaxLatitude: 38.0, MaxLongitude: -122.0}
+
+func createViewportFeatures() []feature {
+	collection := newFeatureCollection(
+		[]redis.SensorLocation{
+			{ID: 1, Longitude: -122.40, Latitude: 37.70},
+			{ID: 2, Longitude: -122.41, Latitude: 37.71},
+			{ID: 3, Longitude: -122.90, Latitude: 37.10},
+		},
+		map[int][]*redis.RawQualityData{
+			1: {{Time: 1600000000, PM25: 10}},
+			2: {{Time: 1600000300, PM25: 20}},
+			3: {{Time: 1600000000, PM25: 50}},
+		},
+	)
+
+	return collection.Features
+}
+
+func TestZoomForBounds(t *testing.T) {
+	tests := []struct {
+		bounds geo.Bounds
+		zoom   int
+	}{
+		{geo.Bounds{MinLatitude: -90, MinLongitude: -180, MaxLatitude: 90, MaxLongitude: 180}, 0},
+		{viewportBounds, 8},
+		{geo.Bounds{MinLatitude: 37, MinLongitude: -122, MaxLatitude: 37, MaxLongitude: -122}, maxZoom},
+	}
+
+	for _, test := range tests {
+		if zoom := zoomForBounds(test.bounds); zoom != test.zoom {
+			t.Errorf("%+v: expected zoom %d, got %d", test.bounds, test.zoom, zoom)
+		}
+	}
+}
+
+func TestClusterFeatures(t *testing.T) {
+	features := clusterFeatures(createViewportFeatures(), 8)
+	if len(features) != 2 {
+		t.Fatalf("expected 2 features, got %d", len(features))
+	}
+
+	cluster := features[0]
+	if !cluster.Properties.Cluster || cluster.Properties.PointCount != 2 || cluster.ID != 0 {
+		t.Errorf("expected cluster of 2 sensors, got %+v", cluster)
+	}
+
+	if math.Abs(cluster.Geometry.Coordinates[0]+122.405) > 1e-9 ||
+		math.Abs(cluster.Geometry.Coordinates[1]-37.705) > 1e-9 {
+		t.Errorf("got unexpected cluster coordinates %v", cluster.Geometry.Coordinates)
+	}
+
+	// The mean PM2.5 of 15 is moderate while only one member is moderate.
+	if cluster.Properties.PM25 != 15 || cluster.Properties.Category != "Moderate" || cluster.Properties.Confidence != 50 {
+		t.Errorf("got unexpected cluster properties %+v", cluster.Properties)
+	}
+
+	if cluster.Properties.LastSeen.Unix() != 1600000300 {
+		t.Errorf("expected most recent last seen time, got %s", cluster.Properties.LastSeen)
+	}
+
+	if features[1].Properties.Cluster || features[1].ID != 3 {
+		t.Errorf("expected lone sensor to be left as is, got %+v", features[1])
+	}
+}
+
+func TestClusterFeaturesZoomedIn(t *testing.T) {
+	features := clusterFeatures(createViewportFeatures(), 16)
+	if len(features) != 3 {
+		t.Errorf("expected 3 features, got %d", len(features))
+	}
+}
+
+func TestViewportInvalidParameters(t *testing.T) {
+	r := createAPIV1TestApp()
+
+	for _, path := range []string{
+		"/api/v1/sensors/viewport",
+		"/api/v1/sensors/viewport?bbox=38,-122,37,-123",
+		"/api/v1/sensors/viewport?bbox=37,-123,38,-122&zoom=30",
+		"/api/v1/sensors/viewport?bbox=37,-123,38,-122&zoom=abc",
+	} {
+		resp, err := r.app.Test(httptest.NewRequest("GET", path, nil))
+		if err != nil {
+			t.Fatalf("got unexpected error: %s", err)
+		}
+
+		if resp.StatusCode != fiber.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", path, fiber.StatusBadRequest, resp.StatusCode)
+		}
+	}
+}
+
+func TestViewportLimit(t *testing.T) {
+	r := &Router{app: fiber.New(), datastore: createAPIV1TestStore(t)}
+	r.addAPIV1Routes()
+
+	viper.Set("web.api.max_sensors", 1)
+	viper.Set("web.api.cluster_max_zoom", 12)
+	defer viper.Set("web.api.max_sensors", nil)
+	defer viper.Set("web.api.cluster_max_zoom", nil)
+
+	tests := []struct {
+		zoom       int
+		truncated  bool
+		pointCount int
+	}{
+		// Both sensors are clustered before the limit is applied.
+		{8, false, 2},
+		{14, true, 0},
+	}
+
+	for _, test := range tests {
+		path := "/api/v1/sensors/viewport?bbox=37.6,-122.5,37.8,-122.3&zoom=" + strconv.Itoa(test.zoom)
+
+		resp, err := r.app.Test(httptest.NewRequest("GET", path, nil))
+		if err != nil {
+			t.Fatalf("got unexpected error: %s", err)
+		}
+
+		var collection featureCollection
+		if err := stdjson.NewDecoder(resp.Body).Decode(&collection); err != nil {
+			t.Fatalf("could not decode response: %s", err)
+		}
+
+		if len(collection.Features) != 1 {
+			t.Fatalf("zoom %d: expected 1 feature, got %d", test.zoom, len(collection.Features))
+		}
+
+		properties := collection.Features[0].Properties
+		if collection.Truncated != test.truncated || properties.PointCount != test.pointCount {
+			t.Errorf(
+				"zoom %d: expected truncated %t with %d points, got truncated %t with %d points",
+				test.zoom, test.truncated, test.pointCount, collection.Truncated, properties.PointCount,
+			)
+		}
+	}
+}
+
+func TestViewportClusteredSensorConfidence(t *testing.T) {
+	datastore := createAPIV1TestStore(t)
+	ctx := context.Background()
+
+	// Sensor 3 is far enough from the others not to be clustered with them, and its readings are
+	// in different categories.
+	now := time.Now().Unix()
+	sensors := []purpleapi.Response{
+		{ID: 1, Location: purpleapi.Outside, Latitude: 37.7, Longitude: -122.4},
+		{ID: 2, Location: purpleapi.Outside, Latitude: 37.701, Longitude: -122.401},
+		{ID: 3, Location: purpleapi.Outside, Latitude: 37.79, Longitude: -122.31},
+	}
+
+	if err := datastore.SetSensorLocationData(ctx, sensors); err != nil {
+		t.Fatalf("could not store sensor locations: %s", err)
+	}
+
+	for i, pm25 := range []float64{5, 100} {
+		reading := purpleapi.Response{ID: 3, Location: purpleapi.Outside, LastUpdated: now - 120 + int64(i)*60, PM25: pm25}
+		if _, _, err := datastore.SetAirQuality(ctx, []purpleapi.Response{reading}); err != nil {
+			t.Fatalf("could not store readings: %s", err)
+		}
+	}
+
+	r := &Router{app: fiber.New(), datastore: datastore}
+	r.addAPIV1Routes()
+
+	viper.Set("web.api.cluster_max_zoom", 12)
+	defer viper.Set("web.api.cluster_max_zoom", nil)
+
+	resp, err := r.app.Test(httptest.NewRequest("GET", "/api/v1/sensors/viewport?bbox=37.6,-122.5,37.8,-122.3&zoom=11", nil))
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	var collection featureCollection
+	if err := stdjson.NewDecoder(resp.Body).Decode(&collection); err != nil {
+		t.Fatalf("could not decode response: %s", err)
+	}
+
+	var found bool
+	for _, f := range collection.Features {
+		if f.ID != 3 {
+			continue
+		}
+
+		found = true
+		if f.Properties.Confidence != 50 {
+			t.Errorf("expected confidence of sensor 3 to be computed from both readings, got %d", f.Properties.Confidence)
+		}
+	}
+
+	if !found {
+		t.Errorf("expected sensor 3 not to be clustered, got %+v", collection.Features)
+	}
+}
@@ -0,0 +1,544 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	fiberutils "github.com/gofiber/fiber/v2/utils"
+	"github.com/mrflynn/air-alert/internal/database/redis"
+	"github.com/mrflynn/air-alert/internal/geo"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// wsPingInterval is how often clients are pinged to check that they are still connected.
+	wsPingInterval = 30 * time.Second
+	// wsPongTimeout is how long a client may stay silent before it is considered gone.
+	wsPongTimeout = wsPingInterval + 10*time.Second
+	// wsWriteTimeout is how long writing a single message may take. Clients that cannot keep up
+	// are disconnected.
+	wsWriteTimeout = 10 * time.Second
+	// wsMaxMessageSize is the largest message that a client may send.
+	wsMaxMessageSize = 16 * 1024
+	// wsRequestQueueSize is how many client messages may wait to be handled. Clients that send
+	// messages faster than they are handled are disconnected.
+	wsRequestQueueSize = 8
+	// wsDefaultRadius is the radius in meters of location subscriptions without one.
+	wsDefaultRadius = 2000.0
+)
+
+var (
+	errTooManyMessages = errors.New("client sent too many messages")
+
+	wsUpgrader = websocket.FastHTTPUpgrader{
+		// The API is public and doesn't use cookies, so connections from any origin are accepted
+		// like they are for the HTTP endpoints.
+		CheckOrigin: func(*fasthttp.RequestCtx) bool {
+			return true
+		},
+	}
+)
+
+// wsRequest is a message sent by a WebSocket client.
+type wsRequest struct {
+	Type      string       `json:"type"`
+	Locations []geo.Circle `json:"locations"`
+	Sensors   []int        `json:"sensors"`
+}
+
+// wsSubscriptions lists everything a client is subscribed to after a subscription change.
+type wsSubscriptions struct {
+	Type      string       `json:"type"`
+	Locations []geo.Circle `json:"locations"`
+	Sensors   []int        `json:"sensors"`
+}
+
+// wsError tells the client that its last message was rejected.
+type wsError struct {
+	Type  string `json:"type"`
+	Error string `json:"error"`
+}
+
+// wsLocationUpdate is the current AQI around a subscribed location.
+type wsLocationUpdate struct {
+	geo.Circle
+	liveAQI
+}
+
+// wsUpdate contains new data for a client's subscriptions.
+type wsUpdate struct {
+	Type      string             `json:"type"`
+	Time      time.Time          `json:"time"`
+	Locations []wsLocationUpdate `json:"locations"`
+	Sensors   []sensorProperties `json:"sensors"`
+}
+
+// wsLimits limits the number of open WebSocket connections, both in total and from a single IP
+// address. A limit of zero or less disables it.
+type wsLimits struct {
+	maxTotal int
+	maxPerIP int
+
+	mu    sync.Mutex
+	total int
+	perIP map[string]int
+}
+
+func newWSLimits(maxTotal, maxPerIP int) *wsLimits {
+	return &wsLimits{
+		maxTotal: maxTotal,
+		maxPerIP: maxPerIP,
+		perIP:    make(map[string]int),
+	}
+}
+
+// check returns an error if a new connection from the given IP address would exceed a limit.
+func (l *wsLimits) check(ip string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.checkLocked(ip)
+}
+
+func (l *wsLimits) checkLocked(ip string) error {
+	if l.maxTotal > 0 && l.total >= l.maxTotal {
+		return errorInfo{
+			err: fiber.ErrServiceUnavailable,
+			why: "too many open websocket connections",
+		}
+	}
+
+	if l.maxPerIP > 0 && l.perIP[ip] >= l.maxPerIP {
+		return errorInfo{
+			err: fiber.ErrTooManyRequests,
+			why: "too many open websocket connections from this address",
+		}
+	}
+
+	return nil
+}
+
+// acquire counts a new connection from the given IP address if no limit would be exceeded.
+func (l *wsLimits) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.checkLocked(ip) != nil {
+		return false
+	}
+
+	l.total++
+	l.perIP[ip]++
+
+	return true
+}
+
+func (l *wsLimits) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total--
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
+
+// connections returns the number of open connections.
+func (l *wsLimits) connections() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.total
+}
+
+// wsClient is a single WebSocket connection and its subscriptions. Everything except reading
+// messages happens on the goroutine that calls run, so the subscriptions need no locking.
+type wsClient struct {
+	conn   *websocket.Conn
+	hub    *aqiHub
+	sub    *liveSubscriber
+	logger *log.Entry
+
+	maxSubscriptions int
+
+	locations []*aqiStream
+	sensors   []redis.SensorLocation
+}
+
+func newWSClient(conn *websocket.Conn, hub *aqiHub, logger *log.Entry, maxSubscriptions int) *wsClient {
+	return &wsClient{
+		conn:             conn,
+		hub:              hub,
+		logger:           logger,
+		maxSubscriptions: maxSubscriptions,
+		locations:        make([]*aqiStream, 0),
+		sensors:          make([]redis.SensorLocation, 0),
+	}
+}
+
+// run handles messages from the client and sends updates until the connection is closed.
+func (c *wsClient) run() {
+	c.sub = c.hub.subscribe()
+	defer c.hub.unsubscribe(c.sub)
+
+	requests := make(chan []byte, wsRequestQueueSize)
+	readErr := make(chan error, 1)
+
+	go c.read(requests, readErr)
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	for {
+		var err error
+
+		select {
+		case <-c.hub.done():
+			c.close(websocket.CloseGoingAway, "server is shutting down")
+			return
+		case err = <-readErr:
+		case message := <-requests:
+			err = c.handle(message)
+		case <-c.sub.updates:
+			err = c.sendUpdate(c.locations, c.sensors)
+		case <-ping.C:
+			err = c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+		}
+
+		if err != nil {
+			c.logger.Debugf("closing websocket connection from %s: %s", c.conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// read passes messages from the client to run. Every message, including pongs, shows that the
+// client is still there.
+func (c *wsClient) read(requests chan<- []byte, errs chan<- error) {
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+
+	for {
+		messageType, message, err := c.conn.ReadMessage()
+		if err != nil {
+			errs <- err
+			return
+		}
+
+		c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+
+		if messageType != websocket.TextMessage {
+			c.close(websocket.CloseUnsupportedData, "only text messages are supported")
+			errs <- errors.New("client sent a binary message")
+			return
+		}
+
+		select {
+		case requests <- message:
+		default:
+			c.close(websocket.ClosePolicyViolation, "too many messages")
+			errs <- errTooManyMessages
+			return
+		}
+	}
+}
+
+// close tells the client why the connection is being closed. Unlike other messages, it can be
+// sent from any goroutine.
+func (c *wsClient) close(code int, why string) {
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, why), time.Now().Add(wsWriteTimeout))
+}
+
+func (c *wsClient) send(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (c *wsClient) sendError(why string) error {
+	return c.send(wsError{Type: "error", Error: why})
+}
+
+func (c *wsClient) handle(message []byte) error {
+	var req wsRequest
+
+	if err := json.Unmarshal(message, &req); err != nil {
+		return c.sendError("could not parse message")
+	}
+
+	switch req.Type {
+	case "subscribe":
+		return c.subscribe(req)
+	case "unsubscribe":
+		return c.unsubscribe(req)
+	default:
+		return c.sendError(fmt.Sprintf(`unknown message type "%s"`, req.Type))
+	}
+}
+
+// normalizeLocation validates a location and rounds it the same way as the HTTP endpoints do, so
+// that the same location is always subscribed to only once.
+func normalizeLocation(location geo.Circle) (geo.Circle, error) {
+	if err := geo.ValidatePoint(location.Longitude, location.Latitude); err != nil {
+		return geo.Circle{}, err
+	}
+
+	if location.Radius < 0 {
+		return geo.Circle{}, errors.New("radius must not be negative")
+	} else if location.Radius == 0 {
+		location.Radius = wsDefaultRadius
+	}
+
+	location.Latitude, _ = decimal.NewFromFloat(location.Latitude).Round(2).Float64()
+	location.Longitude, _ = decimal.NewFromFloat(location.Longitude).Round(2).Float64()
+	location.Radius, _ = decimal.NewFromFloat(location.Radius).Round(2).Float64()
+
+	return location, nil
+}
+
+func (c *wsClient) hasLocation(location geo.Circle) bool {
+	for _, stream := range c.locations {
+		if stream.area == location {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *wsClient) hasSensor(id int) bool {
+	for _, sensor := range c.sensors {
+		if sensor.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// subscribe adds new subscriptions and immediately sends their current data. Nothing is
+// subscribed to if any part of the request is invalid.
+func (c *wsClient) subscribe(req wsRequest) error {
+	locations := make([]*aqiStream, 0, len(req.Locations))
+	for _, location := range req.Locations {
+		location, err := normalizeLocation(location)
+		if err != nil {
+			return c.sendError("invalid location: " + err.Error())
+		}
+
+		duplicate := c.hasLocation(location)
+		for _, stream := range locations {
+			duplicate = duplicate || stream.area == location
+		}
+
+		if !duplicate {
+			locations = append(locations, &aqiStream{hub: c.hub, area: location})
+		}
+	}
+
+	ids := make([]int, 0, len(req.Sensors))
+	for _, id := range req.Sensors {
+		duplicate := c.hasSensor(id)
+		for _, other := range ids {
+			duplicate = duplicate || other == id
+		}
+
+		if !duplicate {
+			ids = append(ids, id)
+		}
+	}
+
+	count := len(c.locations) + len(c.sensors) + len(locations) + len(ids)
+	if c.maxSubscriptions > 0 && count > c.maxSubscriptions {
+		return c.sendError(fmt.Sprintf("at most %d subscriptions are allowed", c.maxSubscriptions))
+	}
+
+	ctx, cancel := context.WithTimeout(c.hub.ctx, liveQueryTimeout)
+	defer cancel()
+
+	sensors, err := c.hub.datastore.GetSensorLocations(ctx, ids...)
+	if err != nil {
+		c.logger.Errorf("GetSensorLocations error: %s", err)
+		return c.sendError("could not get sensor locations from database")
+	}
+
+	if len(sensors) < len(ids) {
+		return c.sendError("unknown sensor id")
+	}
+
+	c.locations = append(c.locations, locations...)
+	c.sensors = append(c.sensors, sensors...)
+	c.updateAreas()
+
+	if err := c.sendSubscriptions(); err != nil {
+		return err
+	}
+
+	return c.sendUpdate(locations, sensors)
+}
+
+func (c *wsClient) unsubscribe(req wsRequest) error {
+	for _, location := range req.Locations {
+		location, err := normalizeLocation(location)
+		if err != nil {
+			return c.sendError("invalid location: " + err.Error())
+		}
+
+		for i, stream := range c.locations {
+			if stream.area == location {
+				c.locations = append(c.locations[:i], c.locations[i+1:]...)
+				break
+			}
+		}
+	}
+
+	for _, id := range req.Sensors {
+		for i, sensor := range c.sensors {
+			if sensor.ID == id {
+				c.sensors = append(c.sensors[:i], c.sensors[i+1:]...)
+				break
+			}
+		}
+	}
+
+	c.updateAreas()
+
+	return c.sendSubscriptions()
+}
+
+// updateAreas tells the hub about the areas that the client needs updates for.
+func (c *wsClient) updateAreas() {
+	areas := make([]geo.Bounds, 0, len(c.locations)+len(c.sensors))
+
+	for _, stream := range c.locations {
+		areas = append(areas, stream.area.Bounds())
+	}
+
+	for _, sensor := range c.sensors {
+		areas = append(areas, geo.Bounds{
+			MinLatitude:  sensor.Latitude,
+			MinLongitude: sensor.Longitude,
+			MaxLatitude:  sensor.Latitude,
+			MaxLongitude: sensor.Longitude,
+		})
+	}
+
+	c.hub.setAreas(c.sub, areas)
+}
+
+func (c *wsClient) sendSubscriptions() error {
+	subscriptions := wsSubscriptions{
+		Type:      "subscriptions",
+		Locations: make([]geo.Circle, 0, len(c.locations)),
+		Sensors:   make([]int, 0, len(c.sensors)),
+	}
+
+	for _, stream := range c.locations {
+		subscriptions.Locations = append(subscriptions.Locations, stream.area)
+	}
+
+	for _, sensor := range c.sensors {
+		subscriptions.Sensors = append(subscriptions.Sensors, sensor.ID)
+	}
+
+	return c.send(subscriptions)
+}
+
+// sendUpdate sends the current data of the given subscriptions. Subscriptions without data are
+// left out, and nothing is sent if none of them have data.
+func (c *wsClient) sendUpdate(locations []*aqiStream, sensors []redis.SensorLocation) error {
+	update := wsUpdate{
+		Type:      "update",
+		Time:      time.Now().UTC(),
+		Locations: make([]wsLocationUpdate, 0, len(locations)),
+		Sensors:   make([]sensorProperties, 0, len(sensors)),
+	}
+
+	for _, stream := range locations {
+		value, err := stream.next()
+		if err != nil {
+			continue
+		}
+
+		update.Locations = append(update.Locations, wsLocationUpdate{
+			Circle:  stream.area,
+			liveAQI: value,
+		})
+	}
+
+	if len(sensors) > 0 {
+		ctx, cancel := context.WithTimeout(c.hub.ctx, liveQueryTimeout)
+		defer cancel()
+
+		history, err := getSensorHistory(ctx, c.hub.datastore, sensors, -1)
+		if err == nil {
+			for _, sensor := range sensors {
+				if properties, ok := newSensorProperties(sensor.ID, history[sensor.ID]); ok {
+					update.Sensors = append(update.Sensors, properties)
+				}
+			}
+		}
+	}
+
+	if len(update.Locations) < 1 && len(update.Sensors) < 1 {
+		return nil
+	}
+
+	return c.send(update)
+}
+
+func serveWebSocket(ctx *fiber.Ctx, hub *aqiHub, limits *wsLimits, maxSubscriptions int) error {
+	if !websocket.FastHTTPIsWebSocketUpgrade(ctx.Context()) {
+		return errorInfo{
+			err: fiber.ErrUpgradeRequired,
+			why: "expected websocket upgrade request",
+		}
+	}
+
+	ip := fiberutils.ImmutableString(getClientIP(ctx))
+	if err := limits.check(ip); err != nil {
+		return err
+	}
+
+	// The connection outlives the request context, so the logger has to be taken beforehand.
+	logger := requestLogger(ctx)
+
+	err := wsUpgrader.Upgrade(ctx.Context(), func(conn *websocket.Conn) {
+		defer conn.Close()
+
+		client := newWSClient(conn, hub, logger, maxSubscriptions)
+
+		// Other connections may have been opened since the limits were checked.
+		if !limits.acquire(ip) {
+			client.close(websocket.CloseTryAgainLater, "too many open connections")
+			return
+		}
+		defer limits.release(ip)
+
+		client.run()
+	})
+
+	if err != nil {
+		return errorInfo{
+			err: fiber.ErrBadRequest,
+			why: "invalid websocket handshake",
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,303 @@
+// +build unit
+
+package router
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/mrflynn/air-alert/internal/geo"
+)
+
+func TestWSLimits(t *testing.T) {
+	limits := newWSLimits(3, 2)
+
+	if !limits.acquire("10.0.0.1") || !limits.acquire("10.0.0.1") {
+		t.Fatalf("expected first two connections to be allowed")
+	}
+
+	err, ok := limits.check("10.0.0.1").(errorInfo)
+	if !ok || err.err != fiber.ErrTooManyRequests {
+		t.Errorf("expected per address limit to be reached, got %v", err)
+	}
+
+	if !limits.acquire("10.0.0.2") {
+		t.Fatalf("expected connection from another address to be allowed")
+	}
+
+	err, ok = limits.check("10.0.0.3").(errorInfo)
+	if !ok || err.err != fiber.ErrServiceUnavailable {
+		t.Errorf("expected total limit to be reached, got %v", err)
+	}
+
+	limits.release("10.0.0.1")
+
+	if !limits.acquire("10.0.0.1") {
+		t.Errorf("expected connection to be allowed after another one was closed")
+	}
+
+	if limits.acquire("10.0.0.3") {
+		t.Errorf("expected connection to be rejected")
+	}
+}
+
+func TestWSLimitsConcurrent(t *testing.T) {
+	limits := newWSLimits(10, 0)
+
+	var (
+		wg       sync.WaitGroup
+		acquired int32
+	)
+
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if limits.acquire("10.0.0.1") {
+				atomic.AddInt32(&acquired, 1)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if acquired != 10 || limits.connections() != 10 {
+		t.Errorf("expected 10 connections, got %d acquired and %d counted", acquired, limits.connections())
+	}
+}
+
+func TestWSLimitsDisabled(t *testing.T) {
+	limits := newWSLimits(0, 0)
+
+	for i := 0; i < 100; i++ {
+		if !limits.acquire("10.0.0.1") {
+			t.Fatalf("expected connection %d to be allowed", i)
+		}
+	}
+}
+
+func TestNormalizeLocation(t *testing.T) {
+	location, err := normalizeLocation(geo.Circle{Point: geo.Point{Latitude: 37.7749, Longitude: -122.4194}})
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	expected := geo.Circle{Point: geo.Point{Latitude: 37.77, Longitude: -122.42}, Radius: wsDefaultRadius}
+	if location != expected {
+		t.Errorf("expected %+v, got %+v", expected, location)
+	}
+
+	for _, invalid := range []geo.Circle{
+		{Point: geo.Point{Latitude: 91, Longitude: 0}},
+		{Point: geo.Point{Latitude: 0, Longitude: 181}},
+		{Point: geo.Point{Latitude: 0, Longitude: 0}, Radius: -1},
+	} {
+		if _, err := normalizeLocation(invalid); err == nil {
+			t.Errorf("%+v: expected error", invalid)
+		}
+	}
+}
+
+func TestWSLocationUpdateEncoding(t *testing.T) {
+	update := wsLocationUpdate{
+		Circle:  geo.Circle{Point: geo.Point{Latitude: 37.77, Longitude: -122.42}, Radius: 2000},
+		liveAQI: liveAQI{AQI: 42.5, Category: "Good", Trend: "steady", Sensors: 3},
+	}
+
+	body, err := json.Marshal(update)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	expected := `{"latitude":37.77,"longitude":-122.42,"radius":2000,"aqi":42.5,"category":"Good",` +
+		`"trend":"steady","sensors":3,"time":"0001-01-01T00:00:00Z"}`
+
+	if string(body) != expected {
+		t.Errorf("expected %s, got %s", expected, body)
+	}
+}
+
+func TestServeWebSocketRequiresUpgrade(t *testing.T) {
+	app := fiber.New()
+	hub := newAQIHub(nil)
+	defer hub.close()
+
+	app.Get("/ws", func(ctx *fiber.Ctx) error {
+		err := serveWebSocket(ctx, hub, newWSLimits(0, 0), 0)
+		if info, ok := err.(errorInfo); ok {
+			return ctx.Status(info.err.Code).SendString(info.why)
+		}
+
+		return err
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/ws", nil))
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if resp.StatusCode != fiber.StatusUpgradeRequired {
+		t.Errorf("expected status %d, got %d", fiber.StatusUpgradeRequired, resp.StatusCode)
+	}
+}
+
+// startWSTestServer serves WebSocket connections with a datastore that holds the sensors created by
+// createAPIV1TestStore and returns the URL of the endpoint. Middleware runs before the endpoint.
+func startWSTestServer(t *testing.T, limits *wsLimits, middleware ...fiber.Handler) string {
+	t.Helper()
+
+	hub := newAQIHub(createAPIV1TestStore(t))
+	t.Cleanup(func() { hub.close() })
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	for _, handler := range middleware {
+		app.Use(handler)
+	}
+
+	app.Get("/ws", func(ctx *fiber.Ctx) error {
+		err := serveWebSocket(ctx, hub, limits, 0)
+		if info, ok := err.(errorInfo); ok {
+			return ctx.Status(info.err.Code).SendString(info.why)
+		}
+
+		return err
+	})
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %s", err)
+	}
+
+	go app.Listener(ln)
+	t.Cleanup(func() { app.Shutdown() })
+
+	return "ws://" + ln.Addr().String() + "/ws"
+}
+
+func readWSMessage(t *testing.T, conn *websocket.Conn, v interface{}) {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	if err := conn.ReadJSON(v); err != nil {
+		t.Fatalf("could not read message: %s", err)
+	}
+}
+
+func TestWebSocketSubscribe(t *testing.T) {
+	url := startWSTestServer(t, newWSLimits(0, 0))
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("could not connect: %s", err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteJSON(wsRequest{Type: "unknown"}); err != nil {
+		t.Fatalf("could not send message: %s", err)
+	}
+
+	var wsErr wsError
+	if readWSMessage(t, conn, &wsErr); wsErr.Type != "error" || wsErr.Error != `unknown message type "unknown"` {
+		t.Errorf("got unexpected error message %+v", wsErr)
+	}
+
+	if err := conn.WriteJSON(wsRequest{Type: "subscribe", Sensors: []int{1}}); err != nil {
+		t.Fatalf("could not send message: %s", err)
+	}
+
+	var subscriptions wsSubscriptions
+	if readWSMessage(t, conn, &subscriptions); len(subscriptions.Sensors) != 1 || subscriptions.Sensors[0] != 1 {
+		t.Errorf("got unexpected subscriptions %+v", subscriptions)
+	}
+
+	var update wsUpdate
+	if readWSMessage(t, conn, &update); len(update.Sensors) != 1 || update.Sensors[0].PM25 != 12.5 {
+		t.Errorf("got unexpected update %+v", update)
+	}
+
+	if err := conn.WriteMessage(websocket.BinaryMessage, []byte{1}); err != nil {
+		t.Fatalf("could not send message: %s", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseUnsupportedData) {
+		t.Errorf("expected connection to be closed for binary message, got %v", err)
+	}
+}
+
+func TestWebSocketLimits(t *testing.T) {
+	limits := newWSLimits(0, 1)
+	url := startWSTestServer(t, limits)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("could not connect: %s", err)
+	}
+
+	// The connection is only counted once the handler runs.
+	for i := 0; limits.connections() == 0 && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || resp == nil || resp.StatusCode != fiber.StatusTooManyRequests {
+		t.Errorf("expected second connection to be rejected, got %v", err)
+	}
+
+	conn.Close()
+
+	for i := 0; limits.connections() > 0 && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := limits.connections(); n != 0 {
+		t.Errorf("expected connection to be released, got %d open connections", n)
+	}
+}
+
+func TestWebSocketLimitsBehindProxy(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"127.0.0.1"})
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	limits := newWSLimits(0, 1)
+	url := startWSTestServer(t, limits, clientIP(fiber.HeaderXForwardedFor, proxies))
+
+	dial := func(client string) (*websocket.Conn, *http.Response, error) {
+		return websocket.DefaultDialer.Dial(url, http.Header{fiber.HeaderXForwardedFor: {client}})
+	}
+
+	first, _, err := dial("203.0.113.1")
+	if err != nil {
+		t.Fatalf("could not connect: %s", err)
+	}
+	defer first.Close()
+
+	for i := 0; limits.connections() == 0 && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Clients behind the same proxy are limited separately.
+	second, _, err := dial("203.0.113.2")
+	if err != nil {
+		t.Fatalf("expected connection from another client to be accepted, got %s", err)
+	}
+	defer second.Close()
+
+	_, resp, err := dial("203.0.113.1")
+	if err == nil || resp == nil || resp.StatusCode != fiber.StatusTooManyRequests {
+		t.Errorf("expected second connection from client to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/mrflynn/air-alert/internal/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// findCycle returns the names of the tasks in a dependency cycle, starting and ending with the same
+// task, or nil if there is no cycle. Dependencies on tasks that aren't in the list are ignored.
+func findCycle(tasks []Task) []string {
+	dependencies := make(map[string][]string, len(tasks))
+	for _, t := range tasks {
+		dependencies[t.GetName()] = t.GetDependencies()
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make(map[string]int, len(tasks))
+	var path []string
+
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		switch state[name] {
+		case visiting:
+			for i, n := range path {
+				if n == name {
+					return append(append([]string{}, path[i:]...), name)
+				}
+			}
+		case visited:
+			return nil
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+
+		for _, dependency := range dependencies[name] {
+			if _, ok := dependencies[dependency]; !ok {
+				continue
+			}
+
+			if cycle := visit(dependency); cycle != nil {
+				return cycle
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[name] = visited
+
+		return nil
+	}
+
+	for _, t := range tasks {
+		if cycle := visit(t.GetName()); cycle != nil {
+			return cycle
+		}
+	}
+
+	return nil
+}
+
+// checkDependencies returns an error if a task depends on a task that doesn't exist.
+func (r *Runner) checkDependencies() error {
+	for _, t := range r.tasks {
+		for _, dependency := range t.GetDependencies() {
+			if _, err := r.getTask(dependency); err != nil {
+				return fmt.Errorf(`task "%s" depends on unknown task "%s"`, t.GetName(), dependency)
+			}
+		}
+	}
+
+	return nil
+}
+
+// startupResult is the outcome of a task at startup. done is closed once err is set.
+type startupResult struct {
+	done chan struct{}
+	err  error
+}
+
+// runStartupTasks runs every task once its dependencies have finished, so that tasks that don't
+// depend on each other run at the same time. Tasks whose dependencies failed are skipped. With
+// the fail fast startup policy, the first failure cancels every other task and is returned.
+// Tasks that skip startup or are paused count as finished straight away. Every dependency must
+// have been added to the runner.
+func (r *Runner) runStartupTasks(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(map[string]*startupResult, len(r.tasks))
+	for _, t := range r.tasks {
+		results[t.GetName()] = &startupResult{done: make(chan struct{})}
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+
+	for _, t := range r.tasks {
+		wg.Add(1)
+
+		go func(t Task) {
+			defer wg.Done()
+
+			result := results[t.GetName()]
+			defer close(result.done)
+
+			logger := log.WithField(logging.FieldTask, t.GetName())
+
+			for _, dependency := range t.GetDependencies() {
+				<-results[dependency].done
+
+				if results[dependency].err != nil {
+					result.err = fmt.Errorf(`dependency "%s" failed`, dependency)
+					logger.Warnf("skipping startup run because %s", result.err)
+
+					return
+				}
+			}
+
+			if ctx.Err() != nil {
+				result.err = ctx.Err()
+				return
+			}
+
+			if t.SkipStartup() {
+				return
+			}
+
+			if r.isPaused(ctx, t.GetName()) {
+				logger.Info("task is paused, skipping startup run")
+				return
+			}
+
+			if result.err = r.runTask(ctx, t, true, TriggerStartup); result.err == nil {
+				return
+			}
+
+			if r.startupPolicy != StartupContinue {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s: %s", t.GetName(), result.err)
+				}
+				mu.Unlock()
+
+				cancel()
+				return
+			}
+
+			logger.Errorf("task failed during startup: %s", result.err)
+		}(t)
+	}
+
+	wg.Wait()
+
+	return firstErr
+}
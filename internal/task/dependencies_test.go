@@ -0,0 +1,134 @@
+// +build unit
+
+package task
+
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAddTaskCycle(t *testing.T) {
+	simpleRunner := Runner{scheduler: newScheduler(tz)}
+
+	first, _ := failingTask("first", 0, Policy{})
+	first.DependsOn = []string{"third"}
+	second, _ := failingTask("second", 0, Policy{})
+	second.DependsOn = []string{"first"}
+	third, _ := failingTask("third", 0, Policy{})
+	third.DependsOn = []string{"second"}
+	self, _ := failingTask("self", 0, Policy{})
+	self.DependsOn = []string{"self"}
+
+	for _, task := range []MinuteTask{first, second} {
+		if err := simpleRunner.AddTask(task); err != nil {
+			t.Fatalf("%s: got unexpected error: %s", task.Name, err)
+		}
+	}
+
+	for _, task := range []MinuteTask{third, self} {
+		if err := simpleRunner.AddTask(task); err == nil {
+			t.Errorf("%s: expected error, got nil", task.Name)
+		}
+	}
+
+	if count := len(simpleRunner.tasks); count != 2 {
+		t.Errorf("expected 2 tasks, got %d tasks", count)
+	}
+}
+
+func TestFindCycle(t *testing.T) {
+	first, _ := failingTask("first", 0, Policy{})
+	second, _ := failingTask("second", 0, Policy{})
+	second.DependsOn = []string{"first", "missing"}
+	third, _ := failingTask("third", 0, Policy{})
+	third.DependsOn = []string{"first", "second"}
+
+	if cycle := findCycle([]Task{first, second, third}); cycle != nil {
+		t.Errorf("expected no cycle, got %v", cycle)
+	}
+
+	first.DependsOn = []string{"third"}
+
+	expected := []string{"first", "third", "first"}
+	if cycle := findCycle([]Task{first, second, third}); !cmp.Equal(cycle, expected) {
+		t.Errorf("expected cycle %v, got %v", expected, cycle)
+	}
+}
+
+func TestStartUnknownDependency(t *testing.T) {
+	simpleRunner := Runner{scheduler: newScheduler(tz)}
+
+	task, runs := failingTask("dependant", 0, Policy{})
+	task.DependsOn = []string{"missing"}
+	simpleRunner.AddTask(task)
+
+	if err := simpleRunner.Start(); err == nil {
+		simpleRunner.Stop()
+		t.Error("expected error, got nil")
+	}
+
+	if *runs != 0 {
+		t.Errorf("expected task not to run, got %d runs", *runs)
+	}
+}
+
+func TestRunStartupTasksConcurrently(t *testing.T) {
+	simpleRunner := Runner{scheduler: newScheduler(tz)}
+
+	// Both tasks only finish once the other one has started.
+	var started sync.WaitGroup
+	started.Add(2)
+
+	for _, name := range []string{"first", "second"} {
+		simpleRunner.AddTask(MinuteTask{
+			Name: name,
+			Rate: 5,
+			TTL:  time.Second,
+			RunFunc: func(context.Context) error {
+				started.Done()
+				started.Wait()
+				return nil
+			},
+		})
+	}
+
+	if err := simpleRunner.runStartupTasks(context.Background()); err != nil {
+		t.Errorf("got unexpected error: %s", err)
+	}
+}
+
+func TestRunStartupTasksSkipsDependants(t *testing.T) {
+	simpleRunner := Runner{
+		scheduler:     newScheduler(tz),
+		startupPolicy: StartupContinue,
+	}
+
+	failed, _ := failingTask("failed", math.MaxInt32, Policy{})
+	dependant, dependantRuns := failingTask("dependant", 0, Policy{})
+	dependant.DependsOn = []string{"failed"}
+	transitive, transitiveRuns := failingTask("transitive", 0, Policy{})
+	transitive.DependsOn = []string{"dependant"}
+	independent, independentRuns := failingTask("independent", 0, Policy{})
+
+	simpleRunner.AddTask(transitive)
+	simpleRunner.AddTask(dependant)
+	simpleRunner.AddTask(failed)
+	simpleRunner.AddTask(independent)
+
+	if err := simpleRunner.runStartupTasks(context.Background()); err != nil {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	if *dependantRuns != 0 || *transitiveRuns != 0 {
+		t.Errorf("expected dependants of the failed task not to run, got %d and %d runs", *dependantRuns, *transitiveRuns)
+	}
+
+	if *independentRuns != 1 {
+		t.Errorf("expected 1 run of the independent task, got %d", *independentRuns)
+	}
+}
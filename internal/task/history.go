@@ -0,0 +1,145 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mrflynn/air-alert/internal/logging"
+	"github.com/mrflynn/air-alert/internal/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// storeTimeout is how long storing a run in the history or checking if a task is paused may take.
+const storeTimeout = 5 * time.Second
+
+// Reasons that a task is run.
+const (
+	TriggerStartup   = "startup"
+	TriggerScheduled = "scheduled"
+	TriggerManual    = "manual"
+)
+
+// Run is a record of a single run of a task, including all of its retries.
+type Run struct {
+	Task     string    `json:"task"`
+	Trigger  string    `json:"trigger"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration float64   `json:"duration_seconds"`
+	Attempts int       `json:"attempts"`
+	// Outcome is one of the task outcomes in the metrics package.
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+// newRun records the outcome of a run that started at start.
+func newRun(t Task, trigger string, start time.Time, attempts int, err error) Run {
+	end := time.Now()
+
+	run := Run{
+		Task:     t.GetName(),
+		Trigger:  trigger,
+		Start:    start,
+		End:      end,
+		Duration: end.Sub(start).Seconds(),
+		Attempts: attempts,
+		Outcome:  metrics.TaskOutcome(err),
+	}
+
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	return run
+}
+
+// History stores the most recent runs of every task.
+type History interface {
+	// AddRun stores a run.
+	AddRun(context.Context, Run) error
+	// Runs returns up to count of the most recent runs of the task, newest first.
+	Runs(ctx context.Context, name string, count int64) ([]Run, error)
+}
+
+// Status is the state of a task.
+type Status struct {
+	Name      string   `json:"name"`
+	Schedule  string   `json:"schedule"`
+	DependsOn []string `json:"depends_on,omitempty"`
+	Paused    bool     `json:"paused"`
+	// SuspendedUntil is set while the task is suspended after too many failed runs on this server.
+	SuspendedUntil *time.Time `json:"suspended_until,omitempty"`
+	LastRun        *Run       `json:"last_run,omitempty"`
+}
+
+// UseHistory makes the runner store every run of its tasks in h.
+func (r *Runner) UseHistory(h History) {
+	r.history = h
+}
+
+// recordRun stores the run in the history. Failures are only logged so that they don't fail the
+// task.
+func (r *Runner) recordRun(run Run) {
+	if r.history == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	if err := r.history.AddRun(ctx, run); err != nil {
+		log.WithField(logging.FieldTask, run.Task).Warnf("could not store task run: %s", err)
+	}
+}
+
+// History returns up to count of the most recent runs of the task with the given name, newest
+// first. It is empty if the runner doesn't have a history.
+func (r *Runner) History(ctx context.Context, name string, count int64) ([]Run, error) {
+	if _, err := r.getTask(name); err != nil {
+		return nil, err
+	}
+
+	if r.history == nil {
+		return []Run{}, nil
+	}
+
+	return r.history.Runs(ctx, name, count)
+}
+
+// Status returns the state of every task in insertion order. The last run of a task is taken from
+// the history, so it includes runs on other servers.
+func (r *Runner) Status(ctx context.Context) ([]Status, error) {
+	statuses := make([]Status, 0, len(r.tasks))
+	now := time.Now()
+
+	for _, t := range r.tasks {
+		status := Status{
+			Name:      t.GetName(),
+			Schedule:  fmt.Sprint(t.GetRate()),
+			DependsOn: t.GetDependencies(),
+		}
+
+		paused, err := r.pauseStore().IsPaused(ctx, t.GetName())
+		if err != nil {
+			return nil, err
+		}
+
+		status.Paused = paused
+
+		if ok, until := r.breakerFor(t.GetName()).allow(now); !ok {
+			status.SuspendedUntil = &until
+		}
+
+		runs, err := r.History(ctx, t.GetName(), 1)
+		if err != nil {
+			return nil, err
+		} else if len(runs) > 0 {
+			status.LastRun = &runs[0]
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
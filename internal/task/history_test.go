@@ -0,0 +1,111 @@
+// +build unit
+
+package task
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryHistory keeps runs in memory, newest first.
+type memoryHistory struct {
+	mu   sync.Mutex
+	runs []Run
+}
+
+func (m *memoryHistory) AddRun(ctx context.Context, run Run) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.runs = append([]Run{run}, m.runs...)
+	return nil
+}
+
+func (m *memoryHistory) Runs(ctx context.Context, name string, count int64) ([]Run, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	runs := []Run{}
+	for _, run := range m.runs {
+		if run.Task == name && int64(len(runs)) < count {
+			runs = append(runs, run)
+		}
+	}
+
+	return runs, nil
+}
+
+func TestRecordRun(t *testing.T) {
+	history := &memoryHistory{}
+	simpleRunner := Runner{scheduler: newScheduler(tz)}
+	simpleRunner.UseHistory(history)
+
+	task, _ := failingTask("flaky", 1, Policy{Retries: 1, Backoff: time.Millisecond})
+	if err := simpleRunner.AddTask(task); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if err := simpleRunner.runTask(context.Background(), task, true, TriggerScheduled); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	runs, err := simpleRunner.History(context.Background(), "flaky", 10)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if len(runs) != 1 {
+		t.Fatalf("expected 1 run, got %d", len(runs))
+	}
+
+	run := runs[0]
+	if run.Trigger != TriggerScheduled || run.Attempts != 2 || run.Outcome != "success" || run.Error != "" {
+		t.Errorf("expected successful scheduled run with 2 attempts, got %+v", run)
+	}
+
+	if run.End.Before(run.Start) {
+		t.Errorf("expected run to end after it started, got %+v", run)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	history := &memoryHistory{}
+	simpleRunner := Runner{scheduler: newScheduler(tz)}
+	simpleRunner.UseHistory(history)
+
+	failed, _ := failingTask("failed", 1, Policy{BreakerThreshold: 1, BreakerCooldown: time.Hour})
+	idle, _ := failingTask("idle", 0, Policy{})
+	simpleRunner.AddTask(failed)
+	simpleRunner.AddTask(idle)
+
+	simpleRunner.runTask(context.Background(), failed, true, TriggerManual)
+
+	statuses, err := simpleRunner.Status(context.Background())
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if len(statuses) != 2 {
+		t.Fatalf("expected 2 statuses, got %d", len(statuses))
+	}
+
+	if s := statuses[0]; s.Name != "failed" || s.Schedule != "5" || s.SuspendedUntil == nil ||
+		s.LastRun == nil || s.LastRun.Error != "failed" {
+		t.Errorf("expected failed task to be suspended with its last run, got %+v", s)
+	}
+
+	if s := statuses[1]; s.Name != "idle" || s.SuspendedUntil != nil || s.LastRun != nil {
+		t.Errorf("expected idle task without runs, got %+v", s)
+	}
+}
+
+func TestHistoryTaskNotFound(t *testing.T) {
+	simpleRunner := Runner{scheduler: newScheduler(tz)}
+
+	if _, err := simpleRunner.History(context.Background(), "missing", 1); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("expected ErrTaskNotFound, got %v", err)
+	}
+}
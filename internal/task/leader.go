@@ -0,0 +1,167 @@
+package task
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mrflynn/air-alert/internal/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// Lease is a lock that is held by at most one runner at a time. Only the runner that holds it runs
+// scheduled tasks, and another runner takes over once it expires.
+type Lease interface {
+	// Acquire tries to take the lease and returns its fencing token, or 0 if the lease is held by
+	// someone else.
+	Acquire(context.Context) (int64, error)
+	// Renew extends the lease and returns false if it is no longer held.
+	Renew(context.Context) (bool, error)
+	// Release gives up the lease.
+	Release(context.Context) error
+}
+
+type fencingTokenKey struct{}
+
+// FencingToken returns the fencing token of the lease that the task in ctx is run under. There is
+// no token if the runner doesn't use a lease.
+func FencingToken(ctx context.Context) (int64, bool) {
+	token, ok := ctx.Value(fencingTokenKey{}).(int64)
+	return token, ok
+}
+
+// elector keeps trying to acquire a lease and renews it while it is held.
+type elector struct {
+	lease Lease
+	ttl   time.Duration
+
+	mu         sync.Mutex
+	token      int64
+	validUntil time.Time
+
+	started  bool
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newElector(lease Lease, ttl time.Duration) *elector {
+	return &elector{
+		lease: lease,
+		ttl:   ttl,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// leader returns the fencing token of the lease if it is held. The lease is treated as lost once
+// it hasn't been renewed for its TTL, even if Redis can't be reached to find out.
+func (e *elector) leader() (int64, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.token == 0 || !time.Now().Before(e.validUntil) {
+		return 0, false
+	}
+
+	return e.token, true
+}
+
+func (e *elector) set(token int64, validUntil time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.token = token
+	e.validUntil = validUntil
+
+	if token != 0 {
+		metrics.TaskLeader.Set(1)
+	} else {
+		metrics.TaskLeader.Set(0)
+	}
+}
+
+// refresh renews the lease if it is held and tries to acquire it otherwise. It must only be called
+// from one goroutine at a time.
+func (e *elector) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), e.ttl/3)
+	defer cancel()
+
+	// The lease expires in Redis one TTL after the request is sent, so it is only known to be held
+	// until then.
+	start := time.Now()
+
+	if e.token != 0 {
+		renewed, err := e.lease.Renew(ctx)
+		if err != nil {
+			log.Warnf("could not renew task leader lease: %s", err)
+			return
+		} else if renewed {
+			e.set(e.token, start.Add(e.ttl))
+			return
+		}
+
+		log.Warn("lost task leader lease")
+		e.set(0, time.Time{})
+	}
+
+	token, err := e.lease.Acquire(ctx)
+	if err != nil {
+		log.Warnf("could not acquire task leader lease: %s", err)
+		return
+	} else if token == 0 {
+		return
+	}
+
+	log.WithField("fencing_token", token).Info("acquired task leader lease")
+	e.set(token, start.Add(e.ttl))
+}
+
+// start refreshes the lease in the background.
+func (e *elector) start() {
+	e.started = true
+	go e.run()
+}
+
+// run refreshes the lease three times per TTL until the elector is stopped, so that it is renewed
+// before it expires even if a renewal fails.
+func (e *elector) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.refresh()
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// shutdown stops refreshing the lease and releases it, so that another runner can take over
+// straight away.
+func (e *elector) shutdown() {
+	e.stopOnce.Do(func() {
+		close(e.stop)
+	})
+
+	if e.started {
+		<-e.done
+	}
+
+	if e.token == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.ttl/3)
+	defer cancel()
+
+	if err := e.lease.Release(ctx); err != nil {
+		log.Warnf("could not release task leader lease: %s", err)
+	}
+
+	e.set(0, time.Time{})
+}
@@ -0,0 +1,312 @@
+// +build unit
+
+package task
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLease is a lease shared by several runners, like the one in Redis.
+type fakeLease struct {
+	mu     sync.Mutex
+	holder *string
+	tokens *int64
+
+	name     string
+	renewErr error
+}
+
+func newFakeLeases(names ...string) []*fakeLease {
+	var (
+		holder string
+		tokens int64
+	)
+
+	leases := make([]*fakeLease, 0, len(names))
+	for _, name := range names {
+		leases = append(leases, &fakeLease{holder: &holder, tokens: &tokens, name: name})
+	}
+
+	return leases
+}
+
+func (f *fakeLease) Acquire(context.Context) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if *f.holder != "" && *f.holder != f.name {
+		return 0, nil
+	}
+
+	*f.holder = f.name
+	*f.tokens++
+
+	return *f.tokens, nil
+}
+
+func (f *fakeLease) Renew(context.Context) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.renewErr != nil {
+		return false, f.renewErr
+	}
+
+	return *f.holder == f.name, nil
+}
+
+func (f *fakeLease) Release(context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if *f.holder == f.name {
+		*f.holder = ""
+	}
+
+	return nil
+}
+
+// expire makes the lease expire as if its holder had died.
+func (f *fakeLease) expire() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	*f.holder = ""
+}
+
+func TestElectorFailover(t *testing.T) {
+	leases := newFakeLeases("first", "second")
+	first, second := newElector(leases[0], time.Minute), newElector(leases[1], time.Minute)
+
+	first.refresh()
+	second.refresh()
+
+	if token, ok := first.leader(); !ok || token != 1 {
+		t.Fatalf("expected first to be leader with token 1, got %d, %t", token, ok)
+	}
+
+	if _, ok := second.leader(); ok {
+		t.Fatal("expected second not to be leader")
+	}
+
+	// The first elector dies without releasing the lease.
+	leases[0].expire()
+	second.refresh()
+
+	if token, ok := second.leader(); !ok || token != 2 {
+		t.Fatalf("expected second to take over with token 2, got %d, %t", token, ok)
+	}
+
+	first.refresh()
+	if _, ok := first.leader(); ok {
+		t.Error("expected first to notice that it lost the lease")
+	}
+}
+
+func TestElectorRenewError(t *testing.T) {
+	lease := newFakeLeases("leader")[0]
+	e := newElector(lease, time.Minute)
+
+	e.refresh()
+	lease.renewErr = errors.New("unavailable")
+	e.refresh()
+
+	if _, ok := e.leader(); !ok {
+		t.Error("expected leader to keep the lease until it expires")
+	}
+
+	e.set(e.token, time.Now())
+	if _, ok := e.leader(); ok {
+		t.Error("expected leader to give up the lease once it expired")
+	}
+}
+
+func TestElectorShutdown(t *testing.T) {
+	leases := newFakeLeases("first", "second")
+	first, second := newElector(leases[0], time.Minute), newElector(leases[1], time.Minute)
+
+	first.refresh()
+	first.start()
+	first.shutdown()
+
+	if _, ok := first.leader(); ok {
+		t.Error("expected first not to be leader after shutdown")
+	}
+
+	second.refresh()
+	if _, ok := second.leader(); !ok {
+		t.Error("expected second to take over straight away")
+	}
+}
+
+func TestRunnerFollowerSkipsTasks(t *testing.T) {
+	leases := newFakeLeases("leader", "follower")
+	leader := newElector(leases[0], time.Minute)
+	leader.refresh()
+
+	follower := Runner{scheduler: newScheduler(tz)}
+	follower.UseLease(leases[1], time.Minute)
+
+	ran := make(chan int64, 1)
+	task := MinuteTask{
+		Name: "leader-only",
+		Rate: 5,
+		TTL:  time.Second,
+		RunFunc: func(ctx context.Context) error {
+			token, _ := FencingToken(ctx)
+			ran <- token
+			return nil
+		},
+	}
+
+	if err := follower.AddTask(task); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if err := follower.Start(); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	follower.runScheduled(task)
+
+	select {
+	case <-ran:
+		t.Fatal("expected follower not to run the task")
+	default:
+	}
+
+	// The leader goes away and the follower takes over.
+	leader.shutdown()
+	follower.elector.refresh()
+	follower.runScheduled(task)
+
+	if token := <-ran; token != 2 {
+		t.Errorf("expected task to run with fencing token 2, got %d", token)
+	}
+
+	follower.Stop()
+}
+
+func TestRunnerRenewsLeaseDuringStartup(t *testing.T) {
+	runner := Runner{scheduler: newScheduler(tz)}
+	runner.UseLease(newFakeLeases("leader")[0], 60*time.Millisecond)
+
+	leading := make(chan bool, 1)
+	task := MinuteTask{
+		Name: "slow-startup",
+		Rate: 5,
+		TTL:  time.Second,
+		RunFunc: func(ctx context.Context) error {
+			// The startup run outlasts the TTL of the lease.
+			time.Sleep(200 * time.Millisecond)
+			leading <- runner.leading(ctx)
+			return nil
+		},
+	}
+
+	if err := runner.AddTask(task); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if err := runner.Start(); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	defer runner.Stop()
+
+	if !<-leading {
+		t.Error("expected lease to be renewed while startup tasks run")
+	}
+}
+
+func TestRunnerLeading(t *testing.T) {
+	leases := newFakeLeases("first", "second")
+
+	runner := Runner{scheduler: newScheduler(tz)}
+	runner.UseLease(leases[0], time.Minute)
+	runner.elector.refresh()
+
+	ctx, ok := runner.leaderContext()
+	if !ok || !runner.leading(ctx) {
+		t.Fatal("expected runner to lead with the token of its context")
+	}
+
+	if !runner.leading(context.Background()) {
+		t.Error("expected runs without a fencing token not to depend on the lease")
+	}
+
+	// Another runner takes over and this one acquires the lease again afterwards.
+	leases[0].expire()
+	other := newElector(leases[1], time.Minute)
+	other.refresh()
+	other.shutdown()
+	runner.elector.refresh()
+
+	if runner.leading(ctx) {
+		t.Error("expected runner not to lead with the fencing token of an earlier lease")
+	}
+}
+
+func TestTriggerPassedToLeader(t *testing.T) {
+	leases := newFakeLeases("leader", "follower")
+	queue := &memoryTriggerQueue{}
+
+	ran := make(chan int64, 2)
+	task := MinuteTask{
+		Name: "triggered",
+		Rate: 5,
+		TTL:  time.Second,
+		RunFunc: func(ctx context.Context) error {
+			token, _ := FencingToken(ctx)
+			ran <- token
+			return nil
+		},
+	}
+
+	runners := make([]*Runner, 0, len(leases))
+	for _, lease := range leases {
+		runner := &Runner{scheduler: newScheduler(tz)}
+		runner.UseLease(lease, time.Minute)
+		runner.UseTriggerQueue(queue)
+
+		if err := runner.AddTask(task); err != nil {
+			t.Fatalf("got unexpected error: %s", err)
+		}
+
+		runner.elector.refresh()
+		runners = append(runners, runner)
+	}
+
+	leader, follower := runners[0], runners[1]
+
+	if err := follower.Trigger(context.Background(), "triggered"); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	// The follower only queues the task, and only the leader runs it.
+	follower.runTriggered()
+
+	select {
+	case <-ran:
+		t.Fatal("expected follower not to run the task")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	leader.runTriggered()
+
+	select {
+	case token := <-ran:
+		if token != 1 {
+			t.Errorf("expected task to run with fencing token 1, got %d", token)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected leader to run the triggered task")
+	}
+
+	if err := leader.Trigger(context.Background(), "missing"); err != ErrTaskNotFound {
+		t.Errorf("expected ErrTaskNotFound, got %v", err)
+	}
+}
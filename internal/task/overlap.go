@@ -0,0 +1,131 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Overlap policies decide what happens when a task is due while its previous run hasn't finished.
+const (
+	// OverlapSkip skips the new run.
+	OverlapSkip = "skip"
+	// OverlapQueue starts the new run once the previous one finishes. At most one run is queued,
+	// and any more runs are skipped.
+	OverlapQueue = "queue"
+	// OverlapCancel cancels the previous run and starts the new run once it has stopped.
+	OverlapCancel = "cancel_previous"
+)
+
+var (
+	// ErrTaskRunning is returned when a task can't be run because it is already running.
+	ErrTaskRunning = errors.New("task is already running")
+
+	// errRunSkipped is returned by runs that were skipped because of the overlap policy.
+	errRunSkipped = errors.New("run skipped because the previous run is still running")
+)
+
+// validateOverlap returns an error if the overlap policy is unknown.
+func validateOverlap(overlap string) error {
+	switch overlap {
+	case "", OverlapSkip, OverlapQueue, OverlapCancel:
+		return nil
+	default:
+		return fmt.Errorf(`unknown overlap policy "%s"`, overlap)
+	}
+}
+
+// slot makes sure that only one run of a task happens at a time.
+type slot struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	// done is closed when the current run finishes. It is nil if the task isn't running.
+	done   chan struct{}
+	queued bool
+}
+
+// running returns whether a run holds the slot.
+func (s *slot) running() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.done != nil
+}
+
+// acquire takes the slot according to the overlap policy, waiting for or cancelling the previous
+// run if necessary. It returns a context that is cancelled if a later run cancels this one, and a
+// function that releases the slot. It returns false if the run should be skipped.
+func (s *slot) acquire(ctx context.Context, overlap string) (context.Context, func(), bool) {
+	s.mu.Lock()
+
+	for s.done != nil {
+		done := s.done
+
+		switch overlap {
+		case OverlapQueue:
+			if s.queued {
+				s.mu.Unlock()
+				return nil, nil, false
+			}
+
+			s.queued = true
+			s.mu.Unlock()
+
+			if !waitFor(ctx, done) {
+				s.mu.Lock()
+				s.queued = false
+				s.mu.Unlock()
+
+				return nil, nil, false
+			}
+
+			s.mu.Lock()
+			s.queued = false
+		case OverlapCancel:
+			s.cancel()
+			s.mu.Unlock()
+
+			if !waitFor(ctx, done) {
+				return nil, nil, false
+			}
+
+			s.mu.Lock()
+		default:
+			s.mu.Unlock()
+			return nil, nil, false
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	s.cancel, s.done = cancel, done
+	s.mu.Unlock()
+
+	release := func() {
+		cancel()
+
+		s.mu.Lock()
+		s.cancel, s.done = nil, nil
+		s.mu.Unlock()
+
+		close(done)
+	}
+
+	return ctx, release, true
+}
+
+// waitFor waits until done is closed or ctx is done and returns false in the latter case.
+func waitFor(ctx context.Context, done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// slotFor returns the slot of the task.
+func (r *Runner) slotFor(name string) *slot {
+	return &r.stateOf(name).slot
+}
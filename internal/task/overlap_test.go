@@ -0,0 +1,153 @@
+// +build unit
+
+package task
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// blockingTask returns a task that runs until it is cancelled or release is closed, and reports
+// every run that starts on started.
+func blockingTask(name, overlap string) (MinuteTask, chan struct{}, chan struct{}) {
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+
+	return MinuteTask{
+		Name:   name,
+		Rate:   5,
+		TTL:    time.Minute,
+		Policy: Policy{Overlap: overlap},
+		RunFunc: func(ctx context.Context) error {
+			started <- struct{}{}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-release:
+				return nil
+			}
+		},
+	}, started, release
+}
+
+func waitStarted(t *testing.T, started chan struct{}) {
+	t.Helper()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected run to start")
+	}
+}
+
+func TestOverlapSkip(t *testing.T) {
+	simpleRunner := Runner{scheduler: newScheduler(tz)}
+	task, started, release := blockingTask("skip", OverlapSkip)
+	simpleRunner.AddTask(task)
+
+	first := make(chan error, 1)
+	go func() {
+		first <- simpleRunner.runTask(context.Background(), task, true, TriggerScheduled)
+	}()
+
+	waitStarted(t, started)
+
+	if err := simpleRunner.runTask(context.Background(), task, true, TriggerScheduled); err != errRunSkipped {
+		t.Errorf("expected overlapping run to be skipped, got %v", err)
+	}
+
+	if err := simpleRunner.Trigger(context.Background(), "skip"); err != ErrTaskRunning {
+		t.Errorf("expected ErrTaskRunning, got %v", err)
+	}
+
+	close(release)
+	if err := <-first; err != nil {
+		t.Errorf("got unexpected error: %s", err)
+	}
+}
+
+func TestOverlapQueue(t *testing.T) {
+	simpleRunner := Runner{scheduler: newScheduler(tz)}
+	task, started, release := blockingTask("queue", OverlapQueue)
+	simpleRunner.AddTask(task)
+
+	results := make(chan error, 2)
+	go func() {
+		results <- simpleRunner.runTask(context.Background(), task, true, TriggerScheduled)
+	}()
+
+	waitStarted(t, started)
+
+	go func() {
+		results <- simpleRunner.runTask(context.Background(), task, true, TriggerScheduled)
+	}()
+
+	// Wait for the second run to be queued, after which any more runs are skipped.
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		s := simpleRunner.slotFor("queue")
+		s.mu.Lock()
+		queued := s.queued
+		s.mu.Unlock()
+
+		if queued {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("expected second run to be queued")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := simpleRunner.runTask(context.Background(), task, true, TriggerScheduled); err != errRunSkipped {
+		t.Errorf("expected third run to be skipped, got %v", err)
+	}
+
+	close(release)
+	waitStarted(t, started)
+
+	for i := 0; i < 2; i++ {
+		if err := <-results; err != nil {
+			t.Errorf("got unexpected error: %s", err)
+		}
+	}
+}
+
+func TestOverlapCancel(t *testing.T) {
+	simpleRunner := Runner{scheduler: newScheduler(tz)}
+	task, started, release := blockingTask("cancel", OverlapCancel)
+	simpleRunner.AddTask(task)
+
+	first := make(chan error, 1)
+	go func() {
+		first <- simpleRunner.runTask(context.Background(), task, true, TriggerScheduled)
+	}()
+
+	waitStarted(t, started)
+
+	second := make(chan error, 1)
+	go func() {
+		second <- simpleRunner.runTask(context.Background(), task, true, TriggerScheduled)
+	}()
+
+	if err := <-first; err != context.Canceled {
+		t.Errorf("expected first run to be cancelled, got %v", err)
+	}
+
+	waitStarted(t, started)
+	close(release)
+
+	if err := <-second; err != nil {
+		t.Errorf("got unexpected error: %s", err)
+	}
+}
+
+func TestValidateOverlap(t *testing.T) {
+	simpleRunner := Runner{scheduler: newScheduler(tz)}
+	task, _, _ := blockingTask("invalid", "sometimes")
+
+	if err := simpleRunner.AddTask(task); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
@@ -0,0 +1,110 @@
+package task
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mrflynn/air-alert/internal/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// PauseStore keeps track of which tasks are paused.
+type PauseStore interface {
+	Pause(ctx context.Context, name string) error
+	Resume(ctx context.Context, name string) error
+	IsPaused(ctx context.Context, name string) (bool, error)
+}
+
+// memoryPauseStore keeps paused tasks in memory, so they are only paused on this server.
+type memoryPauseStore struct {
+	mu     sync.Mutex
+	paused map[string]bool
+}
+
+func (m *memoryPauseStore) Pause(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.paused == nil {
+		m.paused = make(map[string]bool)
+	}
+
+	m.paused[name] = true
+	return nil
+}
+
+func (m *memoryPauseStore) Resume(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.paused, name)
+	return nil
+}
+
+func (m *memoryPauseStore) IsPaused(ctx context.Context, name string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.paused[name], nil
+}
+
+// UsePauseStore makes the runner keep track of paused tasks in s, so that pausing a task on one
+// server pauses it on every server using the same store. Tasks are only paused on this server
+// otherwise.
+func (r *Runner) UsePauseStore(s PauseStore) {
+	r.pauses = s
+}
+
+func (r *Runner) pauseStore() PauseStore {
+	r.pausesOnce.Do(func() {
+		if r.pauses == nil {
+			r.pauses = &memoryPauseStore{}
+		}
+	})
+
+	return r.pauses
+}
+
+// Pause stops the task with the given name from running on its schedule or at startup until it is
+// resumed. It can still be triggered manually.
+func (r *Runner) Pause(ctx context.Context, name string) error {
+	if _, err := r.getTask(name); err != nil {
+		return err
+	}
+
+	if err := r.pauseStore().Pause(ctx, name); err != nil {
+		return err
+	}
+
+	log.WithField(logging.FieldTask, name).Info("paused task")
+	return nil
+}
+
+// Resume lets a paused task run on its schedule again.
+func (r *Runner) Resume(ctx context.Context, name string) error {
+	if _, err := r.getTask(name); err != nil {
+		return err
+	}
+
+	if err := r.pauseStore().Resume(ctx, name); err != nil {
+		return err
+	}
+
+	log.WithField(logging.FieldTask, name).Info("resumed task")
+	return nil
+}
+
+// isPaused returns whether the task is paused. Tasks are run if it can't be determined, since a
+// task that can't reach the store would most likely fail anyway.
+func (r *Runner) isPaused(ctx context.Context, name string) bool {
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+
+	paused, err := r.pauseStore().IsPaused(ctx, name)
+	if err != nil {
+		log.WithField(logging.FieldTask, name).Warnf("could not check if task is paused: %s", err)
+		return false
+	}
+
+	return paused
+}
@@ -0,0 +1,58 @@
+// +build unit
+
+package task
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPause(t *testing.T) {
+	simpleRunner := Runner{scheduler: newScheduler(tz)}
+
+	task, runs := failingTask("paused", 0, Policy{})
+	simpleRunner.AddTask(task)
+
+	if err := simpleRunner.Pause(context.Background(), "paused"); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	simpleRunner.runScheduled(task)
+	if err := simpleRunner.runStartupTasks(context.Background()); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if *runs != 0 {
+		t.Errorf("expected paused task not to run, got %d runs", *runs)
+	}
+
+	statuses, err := simpleRunner.Status(context.Background())
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if !statuses[0].Paused {
+		t.Error("expected status to show the task as paused")
+	}
+
+	if err := simpleRunner.Resume(context.Background(), "paused"); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	simpleRunner.runScheduled(task)
+	if *runs != 1 {
+		t.Errorf("expected resumed task to run once, got %d runs", *runs)
+	}
+}
+
+func TestPauseTaskNotFound(t *testing.T) {
+	simpleRunner := Runner{scheduler: newScheduler(tz)}
+
+	if err := simpleRunner.Pause(context.Background(), "missing"); err != ErrTaskNotFound {
+		t.Errorf("expected ErrTaskNotFound, got %v", err)
+	}
+
+	if err := simpleRunner.Resume(context.Background(), "missing"); err != ErrTaskNotFound {
+		t.Errorf("expected ErrTaskNotFound, got %v", err)
+	}
+}
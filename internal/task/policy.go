@@ -0,0 +1,209 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/mrflynn/air-alert/internal/logging"
+	"github.com/mrflynn/air-alert/internal/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// Startup policies decide what happens when a task fails while the runner is starting.
+const (
+	// StartupFailFast stops the runner from starting when a task fails.
+	StartupFailFast = "fail_fast"
+	// StartupContinue logs the failure and runs the remaining startup tasks.
+	StartupContinue = "continue"
+)
+
+// Defaults for the policy fields that are left empty.
+const (
+	defaultBackoff         = 5 * time.Second
+	defaultMaxBackoff      = 5 * time.Minute
+	defaultBreakerCooldown = 10 * time.Minute
+)
+
+// Policy decides how a task recovers from failed runs and what happens when runs overlap. The zero
+// value runs every task once, never suspends it and skips runs while the previous one is running.
+type Policy struct {
+	// Retries is how many more times a failed run is attempted before giving up.
+	Retries int
+	// Backoff is the delay before the first retry, which doubles with every retry up to MaxBackoff.
+	// The delays are jittered so that retries don't line up with other servers.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// BreakerThreshold is how many runs in a row must fail, after all retries, for the task to be
+	// suspended for BreakerCooldown. The task isn't suspended if it is 0.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// Overlap is one of the overlap policies. Runs are skipped if it is empty.
+	Overlap string
+}
+
+// backoff returns the jittered delay before the given retry, where 0 is the first retry. It is
+// between half and all of the exponential delay.
+func (p Policy) backoff(retry int) time.Duration {
+	delay, max := p.Backoff, p.MaxBackoff
+	if delay <= 0 {
+		delay = defaultBackoff
+	}
+
+	if max <= 0 {
+		max = defaultMaxBackoff
+	}
+
+	for i := 0; i < retry && delay < max; i++ {
+		delay *= 2
+	}
+
+	if delay > max {
+		delay = max
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (p Policy) cooldown() time.Duration {
+	if p.BreakerCooldown <= 0 {
+		return defaultBreakerCooldown
+	}
+
+	return p.BreakerCooldown
+}
+
+// validateStartupPolicy returns an error if the policy is unknown.
+func validateStartupPolicy(policy string) error {
+	switch policy {
+	case StartupFailFast, StartupContinue:
+		return nil
+	default:
+		return fmt.Errorf(`unknown startup policy "%s"`, policy)
+	}
+}
+
+// breaker counts the runs of a task that failed in a row and suspends the task once there are too
+// many. A suspended task is allowed to run again once the cooldown is over, and is suspended again
+// straight away if that run fails too.
+type breaker struct {
+	mu             sync.Mutex
+	failures       int
+	suspendedUntil time.Time
+}
+
+// allow returns whether the task may run, or when it is suspended until.
+func (b *breaker) allow(now time.Time) (bool, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return !now.Before(b.suspendedUntil), b.suspendedUntil
+}
+
+// record counts the outcome of a run and returns whether it suspended the task.
+func (b *breaker) record(policy Policy, err error, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		b.failures = 0
+		b.suspendedUntil = time.Time{}
+		return false
+	}
+
+	b.failures++
+	if policy.BreakerThreshold <= 0 || b.failures < policy.BreakerThreshold {
+		return false
+	}
+
+	b.suspendedUntil = now.Add(policy.cooldown())
+	return true
+}
+
+// sleep waits for d or until ctx is done and returns false in the latter case.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// runWithRetries runs the task and retries it according to its policy until it succeeds or there
+// are no retries left. Every attempt is given the full TTL of the task. Retries are given up once
+// leading returns false, unless it is nil. It returns the number of attempts that were made.
+func runWithRetries(ctx context.Context, t Task, noLog bool, leading func() bool) (int, error) {
+	policy := t.GetPolicy()
+	logger := log.WithField(logging.FieldTask, t.GetName())
+
+	for retry := 0; ; retry++ {
+		err := wrapTimeout(ctx, t, true)
+		if err == nil {
+			return retry + 1, nil
+		}
+
+		if retry >= policy.Retries {
+			if !noLog {
+				logger.Error(err)
+			}
+
+			return retry + 1, err
+		}
+
+		delay := policy.backoff(retry)
+		logger.Warnf("run failed, retrying in %s (retry %d of %d): %s", delay, retry+1, policy.Retries, err)
+		metrics.TaskRetries.WithLabelValues(t.GetName()).Inc()
+
+		if !sleep(ctx, delay) {
+			return retry + 1, err
+		}
+
+		// Another runner may have taken over while the task was failing.
+		if leading != nil && !leading() {
+			logger.Warn("lost task leader lease, giving up retries")
+			return retry + 1, err
+		}
+	}
+}
+
+// breakerFor returns the breaker of the task.
+func (r *Runner) breakerFor(name string) *breaker {
+	return &r.stateOf(name).breaker
+}
+
+// runTask runs the task with retries, suspends it if it keeps failing and stores the run in the
+// history. The run is skipped, waits or cancels the previous run if the task is already running,
+// depending on its overlap policy.
+func (r *Runner) runTask(ctx context.Context, t Task, noLog bool, trigger string) error {
+	policy := t.GetPolicy()
+	logger := log.WithField(logging.FieldTask, t.GetName())
+
+	ctx, release, ok := r.slotFor(t.GetName()).acquire(ctx, policy.Overlap)
+	if !ok {
+		logger.Warnf("previous run is still running, skipping %s run", trigger)
+		metrics.TaskSkipped.WithLabelValues(t.GetName(), metrics.SkipOverlap).Inc()
+
+		return errRunSkipped
+	}
+
+	defer release()
+
+	start := time.Now()
+	attempts, err := runWithRetries(ctx, t, noLog, func() bool {
+		return r.leading(ctx)
+	})
+
+	if r.breakerFor(t.GetName()).record(policy, err, time.Now()) {
+		logger.Errorf("suspending task for %s after %d failed runs in a row", policy.cooldown(), policy.BreakerThreshold)
+	}
+
+	r.recordRun(newRun(t, trigger, start, attempts, err))
+
+	return err
+}
@@ -0,0 +1,180 @@
+// +build unit
+
+package task
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// failingTask returns a task that fails the given number of times before it succeeds.
+func failingTask(name string, failures int, policy Policy) (MinuteTask, *int) {
+	runs := 0
+
+	return MinuteTask{
+		Name:   name,
+		Rate:   5,
+		TTL:    time.Second,
+		Policy: policy,
+		RunFunc: func(context.Context) error {
+			runs++
+			if runs <= failures {
+				return errors.New("failed")
+			}
+
+			return nil
+		},
+	}, &runs
+}
+
+func TestBackoff(t *testing.T) {
+	policy := Policy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+
+	bounds := []struct {
+		min, max time.Duration
+	}{
+		{500 * time.Millisecond, time.Second},
+		{time.Second, 2 * time.Second},
+		{2 * time.Second, 4 * time.Second},
+		{2500 * time.Millisecond, 5 * time.Second},
+		{2500 * time.Millisecond, 5 * time.Second},
+	}
+
+	for retry, bound := range bounds {
+		for i := 0; i < 100; i++ {
+			if delay := policy.backoff(retry); delay < bound.min || delay > bound.max {
+				t.Fatalf("expected retry %d to wait between %s and %s, got %s", retry, bound.min, bound.max, delay)
+			}
+		}
+	}
+}
+
+func TestRunWithRetries(t *testing.T) {
+	task, runs := failingTask("flaky", 2, Policy{Retries: 2, Backoff: time.Millisecond})
+
+	if _, err := runWithRetries(context.Background(), task, true, nil); err != nil {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	if *runs != 3 {
+		t.Errorf("expected 3 runs, got %d", *runs)
+	}
+}
+
+func TestRunWithRetriesExhausted(t *testing.T) {
+	task, runs := failingTask("broken", 5, Policy{Retries: 1, Backoff: time.Millisecond})
+
+	if _, err := runWithRetries(context.Background(), task, true, nil); err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	if *runs != 2 {
+		t.Errorf("expected 2 runs, got %d", *runs)
+	}
+}
+
+func TestRunWithRetriesCancelled(t *testing.T) {
+	task, runs := failingTask("cancelled", 5, Policy{Retries: 3, Backoff: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := runWithRetries(ctx, task, true, nil); err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	if *runs != 1 {
+		t.Errorf("expected backoff to be cancelled after 1 run, got %d runs", *runs)
+	}
+}
+
+func TestRunWithRetriesLostLease(t *testing.T) {
+	task, runs := failingTask("demoted", 5, Policy{Retries: 3, Backoff: time.Millisecond})
+
+	if _, err := runWithRetries(context.Background(), task, true, func() bool { return false }); err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	if *runs != 1 {
+		t.Errorf("expected no retries after losing the lease, got %d runs", *runs)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	var (
+		b      breaker
+		policy = Policy{BreakerThreshold: 2, BreakerCooldown: time.Minute}
+		now    = reference
+		failed = errors.New("failed")
+	)
+
+	if b.record(policy, failed, now) {
+		t.Fatal("expected task not to be suspended after 1 failure")
+	}
+
+	if !b.record(policy, failed, now) {
+		t.Fatal("expected task to be suspended after 2 failures")
+	}
+
+	if ok, until := b.allow(now.Add(time.Second)); ok || !until.Equal(now.Add(time.Minute)) {
+		t.Errorf("expected task to be suspended until %s, got %t, %s", now.Add(time.Minute), ok, until)
+	}
+
+	// The first run after the cooldown suspends the task again if it fails.
+	now = now.Add(time.Minute)
+	if ok, _ := b.allow(now); !ok {
+		t.Fatal("expected task to run after the cooldown")
+	}
+
+	if !b.record(policy, failed, now) {
+		t.Fatal("expected task to be suspended again")
+	}
+
+	b.record(policy, nil, now)
+	if ok, _ := b.allow(now); !ok {
+		t.Error("expected successful run to end the suspension")
+	}
+}
+
+func TestStartupPolicy(t *testing.T) {
+	failed, _ := failingTask("failed", math.MaxInt32, Policy{})
+	healthy, runs := failingTask("healthy", 0, Policy{})
+
+	for _, test := range []struct {
+		policy string
+		fails  bool
+	}{
+		{StartupFailFast, true},
+		{StartupContinue, false},
+	} {
+		*runs = 0
+
+		simpleRunner := Runner{
+			scheduler:     newScheduler(tz),
+			startupPolicy: test.policy,
+		}
+
+		simpleRunner.AddTask(failed)
+		simpleRunner.AddTask(healthy)
+
+		err := simpleRunner.runStartupTasks(context.Background())
+		if (err != nil) != test.fails {
+			t.Errorf("%s: expected failure %t, got %v", test.policy, test.fails, err)
+		}
+
+		// The healthy task doesn't depend on the failed task, so it may have run before the failure
+		// stopped startup with the fail fast policy.
+		if !test.fails && *runs != 1 {
+			t.Errorf("%s: expected 1 run of the healthy task, got %d", test.policy, *runs)
+		}
+	}
+}
+
+func TestValidateStartupPolicy(t *testing.T) {
+	if err := validateStartupPolicy("sometimes"); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
@@ -2,46 +2,99 @@ package task
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"sort"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/go-co-op/gocron"
+	"github.com/mrflynn/air-alert/internal/logging"
+	"github.com/mrflynn/air-alert/internal/metrics"
+	"github.com/mrflynn/air-alert/internal/tracing"
+	"github.com/robfig/cron/v3"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
+// stopGracePeriod is how long a task that timed out or was cancelled is waited for to stop.
+const stopGracePeriod = 10 * time.Second
+
+var (
+	// ErrTaskNotFound is returned when there is no task with the given name.
+	ErrTaskNotFound = errors.New("task not found")
+
+	// cronParser parses standard cron expressions with an optional seconds field, as well as
+	// descriptors such as "@daily" or "@every 30s".
+	cronParser = cron.NewParser(
+		cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor,
+	)
+)
+
 // Task interface declares the methods that a Task subtype should implement.
 type Task interface {
 	Run(context.Context) error
-	GetPriority() uint
+	GetName() string
+	GetDependencies() []string
 	GetRate() interface{}
 	GetTTL() time.Duration
+	GetPolicy() Policy
 	SkipStartup() bool
 }
 
-// WrapTimeout wraps the `Run` interface method with a timeout-dependent context.
+// WrapTimeout wraps the `Run` interface method with a timeout-dependent context. The duration and
+// outcome of every run are recorded in the task metrics.
 func WrapTimeout(t Task, noLog bool) error {
-	var err error
+	return wrapTimeout(context.Background(), t, noLog)
+}
+
+// wrapTimeout is WrapTimeout with a parent context, which carries values such as the fencing token
+// to the task.
+func wrapTimeout(parent context.Context, t Task, noLog bool) error {
+	start := time.Now()
+	err := runWithTimeout(parent, t, noLog)
+	metrics.ObserveTask(t.GetName(), time.Since(start), err)
 
+	return err
+}
+
+// runWithTimeout runs the task in its own span, which is the root of the trace of everything the
+// task does. Messages logged through the context of the task are tagged with its name.
+func runWithTimeout(parent context.Context, t Task, noLog bool) (err error) {
 	// Create context without timeout equal to given time-to-live.
-	ctx, cancel := context.WithTimeout(context.Background(), t.GetTTL())
+	ctx, cancel := context.WithTimeout(parent, t.GetTTL())
 	defer cancel()
 
-	taskTermination := make(chan bool, 1)
+	logger := log.WithField(logging.FieldTask, t.GetName())
+	ctx = logging.WithLogger(ctx, logger)
+
+	ctx, span := tracing.Start(ctx, "task "+t.GetName())
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	taskTermination := make(chan error, 1)
 
 	go func() {
-		err = t.Run(ctx)
-		taskTermination <- true
+		taskTermination <- t.Run(ctx)
 	}()
 
 	select {
 	case <-ctx.Done():
-		return ctx.Err()
-	case <-taskTermination:
+		err = ctx.Err()
+
+		// The task has been told to stop through its context. Give it a moment to do so, so that it
+		// doesn't keep running alongside the next run.
+		select {
+		case <-taskTermination:
+		case <-time.After(stopGracePeriod):
+			logger.Warnf("task did not stop within %s of being cancelled", stopGracePeriod)
+		}
+
+		return err
+	case err = <-taskTermination:
 		if err != nil {
 			if !noLog {
-				log.Error(err)
+				logger.Error(err)
 			}
 
 			return err
@@ -51,13 +104,13 @@ func WrapTimeout(t Task, noLog bool) error {
 	return nil
 }
 
-type priorityToTaskMap map[uint][]Task
-
 // DailyTask is a Task that is run every day.
 type DailyTask struct {
+	Name      string
 	TimeOfDay string
-	Priority  uint
+	DependsOn []string
 	TTL       time.Duration
+	Policy    Policy
 	SkipStart bool
 	RunFunc   func(context.Context) error
 }
@@ -67,9 +120,15 @@ func (d DailyTask) Run(ctx context.Context) error {
 	return d.RunFunc(ctx)
 }
 
-// GetPriority returns the task priority.
-func (d DailyTask) GetPriority() uint {
-	return d.Priority
+// GetName returns the name of the task.
+func (d DailyTask) GetName() string {
+	return d.Name
+}
+
+// GetDependencies returns the names of the tasks that must finish before the task is run at
+// startup.
+func (d DailyTask) GetDependencies() []string {
+	return d.DependsOn
 }
 
 // GetRate returns the repeat frequency for the task.
@@ -83,6 +142,11 @@ func (d DailyTask) GetTTL() time.Duration {
 	return d.TTL
 }
 
+// GetPolicy returns how the task recovers from failed runs.
+func (d DailyTask) GetPolicy() Policy {
+	return d.Policy
+}
+
 // SkipStartup returns whether or not the task should be skipped during the initial startup phase.
 func (d DailyTask) SkipStartup() bool {
 	return d.SkipStart
@@ -91,9 +155,11 @@ func (d DailyTask) SkipStartup() bool {
 // MinuteTask is a Task that is run every n minutes where Rate defines
 // how often the task is run.
 type MinuteTask struct {
+	Name      string
 	Rate      uint64
-	Priority  uint
+	DependsOn []string
 	TTL       time.Duration
+	Policy    Policy
 	SkipStart bool
 	RunFunc   func(context.Context) error
 }
@@ -103,9 +169,15 @@ func (m MinuteTask) Run(ctx context.Context) error {
 	return m.RunFunc(ctx)
 }
 
-// GetPriority returns the task priority.
-func (m MinuteTask) GetPriority() uint {
-	return m.Priority
+// GetName returns the name of the task.
+func (m MinuteTask) GetName() string {
+	return m.Name
+}
+
+// GetDependencies returns the names of the tasks that must finish before the task is run at
+// startup.
+func (m MinuteTask) GetDependencies() []string {
+	return m.DependsOn
 }
 
 // GetRate returns the repeat frequency for the task.
@@ -119,15 +191,272 @@ func (m MinuteTask) GetTTL() time.Duration {
 	return m.TTL
 }
 
+// GetPolicy returns how the task recovers from failed runs.
+func (m MinuteTask) GetPolicy() Policy {
+	return m.Policy
+}
+
 // SkipStartup returns whether or not the task should be skipped during the initial startup phase.
 func (m MinuteTask) SkipStartup() bool {
 	return m.SkipStart
 }
 
+// CronTask is a Task that is run on a cron schedule, such as "0 0 7 * * MON-FRI" for weekdays at
+// 07:00. Schedules may have a leading seconds field and can use descriptors such as "@hourly".
+type CronTask struct {
+	Name      string
+	Schedule  string
+	DependsOn []string
+	TTL       time.Duration
+	Policy    Policy
+	SkipStart bool
+	RunFunc   func(context.Context) error
+}
+
+// Run wraps the internal RunFunc field. This method runs it and returns the result.
+func (c CronTask) Run(ctx context.Context) error {
+	return c.RunFunc(ctx)
+}
+
+// GetName returns the name of the task.
+func (c CronTask) GetName() string {
+	return c.Name
+}
+
+// GetDependencies returns the names of the tasks that must finish before the task is run at
+// startup.
+func (c CronTask) GetDependencies() []string {
+	return c.DependsOn
+}
+
+// GetRate returns the cron schedule of the task.
+func (c CronTask) GetRate() interface{} {
+	return c.Schedule
+}
+
+// GetTTL returns the maximum duration of the task. This parameter is to prevent the task
+// from running too long if it hangs.
+func (c CronTask) GetTTL() time.Duration {
+	return c.TTL
+}
+
+// GetPolicy returns how the task recovers from failed runs.
+func (c CronTask) GetPolicy() Policy {
+	return c.Policy
+}
+
+// SkipStartup returns whether or not the task should be skipped during the initial startup phase.
+func (c CronTask) SkipStartup() bool {
+	return c.SkipStart
+}
+
+// DurationTask is a Task that is run repeatedly with Interval between the start of each run.
+// Intervals are rounded down to whole seconds.
+type DurationTask struct {
+	Name      string
+	Interval  time.Duration
+	DependsOn []string
+	TTL       time.Duration
+	Policy    Policy
+	SkipStart bool
+	RunFunc   func(context.Context) error
+}
+
+// Run wraps the internal RunFunc field. This method runs it and returns the result.
+func (d DurationTask) Run(ctx context.Context) error {
+	return d.RunFunc(ctx)
+}
+
+// GetName returns the name of the task.
+func (d DurationTask) GetName() string {
+	return d.Name
+}
+
+// GetDependencies returns the names of the tasks that must finish before the task is run at
+// startup.
+func (d DurationTask) GetDependencies() []string {
+	return d.DependsOn
+}
+
+// GetRate returns the repeat frequency for the task.
+func (d DurationTask) GetRate() interface{} {
+	return d.Interval
+}
+
+// GetTTL returns the maximum duration of the task. This parameter is to prevent the task
+// from running too long if it hangs.
+func (d DurationTask) GetTTL() time.Duration {
+	return d.TTL
+}
+
+// GetPolicy returns how the task recovers from failed runs.
+func (d DurationTask) GetPolicy() Policy {
+	return d.Policy
+}
+
+// SkipStartup returns whether or not the task should be skipped during the initial startup phase.
+func (d DurationTask) SkipStartup() bool {
+	return d.SkipStart
+}
+
+// FromConfig creates a task that runs on the schedule configured at tasks.<name>.schedule, where
+// dashes in the name are replaced with underscores. A schedule that is a duration such as "5m"
+// creates a DurationTask, and anything else is treated as a cron schedule. The policy of the task is
+// read from the same section.
+func FromConfig(name string, dependsOn []string, ttl time.Duration, run func(context.Context) error) (Task, error) {
+	prefix := configPrefix(name)
+
+	schedule := viper.GetString(prefix + "schedule")
+	if schedule == "" {
+		return nil, fmt.Errorf("no schedule configured for task %s at %s", name, prefix+"schedule")
+	}
+
+	policy := Policy{
+		Retries:          viper.GetInt(prefix + "retries"),
+		Backoff:          viper.GetDuration(prefix + "backoff"),
+		MaxBackoff:       viper.GetDuration(prefix + "max_backoff"),
+		BreakerThreshold: viper.GetInt(prefix + "breaker_threshold"),
+		BreakerCooldown:  viper.GetDuration(prefix + "breaker_cooldown"),
+		Overlap:          viper.GetString(prefix + "overlap"),
+	}
+
+	if err := validateOverlap(policy.Overlap); err != nil {
+		return nil, fmt.Errorf("invalid policy for task %s: %s", name, err)
+	}
+
+	if policy.Retries < 0 || policy.BreakerThreshold < 0 {
+		return nil, fmt.Errorf("retries and breaker threshold of task %s must not be negative", name)
+	}
+
+	if interval, err := time.ParseDuration(schedule); err == nil {
+		return DurationTask{
+			Name:      name,
+			Interval:  interval,
+			DependsOn: dependsOn,
+			TTL:       ttl,
+			Policy:    policy,
+			RunFunc:   run,
+		}, nil
+	}
+
+	return CronTask{
+		Name:      name,
+		Schedule:  schedule,
+		DependsOn: dependsOn,
+		TTL:       ttl,
+		Policy:    policy,
+		RunFunc:   run,
+	}, nil
+}
+
+func configPrefix(name string) string {
+	return "tasks." + strings.ReplaceAll(name, "-", "_") + "."
+}
+
+// ConfiguredInterval returns how often the task with the given name runs according to the
+// schedule configured at tasks.<name>.schedule. For cron schedules, this is the time between its
+// next two runs.
+func ConfiguredInterval(name string) (time.Duration, error) {
+	key := configPrefix(name) + "schedule"
+
+	schedule := viper.GetString(key)
+	if schedule == "" {
+		return 0, fmt.Errorf("no schedule configured for task %s at %s", name, key)
+	}
+
+	if interval, err := time.ParseDuration(schedule); err == nil {
+		return interval, nil
+	}
+
+	parsed, err := cronParser.Parse(schedule)
+	if err != nil {
+		return 0, fmt.Errorf(`invalid schedule "%s" for task %s: %s`, schedule, name, err)
+	}
+
+	next := parsed.Next(time.Now())
+
+	return parsed.Next(next).Sub(next), nil
+}
+
+// scheduleOf returns when the task should run.
+func scheduleOf(task Task) (cron.Schedule, error) {
+	switch t := task.(type) {
+	case DailyTask:
+		timeOfDay, err := time.Parse("15:04", t.TimeOfDay)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid time of day "%s": %s`, t.TimeOfDay, err)
+		}
+
+		return cronParser.Parse(fmt.Sprintf("0 %d %d * * *", timeOfDay.Minute(), timeOfDay.Hour()))
+	case MinuteTask:
+		if t.Rate == 0 {
+			return nil, errors.New("rate must be at least 1 minute")
+		}
+
+		return cron.Every(time.Duration(t.Rate) * time.Minute), nil
+	case DurationTask:
+		if t.Interval < time.Second {
+			return nil, fmt.Errorf("interval must be at least 1 second, got %s", t.Interval)
+		}
+
+		return cron.Every(t.Interval), nil
+	case CronTask:
+		schedule, err := cronParser.Parse(t.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid cron schedule "%s": %s`, t.Schedule, err)
+		}
+
+		return schedule, nil
+	default:
+		return nil, fmt.Errorf(`No scheduler for type %T`, t)
+	}
+}
+
 // Runner is the main background task runner in this package.
 type Runner struct {
-	scheduler *gocron.Scheduler
-	tasks     []Task
+	scheduler     *cron.Cron
+	tasks         []Task
+	elector       *elector
+	history       History
+	startupPolicy string
+
+	pausesOnce sync.Once
+	pauses     PauseStore
+
+	triggersOnce sync.Once
+	triggers     TriggerQueue
+
+	statesMu sync.Mutex
+	states   map[string]*taskState
+}
+
+// taskState is what the runner keeps track of for every task.
+type taskState struct {
+	breaker breaker
+	slot    slot
+}
+
+// stateOf returns the state of the task.
+func (r *Runner) stateOf(name string) *taskState {
+	r.statesMu.Lock()
+	defer r.statesMu.Unlock()
+
+	if r.states == nil {
+		r.states = make(map[string]*taskState)
+	}
+
+	state, ok := r.states[name]
+	if !ok {
+		state = &taskState{}
+		r.states[name] = state
+	}
+
+	return state
+}
+
+// newScheduler creates a scheduler that evaluates schedules in the given time zone.
+func newScheduler(location *time.Location) *cron.Cron {
+	return cron.New(cron.WithLocation(location), cron.WithParser(cronParser))
 }
 
 // NewRunner initializes a new Runner struct.
@@ -137,79 +466,174 @@ func NewRunner() (*Runner, error) {
 		return &Runner{}, err
 	}
 
-	scheduler := gocron.NewScheduler(location)
+	startupPolicy := viper.GetString("tasks.startup_policy")
+	if err := validateStartupPolicy(startupPolicy); err != nil {
+		return &Runner{}, err
+	}
+
+	scheduler := newScheduler(location)
 	tasks := make([]Task, 0, 5)
 
 	return &Runner{
-		scheduler: scheduler,
-		tasks:     tasks,
+		scheduler:     scheduler,
+		tasks:         tasks,
+		startupPolicy: startupPolicy,
 	}, nil
 }
 
-// AddTask adds the task to the runner and schedules it.
+// AddTask adds the task to the runner and schedules it. Task names must be unique, and the task
+// must not depend on itself through the tasks that have been added so far. Tasks may depend on
+// tasks that are added later.
 func (r *Runner) AddTask(task Task) error {
-	switch t := task.(type) {
-	case DailyTask:
-		r.scheduler.Every(1).Day().At(task.GetRate().(string)).Do(WrapTimeout, task, false)
-	case MinuteTask:
-		r.scheduler.Every(task.GetRate().(uint64)).Minutes().Do(WrapTimeout, task, false)
-	default:
-		return fmt.Errorf(`No scheduler for type %T`, t)
+	if _, err := r.getTask(task.GetName()); err == nil {
+		return fmt.Errorf(`task with name "%s" already exists`, task.GetName())
+	}
+
+	if cycle := findCycle(append(r.tasks[:len(r.tasks):len(r.tasks)], task)); cycle != nil {
+		return fmt.Errorf("task dependencies form a cycle: %s", strings.Join(cycle, " -> "))
+	}
+
+	schedule, err := scheduleOf(task)
+	if err != nil {
+		return err
+	}
+
+	if err := validateOverlap(task.GetPolicy().Overlap); err != nil {
+		return err
 	}
 
+	r.scheduler.Schedule(schedule, cron.FuncJob(func() {
+		r.runScheduled(task)
+	}))
+
 	r.tasks = append(r.tasks, task)
 	return nil
 }
 
-func (r *Runner) exposePriorities() priorityToTaskMap {
-	taskMap := make(priorityToTaskMap, len(r.tasks))
+// UseLease makes the runner only run scheduled and startup tasks while it holds the lease, so
+// that they are run by a single server even if several are running. The lease is renewed three
+// times per ttl, which must be the TTL the lease was created with. It must be called before Start.
+func (r *Runner) UseLease(lease Lease, ttl time.Duration) {
+	r.elector = newElector(lease, ttl)
+}
 
-	for _, task := range r.tasks {
-		priority := task.GetPriority()
-		taskMap[priority] = append(taskMap[priority], task)
+// leaderContext returns a context with the fencing token of the lease, or false if the runner
+// uses a lease that it doesn't hold.
+func (r *Runner) leaderContext() (context.Context, bool) {
+	ctx := context.Background()
+	if r.elector == nil {
+		return ctx, true
 	}
 
-	return taskMap
+	token, ok := r.elector.leader()
+	if !ok {
+		return nil, false
+	}
+
+	return context.WithValue(ctx, fencingTokenKey{}, token), true
 }
 
-func (r *Runner) runAllTasksInOrder() error {
-	exposedPriorities := r.exposePriorities()
+// leading returns false if ctx carries a fencing token that is no longer the token of the lease
+// held by the runner. Runs without a token, like those of a runner without a lease, aren't tied to
+// the lease.
+func (r *Runner) leading(ctx context.Context) bool {
+	token, ok := FencingToken(ctx)
+	if !ok || r.elector == nil {
+		return true
+	}
 
-	orderedPriorities := make([]uint, len(exposedPriorities))
-	for priority := range exposedPriorities {
-		orderedPriorities = append(orderedPriorities, priority)
+	current, ok := r.elector.leader()
+	return ok && current == token
+}
+
+// runScheduled runs a task on its schedule if this runner is the leader and the task isn't paused
+// or suspended.
+func (r *Runner) runScheduled(task Task) {
+	logger := log.WithField(logging.FieldTask, task.GetName())
+
+	ctx, ok := r.leaderContext()
+	if !ok {
+		logger.Debug("not the task leader, skipping scheduled run")
+		return
 	}
 
-	sort.Slice(orderedPriorities, func(i, j int) bool {
-		return orderedPriorities[i] < orderedPriorities[j]
-	})
+	if r.isPaused(ctx, task.GetName()) {
+		logger.Debug("task is paused, skipping scheduled run")
+		metrics.TaskSkipped.WithLabelValues(task.GetName(), metrics.SkipPaused).Inc()
+		return
+	}
 
-	for _, priority := range orderedPriorities {
-		for _, task := range exposedPriorities[priority] {
-			if !task.SkipStartup() {
-				if err := WrapTimeout(task, true); err != nil {
-					return err
-				}
-			}
+	if ok, until := r.breakerFor(task.GetName()).allow(time.Now()); !ok {
+		logger.Warnf("task is suspended until %s, skipping scheduled run", until.Format(time.RFC3339))
+		metrics.TaskSkipped.WithLabelValues(task.GetName(), metrics.SkipSuspended).Inc()
+		return
+	}
+
+	r.runTask(ctx, task, false, TriggerScheduled)
+}
+
+func (r *Runner) getTask(name string) (Task, error) {
+	for _, task := range r.tasks {
+		if task.GetName() == name {
+			return task, nil
 		}
 	}
 
-	return nil
+	return nil, ErrTaskNotFound
+}
+
+// Tasks returns the names of all tasks in the runner in insertion order.
+func (r *Runner) Tasks() []string {
+	names := make([]string, 0, len(r.tasks))
+	for _, task := range r.tasks {
+		names = append(names, task.GetName())
+	}
+
+	return names
 }
 
-// Start runs all tasks in descending order of priority (where 0 is the highest priority)
-// or by insertion order if two tasks have the same priority. Then it starts a background
-// thread which will run all tasks every day at the specified time.
+// Start runs every task once, each after the tasks it depends on have finished, so that tasks
+// that don't depend on each other run at the same time. Then it starts a background thread which
+// will run all tasks on their schedules. A failed startup task stops the runner from starting,
+// unless the startup policy is StartupContinue, and so does a task that depends on a task that
+// doesn't exist. If the runner uses a lease, startup tasks are only run if the lease could be
+// acquired straight away, and the lease is renewed while they run.
 func (r *Runner) Start() error {
-	if err := r.runAllTasksInOrder(); err != nil {
-		return fmt.Errorf(`task failed during startup: %s`, err)
+	if err := r.checkDependencies(); err != nil {
+		return err
 	}
 
-	r.scheduler.StartAsync()
+	if r.elector != nil {
+		r.elector.refresh()
+		r.elector.start()
+	}
+
+	if ctx, ok := r.leaderContext(); ok {
+		if err := r.runStartupTasks(ctx); err != nil {
+			if r.elector != nil {
+				r.elector.shutdown()
+			}
+
+			return fmt.Errorf(`task failed during startup: %s`, err)
+		}
+	} else {
+		log.Info("another server is the task leader, skipping startup tasks")
+	}
+
+	// Tasks triggered on other servers are run by the leader.
+	if r.elector != nil {
+		r.scheduler.Schedule(cron.Every(triggerPollInterval), cron.FuncJob(r.runTriggered))
+	}
+
+	r.scheduler.Start()
 	return nil
 }
 
-// Stop stops the background thread.
+// Stop stops the background thread and releases the lease if the runner holds it.
 func (r *Runner) Stop() {
 	r.scheduler.Stop()
+
+	if r.elector != nil {
+		r.elector.shutdown()
+	}
 }
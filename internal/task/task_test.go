@@ -7,9 +7,9 @@ import (
 	"testing"
 	"time"
 
-	"github.com/go-co-op/gocron"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/spf13/viper"
 )
 
 type FakeTask struct{}
@@ -18,8 +18,12 @@ func (f FakeTask) Run(context.Context) error {
 	return nil
 }
 
-func (f FakeTask) GetPriority() uint {
-	return 0
+func (f FakeTask) GetName() string {
+	return "fake"
+}
+
+func (f FakeTask) GetDependencies() []string {
+	return nil
 }
 
 func (f FakeTask) GetRate() interface{} {
@@ -30,6 +34,10 @@ func (f FakeTask) GetTTL() time.Duration {
 	return 5 * time.Second
 }
 
+func (f FakeTask) GetPolicy() Policy {
+	return Policy{}
+}
+
 func (f FakeTask) SkipStartup() bool {
 	return false
 }
@@ -37,13 +45,16 @@ func (f FakeTask) SkipStartup() bool {
 var (
 	tz = time.UTC
 
+	// reference is the time that schedules are checked against.
+	reference = time.Date(2020, time.September, 1, 8, 0, 0, 0, tz)
+
 	firstChan  = make(chan int64, 1)
 	secondChan = make(chan int64, 1)
 	thirdChan  = make(chan bool, 1)
 
 	firstTask = DailyTask{
+		Name:      "first",
 		TimeOfDay: "10:30",
-		Priority:  1,
 		TTL:       20 * time.Second,
 		RunFunc: func(context.Context) error {
 			firstChan <- time.Now().UnixNano()
@@ -52,17 +63,19 @@ var (
 		},
 	}
 	secondTask = MinuteTask{
-		Rate:     5,
-		Priority: 2,
-		TTL:      5 * time.Second,
+		Name:      "second",
+		Rate:      5,
+		DependsOn: []string{"first"},
+		TTL:       5 * time.Second,
 		RunFunc: func(context.Context) error {
 			secondChan <- time.Now().UnixNano()
 			return nil
 		},
 	}
 	thirdTask = MinuteTask{
+		Name:      "third",
 		Rate:      10,
-		Priority:  2,
+		DependsOn: []string{"first"},
 		TTL:       5 * time.Second,
 		SkipStart: true,
 		RunFunc: func(context.Context) error {
@@ -71,20 +84,19 @@ var (
 		},
 	}
 	runner = Runner{
-		scheduler: gocron.NewScheduler(tz),
+		scheduler: newScheduler(tz),
 		tasks:     []Task{firstTask, secondTask, thirdTask},
 	}
 )
 
 func TestAddTaskDaily(t *testing.T) {
 	simpleRunner := Runner{
-		scheduler: gocron.NewScheduler(tz),
+		scheduler: newScheduler(tz),
 		tasks:     make([]Task, 0, 5),
 	}
 
 	task := DailyTask{
 		TimeOfDay: "10:30",
-		Priority:  1,
 		RunFunc: func(context.Context) error {
 			return nil
 		},
@@ -100,24 +112,17 @@ func TestAddTaskDaily(t *testing.T) {
 		t.Errorf("Expected %+v\nGot %+v", task, simpleRunner.tasks[0])
 	}
 
-	if taskCount := simpleRunner.scheduler.Len(); taskCount != 1 {
-		t.Errorf(`Expected 1 task, got %d tasks`, taskCount)
-	}
-
-	if err := simpleRunner.scheduler.Jobs()[0].Err(); err != nil {
-		t.Errorf(`Got error during job creation: %s`, err)
-	}
+	checkSchedule(t, &simpleRunner, time.Date(2020, time.September, 1, 10, 30, 0, 0, tz))
 }
 
 func TestAddTaskMinute(t *testing.T) {
 	simpleRunner := Runner{
-		scheduler: gocron.NewScheduler(tz),
+		scheduler: newScheduler(tz),
 		tasks:     make([]Task, 0, 5),
 	}
 
 	task := MinuteTask{
-		Rate:     5,
-		Priority: 1,
+		Rate: 5,
 		RunFunc: func(context.Context) error {
 			return nil
 		},
@@ -133,18 +138,177 @@ func TestAddTaskMinute(t *testing.T) {
 		t.Errorf("Expected %+v\nGot %+v", task, simpleRunner.tasks[0])
 	}
 
-	if taskCount := simpleRunner.scheduler.Len(); taskCount != 1 {
-		t.Errorf(`Expected 1 task, got %d tasks`, taskCount)
+	checkSchedule(t, &simpleRunner, reference.Add(5*time.Minute))
+}
+
+// checkSchedule checks that the runner has exactly one scheduled task, which next runs at the
+// expected time after the reference time.
+func checkSchedule(t *testing.T, r *Runner, expected time.Time) {
+	t.Helper()
+
+	entries := r.scheduler.Entries()
+	if taskCount := len(entries); taskCount != 1 {
+		t.Fatalf(`Expected 1 task, got %d tasks`, taskCount)
+	}
+
+	if next := entries[0].Schedule.Next(reference); !next.Equal(expected) {
+		t.Errorf(`Expected next run at %s, got %s`, expected, next)
+	}
+}
+
+func TestAddTaskCron(t *testing.T) {
+	tests := []struct {
+		schedule string
+		expected time.Time
+	}{
+		// Weekdays at 07:00. The reference time is a Tuesday after 07:00.
+		{"0 7 * * MON-FRI", time.Date(2020, time.September, 2, 7, 0, 0, 0, tz)},
+		// Every 30 seconds.
+		{"*/30 * * * * *", reference.Add(30 * time.Second)},
+		{"@every 30s", reference.Add(30 * time.Second)},
+		{"@hourly", reference.Add(time.Hour)},
 	}
 
-	if err := simpleRunner.scheduler.Jobs()[0].Err(); err != nil {
-		t.Errorf(`Got error during job creation: %s`, err)
+	for _, test := range tests {
+		simpleRunner := Runner{
+			scheduler: newScheduler(tz),
+			tasks:     make([]Task, 0, 5),
+		}
+
+		err := simpleRunner.AddTask(CronTask{
+			Name:     "cron",
+			Schedule: test.schedule,
+			RunFunc: func(context.Context) error {
+				return nil
+			},
+		})
+		if err != nil {
+			t.Fatalf(`%s: got error: %s`, test.schedule, err)
+		}
+
+		checkSchedule(t, &simpleRunner, test.expected)
+	}
+}
+
+func TestAddTaskDuration(t *testing.T) {
+	simpleRunner := Runner{
+		scheduler: newScheduler(tz),
+		tasks:     make([]Task, 0, 5),
+	}
+
+	err := simpleRunner.AddTask(DurationTask{
+		Name:     "duration",
+		Interval: 30 * time.Second,
+		RunFunc: func(context.Context) error {
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf(`Got error: %s`, err)
+	}
+
+	checkSchedule(t, &simpleRunner, reference.Add(30*time.Second))
+}
+
+func TestAddTaskInvalidSchedule(t *testing.T) {
+	tasks := []Task{
+		DailyTask{Name: "daily", TimeOfDay: "25:00"},
+		MinuteTask{Name: "minute"},
+		DurationTask{Name: "duration", Interval: time.Millisecond},
+		CronTask{Name: "cron", Schedule: "every day"},
+	}
+
+	for _, task := range tasks {
+		simpleRunner := Runner{
+			scheduler: newScheduler(tz),
+			tasks:     make([]Task, 0, 5),
+		}
+
+		if err := simpleRunner.AddTask(task); err == nil {
+			t.Errorf(`%s: expected error, got nil`, task.GetName())
+		}
+
+		if len(simpleRunner.tasks) != 0 || len(simpleRunner.scheduler.Entries()) != 0 {
+			t.Errorf(`%s: task should not have been added if there was an error`, task.GetName())
+		}
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	defer viper.Reset()
+
+	viper.Set("tasks.from_interval.schedule", "30s")
+	viper.Set("tasks.from_cron.schedule", "0 7 * * MON-FRI")
+	viper.Set("tasks.from_cron.retries", 3)
+	viper.Set("tasks.from_cron.backoff", "10s")
+	viper.Set("tasks.from_cron.breaker_threshold", 5)
+
+	task, err := FromConfig("from-interval", []string{"from-cron"}, time.Second, nil)
+	if err != nil {
+		t.Fatalf(`Got error: %s`, err)
+	}
+
+	if d, ok := task.(DurationTask); !ok || d.Interval != 30*time.Second || !cmp.Equal(d.DependsOn, []string{"from-cron"}) {
+		t.Errorf(`Expected 30 second DurationTask, got %+v`, task)
+	}
+
+	task, err = FromConfig("from-cron", nil, time.Second, nil)
+	if err != nil {
+		t.Fatalf(`Got error: %s`, err)
+	}
+
+	if c, ok := task.(CronTask); !ok || c.Schedule != "0 7 * * MON-FRI" {
+		t.Errorf(`Expected CronTask, got %+v`, task)
+	}
+
+	expectedPolicy := Policy{Retries: 3, Backoff: 10 * time.Second, BreakerThreshold: 5}
+	if policy := task.GetPolicy(); policy != expectedPolicy {
+		t.Errorf("Expected policy %+v, got %+v", expectedPolicy, policy)
+	}
+
+	if _, err := FromConfig("missing", nil, time.Second, nil); err == nil {
+		t.Error(`Expected error, got nil`)
+	}
+}
+
+func TestConfiguredInterval(t *testing.T) {
+	defer viper.Reset()
+
+	tests := []struct {
+		schedule string
+		interval time.Duration
+	}{
+		{"5m", 5 * time.Minute},
+		{"*/10 * * * *", 10 * time.Minute},
+		{"@every 30s", 30 * time.Second},
+		{"0 30 3 * * *", 24 * time.Hour},
+	}
+
+	for _, test := range tests {
+		viper.Set("tasks.update_aqi.schedule", test.schedule)
+
+		interval, err := ConfiguredInterval("update-aqi")
+		if err != nil {
+			t.Fatalf(`%s: got error: %s`, test.schedule, err)
+		}
+
+		if interval != test.interval {
+			t.Errorf(`%s: expected interval of %s, got %s`, test.schedule, test.interval, interval)
+		}
+	}
+
+	for _, schedule := range []string{"", "every now and then"} {
+		viper.Set("tasks.update_aqi.schedule", schedule)
+
+		if _, err := ConfiguredInterval("update-aqi"); err == nil {
+			t.Errorf(`%q: expected error, got nil`, schedule)
+		}
 	}
 }
 
 func TestAddFakeTask(t *testing.T) {
 	simpleRunner := Runner{
-		scheduler: gocron.NewScheduler(tz),
+		scheduler: newScheduler(tz),
 		tasks:     make([]Task, 0, 5),
 	}
 
@@ -160,23 +324,68 @@ func TestAddFakeTask(t *testing.T) {
 	}
 }
 
-func TestExposePriorities(t *testing.T) {
-	result := runner.exposePriorities()
-	expected := map[uint][]Task{1: {firstTask}, 2: {secondTask, thirdTask}}
+func TestAddTaskDuplicateName(t *testing.T) {
+	simpleRunner := Runner{
+		scheduler: newScheduler(tz),
+		tasks:     make([]Task, 0, 5),
+	}
 
-	for k, v := range result {
-		if task, exists := expected[k]; exists {
-			if !cmp.Equal(task, v, cmpopts.IgnoreFields(DailyTask{}, "RunFunc"), cmpopts.IgnoreFields(MinuteTask{}, "RunFunc")) {
-				t.Errorf("Expected %+v\nGot%+v\nAt key %d", task, v, k)
-			}
-		} else {
-			t.Errorf(`Key %d in expected not found, but should have been found`, k)
-		}
+	task := MinuteTask{
+		Name: "duplicate",
+		Rate: 5,
+		RunFunc: func(context.Context) error {
+			return nil
+		},
+	}
+
+	if err := simpleRunner.AddTask(task); err != nil {
+		t.Errorf(`Got error: %s`, err)
+	}
+
+	if err := simpleRunner.AddTask(task); err == nil {
+		t.Error(`Expected error, got nil`)
+	}
+
+	if count := len(simpleRunner.tasks); count != 1 {
+		t.Errorf(`Expected 1 task, got %d tasks`, count)
+	}
+}
+
+func TestTrigger(t *testing.T) {
+	triggered := make(chan bool, 1)
+
+	simpleRunner := Runner{
+		scheduler: newScheduler(tz),
+		tasks:     make([]Task, 0, 5),
+	}
+
+	simpleRunner.AddTask(MinuteTask{
+		Name: "triggered",
+		Rate: 5,
+		TTL:  5 * time.Second,
+		RunFunc: func(context.Context) error {
+			triggered <- true
+			return nil
+		},
+	})
+
+	if err := simpleRunner.Trigger(context.Background(), "triggered"); err != nil {
+		t.Errorf(`Got error: %s`, err)
+	}
+
+	select {
+	case <-triggered:
+	case <-time.After(time.Second):
+		t.Error(`Task was not run after being triggered`)
+	}
+
+	if err := simpleRunner.Trigger(context.Background(), "missing"); err != ErrTaskNotFound {
+		t.Errorf(`Expected ErrTaskNotFound, got %v`, err)
 	}
 }
 
-func TestRunAllTasksInOrder(t *testing.T) {
-	err := runner.runAllTasksInOrder()
+func TestRunStartupTasksInOrder(t *testing.T) {
+	err := runner.runStartupTasks(context.Background())
 
 	if err != nil {
 		t.Errorf(`Got error: %s`, err)
@@ -191,7 +400,7 @@ func TestRunAllTasksInOrder(t *testing.T) {
 }
 
 func TestSkipTask(t *testing.T) {
-	err := runner.runAllTasksInOrder()
+	err := runner.runStartupTasks(context.Background())
 
 	if err != nil {
 		t.Errorf(`Got error: %s`, err)
@@ -207,14 +416,13 @@ func TestSkipTask(t *testing.T) {
 
 func TestTimeout(t *testing.T) {
 	simpleRunner := Runner{
-		scheduler: gocron.NewScheduler(tz),
+		scheduler: newScheduler(tz),
 		tasks:     make([]Task, 0, 5),
 	}
 
 	task := MinuteTask{
-		Rate:     5,
-		Priority: 1,
-		TTL:      1 * time.Second,
+		Rate: 5,
+		TTL:  1 * time.Second,
 		RunFunc: func(context.Context) error {
 			time.Sleep(2 * time.Second)
 			return nil
@@ -223,7 +431,7 @@ func TestTimeout(t *testing.T) {
 
 	simpleRunner.AddTask(task)
 
-	err := simpleRunner.runAllTasksInOrder()
+	err := simpleRunner.runStartupTasks(context.Background())
 	if err == nil {
 		t.Error("Expected error, got nil")
 	}
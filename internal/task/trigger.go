@@ -0,0 +1,130 @@
+package task
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mrflynn/air-alert/internal/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// triggerPollInterval is how often the leader checks for tasks that were triggered on other
+// servers.
+const triggerPollInterval = time.Second
+
+// TriggerQueue passes tasks that were triggered on one server to the server that holds the lease.
+type TriggerQueue interface {
+	// Push adds a triggered task to the queue.
+	Push(ctx context.Context, name string) error
+	// Pop removes every task from the queue and returns them in the order they were triggered.
+	Pop(ctx context.Context) ([]string, error)
+}
+
+// memoryTriggerQueue keeps triggered tasks in memory, so they are only run once this server holds
+// the lease.
+type memoryTriggerQueue struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (m *memoryTriggerQueue) Push(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.names = append(m.names, name)
+	return nil
+}
+
+func (m *memoryTriggerQueue) Pop(ctx context.Context) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := m.names
+	m.names = nil
+
+	return names, nil
+}
+
+// UseTriggerQueue makes a runner that uses a lease pass tasks that are triggered while another
+// server holds the lease to that server through q. Tasks are only queued on this server otherwise.
+func (r *Runner) UseTriggerQueue(q TriggerQueue) {
+	r.triggers = q
+}
+
+func (r *Runner) triggerQueue() TriggerQueue {
+	r.triggersOnce.Do(func() {
+		if r.triggers == nil {
+			r.triggers = &memoryTriggerQueue{}
+		}
+	})
+
+	return r.triggers
+}
+
+// Trigger runs the task with the given name in the background outside of its regular schedule.
+// If the runner uses a lease that another server holds, the task is queued for that server
+// instead, so that triggered tasks are run under the lease like scheduled ones. Triggered tasks are
+// run even if the task is paused or suspended, and a successful run ends the suspension.
+// ErrTaskRunning is returned if the task is running here and its overlap policy skips runs.
+func (r *Runner) Trigger(ctx context.Context, name string) error {
+	task, err := r.getTask(name)
+	if err != nil {
+		return err
+	}
+
+	logger := log.WithField(logging.FieldTask, name)
+
+	runCtx, ok := r.leaderContext()
+	if !ok {
+		ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+		defer cancel()
+
+		if err := r.triggerQueue().Push(ctx, name); err != nil {
+			return err
+		}
+
+		logger.Info("manually triggered task, passing it to the task leader")
+		return nil
+	}
+
+	overlap := task.GetPolicy().Overlap
+	if (overlap == "" || overlap == OverlapSkip) && r.slotFor(name).running() {
+		return ErrTaskRunning
+	}
+
+	logger.Info("manually triggered task")
+	go r.runTask(runCtx, task, false, TriggerManual)
+
+	return nil
+}
+
+// runTriggered runs the tasks that were triggered on other servers if the runner is the leader.
+func (r *Runner) runTriggered() {
+	ctx, ok := r.leaderContext()
+	if !ok {
+		return
+	}
+
+	popCtx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+
+	names, err := r.triggerQueue().Pop(popCtx)
+	if err != nil {
+		log.Warnf("could not fetch triggered tasks: %s", err)
+		return
+	}
+
+	for _, name := range names {
+		logger := log.WithField(logging.FieldTask, name)
+
+		task, err := r.getTask(name)
+		if err != nil {
+			logger.Warn("skipping unknown triggered task")
+			continue
+		}
+
+		logger.Info("running task triggered on another server")
+		go r.runTask(ctx, task, false, TriggerManual)
+	}
+}
@@ -0,0 +1,173 @@
+package tiles
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"sort"
+
+	utils "github.com/mrflynn/air-alert/internal"
+	"github.com/mrflynn/air-alert/internal/geo"
+)
+
+const (
+	// Size is the width and height of a tile in pixels.
+	Size = 256
+	// MinZoom is the lowest zoom level that tiles are rendered for. Tiles below this level cover
+	// so many sensors that they are too expensive to render on demand.
+	MinZoom = 5
+	// MaxZoom is the highest zoom level that tiles are rendered for.
+	MaxZoom = 18
+
+	// metersPerDegree is the approximate length of one degree of latitude in meters.
+	metersPerDegree = 111320.0
+	// alpha is the opacity of rendered pixels so that the map below stays visible.
+	alpha = 160
+)
+
+// Sample is an AQI measurement at a location.
+type Sample struct {
+	Longitude float64
+	Latitude  float64
+	AQI       float64
+}
+
+// Valid checks if the tile coordinates exist and are within the supported zoom levels.
+func Valid(z, x, y int) bool {
+	if z < MinZoom || z > MaxZoom {
+		return false
+	}
+
+	n := 1 << uint(z)
+	return x >= 0 && x < n && y >= 0 && y < n
+}
+
+// worldSize returns the width and height of the whole map in pixels at the given zoom level.
+func worldSize(z int) float64 {
+	return float64(int(Size) << uint(z))
+}
+
+// longitude returns the longitude of a horizontal pixel position at the given zoom level.
+func longitude(z int, px float64) float64 {
+	return px/worldSize(z)*360 - 180
+}
+
+// latitude returns the latitude of a vertical pixel position at the given zoom level using the
+// Web Mercator projection.
+func latitude(z int, py float64) float64 {
+	n := math.Pi * (1 - 2*py/worldSize(z))
+	return math.Atan(math.Sinh(n)) * 180 / math.Pi
+}
+
+// Bounds returns the area covered by a tile.
+func Bounds(z, x, y int) geo.Bounds {
+	return geo.Bounds{
+		MinLatitude:  latitude(z, float64((y+1)*Size)),
+		MinLongitude: longitude(z, float64(x*Size)),
+		MaxLatitude:  latitude(z, float64(y*Size)),
+		MaxLongitude: longitude(z, float64((x+1)*Size)),
+	}
+}
+
+// Expand grows bounds by distance meters in every direction.
+func Expand(bounds geo.Bounds, distance float64) geo.Bounds {
+	latDelta := distance / metersPerDegree
+
+	maxLatitude := math.Max(math.Abs(bounds.MinLatitude), math.Abs(bounds.MaxLatitude))
+	longDelta := 180.0
+	if cos := math.Cos(maxLatitude * math.Pi / 180); cos > 1e-6 {
+		longDelta = math.Min(180, distance/(metersPerDegree*cos))
+	}
+
+	return geo.Bounds{
+		MinLatitude:  math.Max(-90, bounds.MinLatitude-latDelta),
+		MinLongitude: math.Max(-180, bounds.MinLongitude-longDelta),
+		MaxLatitude:  math.Min(90, bounds.MaxLatitude+latDelta),
+		MaxLongitude: math.Min(180, bounds.MaxLongitude+longDelta),
+	}
+}
+
+// Render creates a tile that shows the AQI interpolated from the given samples. Each pixel is
+// colored with the EPA color of the inverse distance weighted AQI of every sample within radius
+// meters. Pixels without any samples nearby are transparent.
+func Render(z, x, y int, samples []Sample, radius float64) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, Size, Size))
+
+	sorted := make([]Sample, len(samples))
+	copy(sorted, samples)
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Latitude < sorted[j].Latitude
+	})
+
+	radiusDegrees := radius / metersPerDegree
+	radiusSquared := radius * radius
+
+	longitudes := make([]float64, Size)
+	for px := range longitudes {
+		longitudes[px] = longitude(z, float64(x*Size+px)+0.5)
+	}
+
+	for py := 0; py < Size; py++ {
+		lat := latitude(z, float64(y*Size+py)+0.5)
+		metersPerLongitude := metersPerDegree * math.Cos(lat*math.Pi/180)
+
+		// Only samples within the radius of this row's latitude can affect its pixels.
+		low := sort.Search(len(sorted), func(i int) bool {
+			return sorted[i].Latitude >= lat-radiusDegrees
+		})
+		high := sort.Search(len(sorted), func(i int) bool {
+			return sorted[i].Latitude > lat+radiusDegrees
+		})
+
+		if low >= high {
+			continue
+		}
+
+		for px, long := range longitudes {
+			var weights, total float64
+
+			for _, s := range sorted[low:high] {
+				dx := (s.Longitude - long) * metersPerLongitude
+				dy := (s.Latitude - lat) * metersPerDegree
+
+				d := dx*dx + dy*dy
+				if d > radiusSquared {
+					continue
+				}
+
+				// A sample right on top of the pixel determines its value by itself.
+				if d < 1 {
+					total, weights = s.AQI, 1
+					break
+				}
+
+				weights += 1 / d
+				total += s.AQI / d
+			}
+
+			if weights == 0 {
+				continue
+			}
+
+			c := utils.AQIIndex(total / weights).Color
+			img.SetNRGBA(px, py, color.NRGBA{R: c.R, G: c.G, B: c.B, A: alpha})
+		}
+	}
+
+	return img
+}
+
+// Encode encodes a tile as a PNG image.
+func Encode(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := encoder.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,123 @@
+// +build unit
+
+package tiles
+
+import (
+	"bytes"
+	"image/png"
+	"math"
+	"testing"
+
+	"github.com/mrflynn/go-aqi"
+)
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		z, x, y int
+		valid   bool
+	}{
+		{MinZoom, 0, 0, true},
+		{MinZoom, 31, 31, true},
+		{MinZoom, 32, 0, false},
+		{MinZoom, 0, -1, false},
+		{MinZoom - 1, 0, 0, false},
+		{MaxZoom + 1, 0, 0, false},
+	}
+
+	for _, test := range tests {
+		if Valid(test.z, test.x, test.y) != test.valid {
+			t.Errorf("%d/%d/%d: expected valid to be %t", test.z, test.x, test.y, test.valid)
+		}
+	}
+}
+
+func TestBounds(t *testing.T) {
+	bounds := Bounds(1, 0, 0)
+
+	if bounds.MinLongitude != -180 || bounds.MaxLongitude != 0 {
+		t.Errorf("got unexpected longitudes %+v", bounds)
+	}
+
+	if math.Abs(bounds.MinLatitude) > 1e-9 || math.Abs(bounds.MaxLatitude-85.0511287798) > 1e-9 {
+		t.Errorf("got unexpected latitudes %+v", bounds)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	bounds := Expand(Bounds(10, 163, 395), 10000)
+	original := Bounds(10, 163, 395)
+
+	if bounds.MinLatitude >= original.MinLatitude || bounds.MaxLongitude <= original.MaxLongitude {
+		t.Errorf("expected %+v to contain %+v", bounds, original)
+	}
+
+	if math.Abs(original.MinLatitude-bounds.MinLatitude-10000/metersPerDegree) > 1e-9 {
+		t.Errorf("got unexpected latitude expansion %+v", bounds)
+	}
+}
+
+func TestRender(t *testing.T) {
+	z, x, y := 10, 163, 395
+	bounds := Bounds(z, x, y)
+
+	// Put a single sample in the center of the tile.
+	samples := []Sample{{
+		Longitude: (bounds.MinLongitude + bounds.MaxLongitude) / 2,
+		Latitude:  latitude(z, float64(y*Size+Size/2)),
+		AQI:       120,
+	}}
+
+	img := Render(z, x, y, samples, 5000)
+
+	center := img.NRGBAAt(Size/2, Size/2)
+	if center.R != aqi.Sensitive.Color.R || center.G != aqi.Sensitive.Color.G || center.A != alpha {
+		t.Errorf("expected center pixel to be colored, got %v", center)
+	}
+
+	// The corners are more than 5 km from the center at this zoom level.
+	if corner := img.NRGBAAt(0, 0); corner.A != 0 {
+		t.Errorf("expected corner pixel to be transparent, got %v", corner)
+	}
+}
+
+func TestRenderInterpolates(t *testing.T) {
+	z, x, y := 10, 163, 395
+	bounds := Bounds(z, x, y)
+	middle := latitude(z, float64(y*Size+Size/2))
+
+	samples := []Sample{
+		{Longitude: bounds.MinLongitude, Latitude: middle, AQI: 20},
+		{Longitude: bounds.MaxLongitude, Latitude: middle, AQI: 180},
+	}
+
+	img := Render(z, x, y, samples, 50000)
+
+	// Halfway between the samples the average AQI of 100 is moderate.
+	if c := img.NRGBAAt(Size/2, Size/2); c.R != aqi.Moderate.Color.R || c.G != aqi.Moderate.Color.G {
+		t.Errorf("expected moderate color, got %v", c)
+	}
+
+	if c := img.NRGBAAt(0, Size/2); c.R != aqi.Good.Color.R || c.G != aqi.Good.Color.G {
+		t.Errorf("expected good color, got %v", c)
+	}
+
+	if c := img.NRGBAAt(Size-1, Size/2); c.R != aqi.Unhealthy.Color.R || c.G != aqi.Unhealthy.Color.G {
+		t.Errorf("expected unhealthy color, got %v", c)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	data, err := Encode(Render(MinZoom, 0, 0, nil, 1000))
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("could not decode tile: %s", err)
+	}
+
+	if img.Bounds().Dx() != Size || img.Bounds().Dy() != Size {
+		t.Errorf("got unexpected tile size %v", img.Bounds())
+	}
+}
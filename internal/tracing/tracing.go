@@ -0,0 +1,161 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/stdout"
+	"go.opentelemetry.io/otel/label"
+	export "go.opentelemetry.io/otel/sdk/export/trace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+)
+
+// instrumentationName identifies the spans created by Air Alert.
+const instrumentationName = "github.com/mrflynn/air-alert"
+
+// Exporters that spans can be sent to.
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+func newExporter(name string) (export.SpanBatcher, func() error, error) {
+	switch name {
+	case ExporterOTLP:
+		opts := []otlp.ExporterOption{otlp.WithAddress(viper.GetString("tracing.endpoint"))}
+		if viper.GetBool("tracing.insecure") {
+			opts = append(opts, otlp.WithInsecure())
+		}
+
+		exporter, err := otlp.NewExporter(opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return exporter, exporter.Stop, nil
+	case ExporterStdout:
+		exporter, err := stdout.NewExporter()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return exporter, func() error { return nil }, nil
+	default:
+		return nil, nil, fmt.Errorf(`unknown tracing exporter "%s"`, name)
+	}
+}
+
+// Init installs the global tracer provider configured in the tracing section of the config. The
+// returned function exports any remaining spans and must be called before the program exits.
+// Nothing is recorded if tracing is disabled.
+func Init() (func(context.Context) error, error) {
+	exporterName := viper.GetString("tracing.exporter")
+	if exporterName == ExporterNone || exporterName == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, stop, err := newExporter(exporterName)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := sdktrace.NewProvider(
+		sdktrace.WithConfig(sdktrace.Config{
+			DefaultSampler: sdktrace.ParentSample(sdktrace.ProbabilitySampler(viper.GetFloat64("tracing.sample_ratio"))),
+		}),
+		sdktrace.WithResource(resource.New(semconv.ServiceNameKey.String(viper.GetString("tracing.service_name")))),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// The provider can't be shut down, so the processor is registered here to be able to flush it
+	// on exit.
+	processor, err := sdktrace.NewBatchSpanProcessor(exporter)
+	if err != nil {
+		return nil, err
+	}
+
+	provider.RegisterSpanProcessor(processor)
+	global.SetTraceProvider(provider)
+
+	return func(ctx context.Context) error {
+		done := make(chan error, 1)
+
+		go func() {
+			// Unregistering the processor exports the spans that are still queued.
+			provider.UnregisterSpanProcessor(processor)
+			done <- stop()
+		}()
+
+		select {
+		case err := <-done:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}, nil
+}
+
+// Start creates a new span as a child of the span in ctx, if there is one.
+func Start(ctx context.Context, name string, opts ...trace.StartOption) (context.Context, trace.Span) {
+	return global.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// End marks the span as failed if err is not nil and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(context.Background(), err, trace.WithErrorStatus(codes.Unknown))
+	}
+
+	span.End()
+}
+
+// carrier stores propagated trace context fields.
+type carrier []label.KeyValue
+
+func (c *carrier) Get(key string) string {
+	for _, kv := range *c {
+		if string(kv.Key) == key {
+			return kv.Value.AsString()
+		}
+	}
+
+	return ""
+}
+
+func (c *carrier) Set(key, value string) {
+	*c = append(*c, label.String(key, value))
+}
+
+// TraceParent returns the W3C traceparent value of the span in ctx, or an empty string if there is
+// no span. It is used to continue a trace in another process.
+func TraceParent(ctx context.Context) string {
+	if !trace.SpanFromContext(ctx).SpanContext().IsValid() {
+		return ""
+	}
+
+	var c carrier
+	trace.TraceContext{}.Inject(ctx, &c)
+
+	return c.Get("traceparent")
+}
+
+// WithTraceParent returns a copy of ctx that continues the trace of a W3C traceparent value. New
+// spans in the returned context are children of the remote span.
+func WithTraceParent(ctx context.Context, traceParent string) context.Context {
+	if traceParent == "" {
+		return ctx
+	}
+
+	c := carrier{label.String("traceparent", traceParent)}
+	return trace.TraceContext{}.Extract(ctx, &c)
+}
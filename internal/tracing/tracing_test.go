@@ -0,0 +1,118 @@
+// +build unit
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/codes"
+	export "go.opentelemetry.io/otel/sdk/export/trace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []*export.SpanData
+}
+
+func (m *memoryExporter) ExportSpan(ctx context.Context, span *export.SpanData) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.spans = append(m.spans, span)
+}
+
+func createTestTracer(t *testing.T) (trace.Tracer, *memoryExporter) {
+	t.Helper()
+
+	m := &memoryExporter{}
+
+	provider, err := sdktrace.NewProvider(sdktrace.WithSyncer(m))
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	return provider.Tracer("test"), m
+}
+
+func TestTraceParent(t *testing.T) {
+	tracer, m := createTestTracer(t)
+
+	if tp := TraceParent(context.Background()); tp != "" {
+		t.Errorf("expected no traceparent without a span, got %s", tp)
+	}
+
+	ctx, parent := tracer.Start(context.Background(), "producer")
+	tp := TraceParent(ctx)
+	parent.End()
+
+	expected := "00-" + parent.SpanContext().TraceID.String() + "-" + parent.SpanContext().SpanID.String() + "-01"
+	if tp != expected {
+		t.Fatalf("expected traceparent %s, got %s", expected, tp)
+	}
+
+	_, child := tracer.Start(WithTraceParent(context.Background(), tp), "consumer")
+	child.End()
+
+	if len(m.spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(m.spans))
+	}
+
+	consumer := m.spans[1]
+	if consumer.SpanContext.TraceID != parent.SpanContext().TraceID || consumer.ParentSpanID != parent.SpanContext().SpanID {
+		t.Errorf("expected consumer to continue the producer trace, got %#v", consumer)
+	}
+
+	if ctx := WithTraceParent(context.Background(), ""); trace.RemoteSpanContextFromContext(ctx).IsValid() {
+		t.Error("expected empty traceparent to be ignored")
+	}
+}
+
+func TestEnd(t *testing.T) {
+	tracer, m := createTestTracer(t)
+
+	_, span := tracer.Start(context.Background(), "failed")
+	End(span, errors.New("unavailable"))
+
+	_, span = tracer.Start(context.Background(), "succeeded")
+	End(span, nil)
+
+	if len(m.spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(m.spans))
+	}
+
+	if failed := m.spans[0]; uint32(failed.StatusCode) != uint32(codes.Unknown) || len(failed.MessageEvents) != 1 {
+		t.Errorf("expected failed span to record the error, got %#v", failed)
+	}
+
+	if succeeded := m.spans[1]; uint32(succeeded.StatusCode) != uint32(codes.OK) || len(succeeded.MessageEvents) != 0 {
+		t.Errorf("expected successful span to have no error, got %#v", succeeded)
+	}
+}
+
+func TestInit(t *testing.T) {
+	defer viper.Reset()
+
+	viper.Set("tracing.exporter", "unknown")
+	if _, err := Init(); err == nil {
+		t.Error("expected error for unknown exporter")
+	}
+
+	for _, exporter := range []string{ExporterNone, ExporterStdout} {
+		viper.Set("tracing.exporter", exporter)
+
+		stop, err := Init()
+		if err != nil {
+			t.Fatalf("%s: got unexpected error: %s", exporter, err)
+		}
+
+		if err := stop(context.Background()); err != nil {
+			t.Errorf("%s: got unexpected error on shutdown: %s", exporter, err)
+		}
+	}
+}
@@ -1,10 +1,16 @@
 package internal
 
 import (
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"math/rand"
 	"reflect"
 	"strings"
 	"time"
+
+	"github.com/mrflynn/go-aqi"
 )
 
 const (
@@ -87,3 +93,39 @@ func CreateRandomString(size int) string {
 func RecalculateAverage(new, avg float64, count int) float64 {
 	return (new + (avg * float64(count))) / float64(count+1)
 }
+
+// CreateToken creates a random, URL-safe secret token from `size` bytes of cryptographically
+// secure random data.
+func CreateToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := crand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashToken returns the hex-encoded SHA-256 digest of a secret token. Tokens are only ever stored
+// in this form.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// aqiIndices are the EPA AQI categories in increasing order of severity.
+var aqiIndices = []aqi.Index{
+	aqi.Good, aqi.Moderate, aqi.Sensitive, aqi.Unhealthy, aqi.VeryUnhealthy, aqi.Hazardous, aqi.VeryHazardous,
+}
+
+// AQIIndex returns the EPA category of an AQI value. Unlike aqi.Calculate, values of zero are
+// also categorized. Values that fall between two categories (e.g. 50.5) are truncated like the
+// EPA does, so they belong to the lower category.
+func AQIIndex(value float64) aqi.Index {
+	for _, index := range aqiIndices {
+		if value < float64(index.High)+1 {
+			return index
+		}
+	}
+
+	return aqi.VeryHazardous
+}
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/mrflynn/go-aqi"
 )
 
 var floatComparer = cmp.Comparer(func(x, y float64) bool {
@@ -124,3 +125,44 @@ func TestRecalculateAverage(t *testing.T) {
 		t.Errorf("expected average to be %f, got %f", avgExpected, avgComputed)
 	}
 }
+
+func TestCreateToken(t *testing.T) {
+	token, err := CreateToken(32)
+	if err != nil {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	if size := len(token); size != 43 {
+		t.Errorf("Expected token to be 43 bytes, got %d bytes", size)
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	expected := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	if hash := HashToken("hello"); hash != expected {
+		t.Errorf("expected hash to be %s, got %s", expected, hash)
+	}
+}
+
+func TestAQIIndex(t *testing.T) {
+	tests := []struct {
+		value float64
+		index aqi.Index
+	}{
+		{0, aqi.Good},
+		{50, aqi.Good},
+		{50.5, aqi.Good},
+		{51, aqi.Moderate},
+		{101, aqi.Sensitive},
+		{250, aqi.VeryUnhealthy},
+		{350, aqi.Hazardous},
+		{800, aqi.VeryHazardous},
+	}
+
+	for _, test := range tests {
+		if index := AQIIndex(test.value); index != test.index {
+			t.Errorf("%v: expected %s, got %s", test.value, test.index.Name, index.Name)
+		}
+	}
+}
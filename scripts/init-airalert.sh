@@ -26,4 +26,12 @@ PGPASSWORD="$AIR_ALERT_PGPASS" psql -v ON_ERROR_STOP=1 --username airalert --dbn
     threshold DOUBLE PRECISION NOT NULL,
     last_crossover TIMESTAMP WITH TIME ZONE
   );
+
+  CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL NOT NULL PRIMARY KEY,
+    name TEXT NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE,
+    rate_limit INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+  );
 EOSQL
\ No newline at end of file
@@ -28,10 +28,21 @@ function getPosition(callback) {
 }
 
 function getAQI(latitude, longitude) {
-  fetch(`/aqi/${latitude}/${longitude}`)
-    .then(resp => resp.text())
-    .then(aqi => updateDisplayBox(aqi))
-    .catch(err => console.error(err));
+  // Browsers without server-sent events only get the AQI once.
+  if (!window.EventSource) {
+    fetch(`/aqi/${latitude}/${longitude}`)
+      .then(resp => resp.text())
+      .then(aqi => updateDisplayBox(aqi))
+      .catch(err => console.error(err));
+
+    return;
+  }
+
+  // The stream sends the current AQI right away and again whenever new data is available. The
+  // browser reconnects by itself if the connection drops.
+  let events = new EventSource(`/aqi/${latitude}/${longitude}/events`);
+  events.addEventListener('aqi', e => updateDisplayBox(JSON.parse(e.data).aqi));
+  events.onerror = err => console.error(err);
 }
 
 function updateDisplayBox(aqi) {